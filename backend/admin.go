@@ -0,0 +1,403 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ==========================================
+// 管理接口：房间运维操作与审计日志
+// 所有写操作均为 POST + JSON 请求体，结果统一返回 adminResult
+// ==========================================
+
+func registerAdminRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("/api/admin/status", requireAdmin(handleAdminStatus))
+	mux.HandleFunc("/api/admin/rooms/close", requireAdmin(adminPost("close_room", handleAdminCloseRoom)))
+	mux.HandleFunc("/api/admin/rooms/kick", requireAdmin(adminPost("kick_player", handleAdminKick)))
+	mux.HandleFunc("/api/admin/rooms/end-round", requireAdmin(adminPost("end_round", handleAdminEndRound)))
+	mux.HandleFunc("/api/admin/rooms/pause", requireAdmin(adminPost("pause_room", handleAdminPause)))
+	mux.HandleFunc("/api/admin/rooms/resume", requireAdmin(adminPost("resume_room", handleAdminResume)))
+	mux.HandleFunc("/api/admin/announce", requireAdmin(adminPost("announce", handleAdminAnnounce)))
+	mux.HandleFunc("/api/admin/room-cap", requireAdmin(adminPost("set_room_cap", handleAdminRoomCap)))
+	mux.HandleFunc("/api/admin/catalog/reload", requireAdmin(adminPost("reload_catalog", handleAdminReloadCatalog)))
+	mux.HandleFunc("/api/admin/packs/delete", requireAdmin(adminPost("delete_pack", handleAdminDeletePack)))
+	mux.HandleFunc("/api/admin/audit", requireAdmin(handleAdminAudit))
+	mux.HandleFunc("/api/admin/events", requireAdmin(handleAdminEvents))
+}
+
+// adminRequest 是各管理操作共用的请求体
+type adminRequest struct {
+	RoomID   string `json:"roomId"`
+	PlayerID string `json:"playerId"`
+	Ban      bool   `json:"ban"`
+	Reason   string `json:"reason"`
+	Message  string `json:"message"`
+	MaxRooms int    `json:"maxRooms"`
+	PackID   string `json:"packId"`
+}
+
+type adminResult struct {
+	OK      bool   `json:"ok"`
+	Message string `json:"message"`
+}
+
+// adminActionFunc 执行一次管理操作，返回审计目标与结果说明
+type adminActionFunc func(req adminRequest) (target string, message string, err error)
+
+// adminPost 统一处理请求解析、响应与审计日志
+func adminPost(action string, fn adminActionFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var req adminRequest
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&req); err != nil {
+			writeAdminResult(w, http.StatusBadRequest, adminResult{Message: "请求体不是有效的 JSON"})
+			return
+		}
+		req.RoomID = normalizeRoomID(req.RoomID)
+
+		target, message, err := fn(req)
+		entry := auditEntry{
+			Time:   time.Now().Format("2006-01-02 15:04:05"),
+			Actor:  adminActor(r),
+			Action: action,
+			Target: target,
+			OK:     err == nil,
+		}
+		defer func() {
+			events.publish(evAdminAction, req.RoomID, map[string]interface{}{
+				"action": action,
+				"actor":  entry.Actor,
+				"target": entry.Target,
+				"ok":     entry.OK,
+				"detail": entry.Detail,
+			})
+		}()
+		if err != nil {
+			entry.Detail = err.Error()
+			audit.record(entry)
+			status := http.StatusBadRequest
+			var nf *adminNotFound
+			if errors.As(err, &nf) {
+				status = http.StatusNotFound
+			}
+			writeAdminResult(w, status, adminResult{Message: err.Error()})
+			return
+		}
+		entry.Detail = message
+		audit.record(entry)
+		writeAdminResult(w, http.StatusOK, adminResult{OK: true, Message: message})
+	}
+}
+
+func writeAdminResult(w http.ResponseWriter, status int, res adminResult) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	enc.Encode(res)
+}
+
+// adminNotFound 表示操作的房间或玩家不存在，对应 HTTP 404
+type adminNotFound struct{ msg string }
+
+func (e *adminNotFound) Error() string { return e.msg }
+
+func notFoundf(format string, args ...interface{}) error {
+	return &adminNotFound{msg: fmt.Sprintf(format, args...)}
+}
+
+func lookupRoom(roomID string) (*Room, error) {
+	globalMutex.Lock()
+	room, exists := rooms[roomID]
+	globalMutex.Unlock()
+	if !exists {
+		return nil, notFoundf("房间 [%s] 不存在", roomID)
+	}
+	return room, nil
+}
+
+func handleAdminCloseRoom(req adminRequest) (string, string, error) {
+	room, err := lookupRoom(req.RoomID)
+	if err != nil {
+		return req.RoomID, "", err
+	}
+	reason := req.Reason
+	if reason == "" {
+		reason = "房间已被管理员关闭。"
+	}
+	closeRoom(room, reason)
+	return room.ID, fmt.Sprintf("房间 [%s] 已关闭", room.ID), nil
+}
+
+// closeRoom 立即销毁房间：从房间表移除、停止计时并断开所有玩家
+func closeRoom(room *Room, reason string) {
+	globalMutex.Lock()
+	owned := rooms[room.ID] == room
+	if owned {
+		delete(rooms, room.ID)
+	}
+	globalMutex.Unlock()
+	if owned {
+		cluster.releaseRoom(room.ID)
+	}
+
+	room.Mutex.Lock()
+	defer room.Mutex.Unlock()
+	room.RoundState = "ended"
+	room.Paused = false
+	cancelRoomTimer(room)
+
+	closedMsg := WsMessage{
+		Type:    "room_closed",
+		Payload: map[string]interface{}{"message": reason},
+	}
+	cBytes, _ := json.Marshal(closedMsg)
+	for _, p := range room.Players {
+		p.send(cBytes)
+		p.close()
+	}
+	fmt.Printf("房间 [%s] 被关闭: %s\n", room.ID, reason)
+	if owned {
+		events.publish(evRoomDestroyed, room.ID, map[string]interface{}{"reason": reason})
+	}
+}
+
+func handleAdminKick(req adminRequest) (string, string, error) {
+	target := req.RoomID + "/" + req.PlayerID
+	room, err := lookupRoom(req.RoomID)
+	if err != nil {
+		return target, "", err
+	}
+	room.Mutex.Lock()
+	defer room.Mutex.Unlock()
+	if req.Ban {
+		room.Banned[req.PlayerID] = true
+	}
+	player, inRoom := room.Players[req.PlayerID]
+	if !inRoom {
+		if req.Ban {
+			return target, "玩家不在房间中，已加入封禁名单", nil
+		}
+		return target, "", notFoundf("玩家 [%s] 不在房间 [%s] 中", req.PlayerID, room.ID)
+	}
+	reason := "你已被管理员移出房间。"
+	if req.Ban {
+		reason = "你已被管理员封禁，无法再加入该房间。"
+	}
+	kickPlayerLocked(room, player, reason)
+	return target, fmt.Sprintf("玩家 [%s] 已被移出房间 [%s]", player.Name, room.ID), nil
+}
+
+func handleAdminEndRound(req adminRequest) (string, string, error) {
+	room, err := lookupRoom(req.RoomID)
+	if err != nil {
+		return req.RoomID, "", err
+	}
+	room.Mutex.Lock()
+	defer room.Mutex.Unlock()
+	switch room.RoundState {
+	case "preparing", "countdown", "playing":
+	default:
+		return room.ID, "", fmt.Errorf("房间 [%s] 当前没有进行中的回合", room.ID)
+	}
+	if room.CurrentSong == nil {
+		return room.ID, "", fmt.Errorf("房间 [%s] 当前没有进行中的回合", room.ID)
+	}
+	round := room.CurrentRound
+	room.Paused = false
+	endRound(room, "本局已被管理员结束。", false, false)
+	return room.ID, fmt.Sprintf("房间 [%s] 第 %d 局已结束", room.ID, round), nil
+}
+
+func handleAdminPause(req adminRequest) (string, string, error) {
+	room, err := lookupRoom(req.RoomID)
+	if err != nil {
+		return req.RoomID, "", err
+	}
+	room.Mutex.Lock()
+	defer room.Mutex.Unlock()
+	if room.Paused {
+		return room.ID, "", fmt.Errorf("房间 [%s] 已处于暂停状态", room.ID)
+	}
+	if room.TimerFire == nil {
+		return room.ID, "", fmt.Errorf("房间 [%s] 当前没有运行中的计时", room.ID)
+	}
+
+	remaining := time.Until(room.TimerDeadline)
+	if remaining < 0 {
+		remaining = 0
+	}
+	// 只停掉计时协程，保留 TimerFire 供恢复时重新挂上
+	if room.TimerCancel != nil {
+		close(room.TimerCancel)
+		room.TimerCancel = nil
+	}
+	room.Paused = true
+	room.PausedRemaining = remaining
+
+	pauseMsg := WsMessage{
+		Type:    "room_paused",
+		Payload: map[string]interface{}{"roundState": room.RoundState},
+	}
+	pBytes, _ := json.Marshal(pauseMsg)
+	for _, p := range room.Players {
+		p.send(pBytes)
+	}
+	postSystemMessageLocked(room, "房间已被管理员暂停。")
+	return room.ID, fmt.Sprintf("房间 [%s] 已暂停 (剩余 %v)", room.ID, remaining.Round(time.Millisecond)), nil
+}
+
+func handleAdminResume(req adminRequest) (string, string, error) {
+	room, err := lookupRoom(req.RoomID)
+	if err != nil {
+		return req.RoomID, "", err
+	}
+	room.Mutex.Lock()
+	defer room.Mutex.Unlock()
+	if !room.Paused {
+		return room.ID, "", fmt.Errorf("房间 [%s] 未处于暂停状态", room.ID)
+	}
+	room.Paused = false
+	if room.TimerFire != nil {
+		armRoomTimer(room, room.PausedRemaining, room.TimerFire)
+	}
+
+	resumeMsg := WsMessage{
+		Type: "room_resumed",
+		Payload: map[string]interface{}{
+			"roundState":  room.RoundState,
+			"remainingMs": room.PausedRemaining.Milliseconds(),
+		},
+	}
+	rBytes, _ := json.Marshal(resumeMsg)
+	for _, p := range room.Players {
+		p.send(rBytes)
+	}
+	postSystemMessageLocked(room, "房间已恢复。")
+	return room.ID, fmt.Sprintf("房间 [%s] 已恢复", room.ID), nil
+}
+
+func handleAdminAnnounce(req adminRequest) (string, string, error) {
+	text := sanitizeChat(req.Message)
+	if text == "" {
+		return "*", "", errors.New("公告内容不能为空")
+	}
+
+	globalMutex.Lock()
+	roomList := make([]*Room, 0, len(rooms))
+	for _, room := range rooms {
+		roomList = append(roomList, room)
+	}
+	globalMutex.Unlock()
+
+	for _, room := range roomList {
+		postSystemMessage(room, "📢 服务器公告: "+text)
+	}
+	return "*", fmt.Sprintf("公告已发送到 %d 个房间", len(roomList)), nil
+}
+
+func handleAdminRoomCap(req adminRequest) (string, string, error) {
+	if req.MaxRooms < 1 || req.MaxRooms > 1000 {
+		return "", "", errors.New("房间上限需在 1 到 1000 之间")
+	}
+	globalMutex.Lock()
+	old := maxRooms
+	maxRooms = req.MaxRooms
+	current := len(rooms)
+	globalMutex.Unlock()
+	return "room_cap", fmt.Sprintf("房间上限 %d -> %d (当前 %d 个房间)", old, req.MaxRooms, current), nil
+}
+
+func handleAdminReloadCatalog(req adminRequest) (string, string, error) {
+	sizes, err := reloadCatalog()
+	if err != nil {
+		return "catalog", "", err
+	}
+	return "catalog", "题库已重新加载: " + formatCatalogSizes(sizes), nil
+}
+
+// handleAdminDeletePack 删除违规或不再需要的曲包，正被房间使用时需先关闭房间
+func handleAdminDeletePack(req adminRequest) (string, string, error) {
+	target := packModePrefix + req.PackID
+	if err := deletePack(req.PackID); err != nil {
+		return target, "", err
+	}
+	return target, fmt.Sprintf("曲包 [%s] 已删除", req.PackID), nil
+}
+
+// ==========================================
+// 审计日志：内存中保留最近的记录，同时追加写入文件 (JSON Lines)
+// ==========================================
+
+type auditEntry struct {
+	Time   string `json:"time"`
+	Actor  string `json:"actor"`
+	Action string `json:"action"`
+	Target string `json:"target,omitempty"`
+	Detail string `json:"detail,omitempty"`
+	OK     bool   `json:"ok"`
+}
+
+const auditMemorySize = 200
+
+type auditLog struct {
+	mu      sync.Mutex
+	path    string
+	entries []auditEntry
+}
+
+var audit = &auditLog{path: "admin_audit.log"}
+
+func (a *auditLog) record(e auditEntry) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.entries = append(a.entries, e)
+	if len(a.entries) > auditMemorySize {
+		a.entries = a.entries[len(a.entries)-auditMemorySize:]
+	}
+	fmt.Printf("[审计] %s %s %s %s ok=%v %s\n", e.Time, e.Actor, e.Action, e.Target, e.OK, e.Detail)
+
+	if a.path == "" {
+		return
+	}
+	f, err := os.OpenFile(a.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o640)
+	if err != nil {
+		fmt.Println("警告: 写入审计日志失败:", err)
+		return
+	}
+	defer f.Close()
+	line, _ := json.Marshal(e)
+	f.Write(append(line, '\n'))
+}
+
+func (a *auditLog) recent() []auditEntry {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	out := make([]auditEntry, len(a.entries))
+	copy(out, a.entries)
+	return out
+}
+
+// handleAdminAudit 返回最近的审计记录 (GET /api/admin/audit?action=close_room)
+func handleAdminAudit(w http.ResponseWriter, r *http.Request) {
+	action := strings.TrimSpace(r.URL.Query().Get("action"))
+	entries := make([]auditEntry, 0)
+	for _, e := range audit.recent() {
+		if action == "" || e.Action == action {
+			entries = append(entries, e)
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(map[string]interface{}{"entries": entries})
+}
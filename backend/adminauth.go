@@ -0,0 +1,240 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// ==========================================
+// 管理接口的监听与认证
+// 管理接口可以挂在独立的 TCP 端口或 Unix socket 上，
+// 认证方式为 Bearer Token 或 mTLS 客户端证书
+// ==========================================
+
+// adminConfig 对应启动参数
+type adminConfig struct {
+	Listen     string // 空: 与游戏服务共用端口；"unix:/path" 或 "host:port": 独立监听
+	TokensFile string // 每行 "名称 令牌"
+	TLSCert    string
+	TLSKey     string
+	ClientCA   string // 配置后要求客户端证书 (mTLS)
+	AllowLocal bool   // 未配置凭据时是否允许本机直连免认证
+}
+
+type adminTokenEntry struct {
+	name  string
+	token []byte
+}
+
+var (
+	adminTokens []adminTokenEntry
+	// 独立监听且启用了 mTLS 时为 true
+	adminMTLS bool
+	// 对应 -admin-allow-local；默认关闭，未配置凭据的 TCP 管理请求一律拒绝
+	adminAllowLocal bool
+)
+
+type adminCtxKey int
+
+const (
+	ctxAdminActor adminCtxKey = iota
+	ctxAdminUnix
+)
+
+// loadAdminTokens 读取令牌文件；环境变量 METAGARUTA_ADMIN_TOKEN 额外提供一个名为 env 的令牌
+func loadAdminTokens(path string) error {
+	adminTokens = nil
+	if t := os.Getenv("METAGARUTA_ADMIN_TOKEN"); t != "" {
+		adminTokens = append(adminTokens, adminTokenEntry{name: "env", token: []byte(t)})
+	}
+	if path == "" {
+		return nil
+	}
+	file, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(file))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		entry := adminTokenEntry{name: fmt.Sprintf("token#%d", n), token: []byte(fields[0])}
+		if len(fields) >= 2 {
+			entry.name, entry.token = fields[0], []byte(fields[1])
+		}
+		if len(entry.token) < 16 {
+			return fmt.Errorf("%s 第 %d 行: 令牌长度至少 16 个字符", path, n)
+		}
+		adminTokens = append(adminTokens, entry)
+	}
+	return scanner.Err()
+}
+
+// matchAdminToken 常量时间比较所有令牌，返回匹配的令牌名称
+func matchAdminToken(token string) (string, bool) {
+	name, ok := "", false
+	for _, e := range adminTokens {
+		if subtle.ConstantTimeCompare([]byte(token), e.token) == 1 {
+			name, ok = e.name, true
+		}
+	}
+	return name, ok
+}
+
+var errAdminUnauthorized = errors.New("unauthorized")
+
+// authenticateAdmin 识别管理请求的操作者
+// 优先级：mTLS 客户端证书 > Bearer Token > Unix socket / 本机直连（仅在未配置任何凭据时，本机直连还需 -admin-allow-local）
+func authenticateAdmin(r *http.Request) (string, error) {
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 && len(r.TLS.VerifiedChains[0]) > 0 {
+		return "cert:" + r.TLS.VerifiedChains[0][0].Subject.CommonName, nil
+	}
+	if auth := r.Header.Get("Authorization"); auth != "" {
+		token, found := strings.CutPrefix(auth, "Bearer ")
+		if !found {
+			return "", errAdminUnauthorized
+		}
+		if name, ok := matchAdminToken(strings.TrimSpace(token)); ok {
+			return "token:" + name, nil
+		}
+		return "", errAdminUnauthorized
+	}
+	if len(adminTokens) > 0 || adminMTLS {
+		return "", errAdminUnauthorized
+	}
+
+	// 未配置凭据时的兼容模式
+	if via, _ := r.Context().Value(ctxAdminUnix).(bool); via {
+		return "unix", nil
+	}
+	if !adminAllowLocal {
+		return "", errAdminUnauthorized
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return "", errAdminUnauthorized
+	}
+	// 经反向代理转发来的请求以真实客户端 IP 判断，避免 nginx 后面所有请求都像是本机发出的
+	ip := net.ParseIP(clientIP(r))
+	if ip != nil && ip.IsLoopback() && net.ParseIP(host).IsLoopback() {
+		return "local:" + ip.String(), nil
+	}
+	return "", errAdminUnauthorized
+}
+
+// requireAdmin 为管理接口加上认证，并把操作者写入请求上下文供审计使用
+func requireAdmin(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		actor, err := authenticateAdmin(r)
+		if err != nil {
+			fmt.Printf("拒绝管理请求: %s %s 来自 %s\n", r.Method, r.URL.Path, clientIP(r))
+			w.Header().Set("WWW-Authenticate", `Bearer realm="metagaruta-admin"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		h(w, r.WithContext(context.WithValue(r.Context(), ctxAdminActor, actor)))
+	}
+}
+
+// adminActor 审计日志中记录的操作者
+func adminActor(r *http.Request) string {
+	if actor, ok := r.Context().Value(ctxAdminActor).(string); ok {
+		return actor + "@" + clientIP(r)
+	}
+	return clientIP(r)
+}
+
+// setupAdmin 注册管理接口；配置了独立监听地址时在后台启动管理服务
+func setupAdmin(cfg adminConfig) error {
+	if err := loadAdminTokens(cfg.TokensFile); err != nil {
+		return fmt.Errorf("加载管理令牌失败: %w", err)
+	}
+	adminAllowLocal = cfg.AllowLocal
+
+	if cfg.Listen == "" {
+		if cfg.TLSCert != "" || cfg.ClientCA != "" {
+			return errors.New("mTLS 需要配合 -admin-listen 使用独立监听")
+		}
+		registerAdminRoutes(http.DefaultServeMux)
+		if len(adminTokens) == 0 {
+			if adminAllowLocal {
+				fmt.Println("警告: 管理接口与游戏服务共用端口且未配置令牌，本机直连可免认证访问")
+			} else {
+				fmt.Println("警告: 未配置管理令牌，管理接口拒绝所有请求 (可配置 -admin-tokens、使用 -admin-listen unix:... 或加 -admin-allow-local)")
+			}
+		}
+		return nil
+	}
+
+	mux := http.NewServeMux()
+	registerAdminRoutes(mux)
+	srv := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+
+	var ln net.Listener
+	var err error
+	if path, ok := strings.CutPrefix(cfg.Listen, "unix:"); ok {
+		if cfg.TLSCert != "" {
+			return errors.New("Unix socket 上不支持 TLS")
+		}
+		os.Remove(path)
+		if ln, err = net.Listen("unix", path); err != nil {
+			return err
+		}
+		// 仅属主与同组用户可连接，文件权限即为访问控制，设置失败时不能继续监听
+		if err := os.Chmod(path, 0o660); err != nil {
+			ln.Close()
+			return fmt.Errorf("设置管理 socket 权限失败: %w", err)
+		}
+		srv.ConnContext = func(ctx context.Context, c net.Conn) context.Context {
+			return context.WithValue(ctx, ctxAdminUnix, true)
+		}
+	} else if ln, err = net.Listen("tcp", cfg.Listen); err != nil {
+		return err
+	}
+
+	if cfg.TLSCert != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.TLSCert, cfg.TLSKey)
+		if err != nil {
+			return fmt.Errorf("加载管理接口证书失败: %w", err)
+		}
+		tlsCfg := &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+		if cfg.ClientCA != "" {
+			caPEM, err := os.ReadFile(cfg.ClientCA)
+			if err != nil {
+				return fmt.Errorf("读取客户端 CA 失败: %w", err)
+			}
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(caPEM) {
+				return errors.New("客户端 CA 文件中没有有效证书")
+			}
+			tlsCfg.ClientCAs = pool
+			tlsCfg.ClientAuth = tls.RequireAndVerifyClientCert
+			adminMTLS = true
+		}
+		ln = tls.NewListener(ln, tlsCfg)
+	} else if cfg.ClientCA != "" {
+		return errors.New("启用 mTLS 需要同时配置 -admin-tls-cert 与 -admin-tls-key")
+	}
+
+	go func() {
+		if err := srv.Serve(ln); err != nil {
+			fmt.Println("管理服务异常退出:", err)
+		}
+	}()
+	fmt.Printf("管理接口已在 %s 上启动 (令牌 %d 个, mTLS %v)\n", cfg.Listen, len(adminTokens), adminMTLS)
+	return nil
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ==========================================
+// 题目音频的格式协商与响度统一
+// 题库音频可以是任意 ffmpeg 能解码的格式 (按 audioSourceTypes 的顺序查找 {songId}.{ext})；
+// 播放时按客户端声明的格式 (formats 参数) 或 Accept 头在 Opus/WebM、AAC/MP4、MP3 中选择，
+// 转码的同时用 loudnorm 统一响度，生成的变体缓存在 audioCacheDir
+// 启动与重新加载题库后在后台预先生成首选格式的变体；播放时变体尚未生成且客户端能直接播放源文件的，
+// 先输出源文件并在后台生成，不让玩家等待转码
+// 没有 ffmpeg 或所有格式都编码失败时直接输出源文件
+// ==========================================
+
+var (
+	audioCacheDir = "audio-cache"
+	// 目标响度 (LUFS)，0 表示不做响度统一
+	audioLoudness = -16.0
+)
+
+type audioFormat struct {
+	name  string // formats 参数中使用的名称
+	ext   string
+	ctype string
+	args  []string // ffmpeg 编码参数
+}
+
+var (
+	audioOpus = audioFormat{"opus", ".webm", "audio/webm", []string{"-c:a", "libopus", "-b:a", "96k", "-f", "webm"}}
+	audioAAC  = audioFormat{"aac", ".m4a", "audio/mp4", []string{"-c:a", "aac", "-b:a", "128k", "-movflags", "+faststart", "-f", "mp4"}}
+	audioMP3  = audioFormat{"mp3", ".mp3", "audio/mpeg", []string{"-c:a", "libmp3lame", "-b:a", "128k", "-f", "mp3"}}
+)
+
+// 客户端同样支持时的偏好顺序：同等音质下 Opus 体积最小，MP3 兼容性最好但放在最后
+var audioFormats = []audioFormat{audioOpus, audioAAC, audioMP3}
+
+// 题库音频可用的扩展名、Content-Type 与裁剪片段时使用的 ffmpeg 封装格式，按查找顺序排列
+var audioSourceTypes = []struct{ ext, ctype, muxer string }{
+	{".m4a", "audio/mp4", "mp4"},
+	{".ogg", "audio/ogg", "ogg"},
+	{".opus", "audio/ogg", "ogg"},
+	{".webm", "audio/webm", "webm"},
+	{".mp3", "audio/mpeg", "mp3"},
+	{".aac", "audio/aac", "adts"},
+	{".flac", "audio/flac", "flac"},
+	{".wav", "audio/wav", "wav"},
+}
+
+// findAudioSource 在 dir 下查找 {id}.{ext} 形式的源音频；都不存在时返回 fallback 扩展名的路径，
+// 由调用方报告文件缺失
+func findAudioSource(dir, id, fallback string) (string, string) {
+	ctype := ""
+	for _, t := range audioSourceTypes {
+		p := filepath.Join(dir, id+t.ext)
+		if _, err := os.Stat(p); err == nil {
+			return p, t.ctype
+		}
+		if t.ext == fallback {
+			ctype = t.ctype
+		}
+	}
+	return filepath.Join(dir, id+fallback), ctype
+}
+
+// 同时进行的音频转码数
+var audioSlots = make(chan struct{}, 2)
+
+var audioBuilds = newVariantBuilds()
+
+// ffmpeg 缺少编码器的格式，之后不再尝试
+var audioEncoderBroken sync.Map
+
+func audioEncoderUsable(f audioFormat) bool {
+	if _, broken := audioEncoderBroken.Load(f.name); broken {
+		return false
+	}
+	return haveFFmpeg()
+}
+
+// negotiateAudioFormats 返回客户端能播放的格式，按优先级排序
+// 前端用 canPlayType 检测后通过 formats 参数声明 (如 "opus,aac,mp3")，以此为准；
+// 没有声明时看 Accept 头中明确列出的类型，并以 MP3 兜底
+func negotiateAudioFormats(r *http.Request) []audioFormat {
+	if declared := r.URL.Query().Get("formats"); declared != "" {
+		names := make(map[string]bool)
+		for _, name := range strings.Split(declared, ",") {
+			names[strings.ToLower(strings.TrimSpace(name))] = true
+		}
+		var out []audioFormat
+		for _, f := range audioFormats {
+			if names[f.name] {
+				out = append(out, f)
+			}
+		}
+		return out
+	}
+
+	accept := r.Header.Get("Accept")
+	type candidate struct {
+		f audioFormat
+		q float64
+	}
+	var cands []candidate
+	for _, f := range audioFormats {
+		if q := acceptQuality(accept, f.ctype); q > 0 {
+			cands = append(cands, candidate{f, q})
+		}
+	}
+	sort.SliceStable(cands, func(i, j int) bool { return cands[i].q > cands[j].q })
+	out := make([]audioFormat, 0, len(cands)+1)
+	for _, c := range cands {
+		out = append(out, c.f)
+	}
+	for _, f := range out {
+		if f.name == audioMP3.name {
+			return out
+		}
+	}
+	return append(out, audioMP3)
+}
+
+// resolveAudioVariant 按协商结果返回要发送的文件 (转码变体或源文件本身) 及其 Content-Type
+// 变体尚未生成时：wait 为 true 或客户端无法直接播放源文件则当场转码；否则先返回源文件，变体在后台生成
+func resolveAudioVariant(r *http.Request, srcPath, srcType string, wait bool) (string, string, error) {
+	st, err := os.Stat(srcPath)
+	if err != nil {
+		return "", "", err
+	}
+
+	formats := negotiateAudioFormats(r)
+	playable := false
+	for _, f := range formats {
+		if f.ctype == srcType {
+			playable = true
+			break
+		}
+	}
+	if audioLoudness == 0 && playable {
+		// 不统一响度时，客户端能直接播放的源文件无需转码
+		formats = nil
+	}
+	for _, f := range formats {
+		if !audioEncoderUsable(f) {
+			continue
+		}
+		out := audioVariantPath(srcPath, st, f)
+		if _, err := os.Stat(out); err != nil && !wait && playable {
+			go tryAudioVariant(srcPath, st, f)
+			return srcPath, srcType, nil
+		}
+		if err := tryAudioVariant(srcPath, st, f); err != nil {
+			continue
+		}
+		return out, f.ctype, nil
+	}
+	return srcPath, srcType, nil
+}
+
+// tryAudioVariant 生成变体并记录失败；缺少编码器时之后不再尝试该格式，
+// 其它错误只说明这个文件转不了
+func tryAudioVariant(srcPath string, st os.FileInfo, f audioFormat) error {
+	err := buildAudioVariant(srcPath, st, f)
+	if err != nil {
+		fmt.Printf("音频转码失败 %s (%s): %v\n", srcPath, f.name, err)
+		if errors.Is(err, errEncoderMissing) {
+			audioEncoderBroken.Store(f.name, true)
+		}
+	}
+	return err
+}
+
+// audioVariantPath 返回变体在缓存中的路径
+// 缓存键包含源文件的路径、大小、修改时间、目标格式与目标响度，源文件或配置变化后自动重新生成
+func audioVariantPath(srcPath string, st os.FileInfo, f audioFormat) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s|%d|%d|%s|%g", srcPath, st.Size(), st.ModTime().UnixNano(), f.name, audioLoudness)
+	return filepath.Join(audioCacheDir, hex.EncodeToString(h.Sum(nil)[:16])+f.ext)
+}
+
+// buildAudioVariant 在缓存中没有变体时生成
+func buildAudioVariant(srcPath string, st os.FileInfo, f audioFormat) error {
+	out := audioVariantPath(srcPath, st, f)
+	key := strings.TrimSuffix(filepath.Base(out), f.ext)
+
+	return audioBuilds.build(out, func() error {
+		audioSlots <- struct{}{}
+		defer func() { <-audioSlots }()
+
+		start := time.Now()
+		if err := os.MkdirAll(audioCacheDir, 0755); err != nil {
+			return err
+		}
+		tmp := filepath.Join(audioCacheDir, ".tmp-"+key+f.ext)
+		defer os.Remove(tmp)
+		if err := transcodeAudio(srcPath, tmp, f, audioLoudness); err != nil {
+			return err
+		}
+		if err := os.Rename(tmp, out); err != nil {
+			return err
+		}
+		fmt.Printf("已生成音频变体 %s (%s)，耗时 %v\n", srcPath, f.name, time.Since(start).Round(time.Millisecond))
+		return nil
+	})
+}
+
+// prewarmAudioVariants 在后台为各模式题库中的所有曲目生成首选格式 (第一个可用的编码) 的变体；
+// 与按需转码共用 audioSlots 与 audioBuilds，不会重复生成，也不会占满转码并发
+func prewarmAudioVariants() {
+	if !haveFFmpeg() {
+		return
+	}
+	go func() {
+		start := time.Now()
+		count := 0
+		for _, m := range allModes() {
+			pool := m.BuildPool(0, nil)
+			for i := range pool {
+				pool[i].TrackSeed = 0 // 预热全部曲目，而不只是这次随机选中的子集
+			}
+			for _, s := range expandTracks(m, pool, nil) {
+				// 中途发现缺少编码器时改用下一种格式
+				f, ok := preferredAudioFormat()
+				if !ok {
+					return
+				}
+				srcPath, srcType := m.AudioAsset(s)
+				if audioLoudness == 0 && srcType == f.ctype {
+					continue
+				}
+				st, err := os.Stat(srcPath)
+				if err != nil {
+					continue
+				}
+				if tryAudioVariant(srcPath, st, f) == nil {
+					count++
+				}
+			}
+		}
+		fmt.Printf("音频变体预生成完成: %d 首，耗时 %v\n", count, time.Since(start).Round(time.Second))
+	}()
+}
+
+// preferredAudioFormat 返回第一个可用的编码格式
+func preferredAudioFormat() (audioFormat, bool) {
+	for _, f := range audioFormats {
+		if audioEncoderUsable(f) {
+			return f, true
+		}
+	}
+	return audioFormat{}, false
+}
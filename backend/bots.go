@@ -0,0 +1,205 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// ==========================================
+// 房间内的电脑玩家
+// 房主可以添加不同难度的电脑对手陪练，电脑玩家没有 WebSocket 连接，
+// 由服务端定时器直接调用与真人相同的 client_ready / buzz / no_song 逻辑
+// 电脑玩家不计入排名统计，也不会继任房主
+// ==========================================
+
+// botDifficulty 决定电脑玩家的反应速度与判断能力
+type botDifficulty struct {
+	Key   string
+	Label string
+	// 反应时间服从对数正态分布
+	ReactionMean   time.Duration
+	ReactionStddev time.Duration
+	// 歌曲在场上时抢对牌的概率
+	Accuracy float64
+	// 歌曲不在场上时正确喊出"没有这首歌"的概率
+	NoSongAccuracy float64
+}
+
+var botDifficulties = map[string]botDifficulty{
+	"easy": {
+		Key: "easy", Label: "简单",
+		ReactionMean: 12 * time.Second, ReactionStddev: 5 * time.Second,
+		Accuracy: 0.45, NoSongAccuracy: 0.35,
+	},
+	"normal": {
+		Key: "normal", Label: "普通",
+		ReactionMean: 7 * time.Second, ReactionStddev: 3 * time.Second,
+		Accuracy: 0.7, NoSongAccuracy: 0.6,
+	},
+	"hard": {
+		Key: "hard", Label: "困难",
+		ReactionMean: 4 * time.Second, ReactionStddev: 1500 * time.Millisecond,
+		Accuracy: 0.9, NoSongAccuracy: 0.85,
+	},
+}
+
+// reactionTime 按难度抽样一次反应时间
+func (d *botDifficulty) reactionTime() time.Duration {
+	mean, sd := float64(d.ReactionMean), float64(d.ReactionStddev)
+	sigma2 := math.Log(1 + (sd*sd)/(mean*mean))
+	mu := math.Log(mean) - sigma2/2
+	v := math.Exp(mu + math.Sqrt(sigma2)*rand.NormFloat64())
+	// 每局最多 45 秒，留出余量
+	return time.Duration(math.Min(math.Max(v, float64(800*time.Millisecond)), float64(40*time.Second)))
+}
+
+// handleBotCommand 处理房主的 add_bot / remove_bot 消息
+func handleBotCommand(room *Room, player *Player, action string, payload map[string]interface{}) {
+	room.Mutex.Lock()
+	defer room.Mutex.Unlock()
+
+	if room.OwnerID != player.ID {
+		sendOwnerError(player.Conn, "只有房主可以管理电脑玩家。")
+		return
+	}
+
+	switch action {
+	case "add_bot":
+		if room.Practice != nil {
+			sendOwnerError(player.Conn, "练习模式不能添加电脑玩家。")
+			return
+		}
+		key, _ := payload["difficulty"].(string)
+		if key == "" {
+			key = "normal"
+		}
+		diff, ok := botDifficulties[key]
+		if !ok {
+			sendOwnerError(player.Conn, "未知的电脑难度。")
+			return
+		}
+		if room.State != "waiting" {
+			sendOwnerError(player.Conn, "只能在游戏开始前添加电脑玩家。")
+			return
+		}
+		if len(room.Players) >= 8 {
+			sendOwnerError(player.Conn, "房间人数已满 (最多8人)")
+			return
+		}
+		bot := &Player{
+			ID:        "bot_" + newInviteToken()[:12],
+			Name:      botName(room, &diff),
+			IsBot:     true,
+			GameReady: true,
+			JoinedAt:  time.Now(),
+			Bot:       &diff,
+		}
+		room.Players[bot.ID] = bot
+		fmt.Printf("房间 [%s] 添加了电脑玩家 [%s]\n", room.ID, bot.Name)
+		events.publish(evPlayerJoined, room.ID, map[string]interface{}{"playerId": bot.ID, "name": bot.Name, "bot": diff.Key})
+		postSystemMessageLocked(room, fmt.Sprintf("房主添加了电脑玩家 [%s]", bot.Name))
+		broadcastRoomStateLocked(room)
+
+	case "remove_bot":
+		targetID, _ := payload["playerId"].(string)
+		bot, ok := room.Players[targetID]
+		if !ok || !bot.IsBot {
+			sendOwnerError(player.Conn, "该电脑玩家不在房间中。")
+			return
+		}
+		postSystemMessageLocked(room, fmt.Sprintf("房主移除了电脑玩家 [%s]", bot.Name))
+		removeBotLocked(room, bot)
+	}
+}
+
+// 持有 room.Mutex
+// removeBotLocked 把电脑玩家移出房间；它没有连接协程，需要在这里完成离开房间的收尾
+func removeBotLocked(room *Room, bot *Player) {
+	delete(room.Players, bot.ID)
+	fmt.Printf("电脑玩家 [%s] 离开了房间 [%s]\n", bot.Name, room.ID)
+	events.publish(evPlayerLeft, room.ID, map[string]interface{}{"playerId": bot.ID, "name": bot.Name, "bot": bot.Bot.Key})
+	broadcastRoomStateLocked(room)
+}
+
+// 持有 room.Mutex
+func botName(room *Room, diff *botDifficulty) string {
+	for n := 1; ; n++ {
+		name := fmt.Sprintf("电脑·%s%d", diff.Label, n)
+		if findPlayerByName(room, name) == nil {
+			return name
+		}
+	}
+}
+
+// 持有 room.Mutex
+// scheduleBotsReady 在准备阶段让电脑玩家稍等片刻后报告音频加载完毕
+func scheduleBotsReady(room *Room) {
+	for _, p := range room.Players {
+		if !p.IsBot {
+			continue
+		}
+		bot := p
+		delay := 300*time.Millisecond + time.Duration(rand.Int63n(int64(time.Second)))
+		time.AfterFunc(delay, func() { playerClientReady(room, bot) })
+	}
+}
+
+// 持有 room.Mutex
+// scheduleBotAnswers 在正式播放后按各自的反应时间安排电脑玩家作答
+func scheduleBotAnswers(room *Room, roundNum int) {
+	for _, p := range room.Players {
+		if !p.IsBot {
+			continue
+		}
+		bot := p
+		time.AfterFunc(bot.Bot.reactionTime(), func() { botAnswer(room, bot, roundNum) })
+	}
+}
+
+// botAnswer 让电脑玩家按难度作答；房间暂停时顺延
+// 反应时间过后房间可能已进入下一局、重新开始或关闭，选牌与作答在同一次加锁内完成
+func botAnswer(room *Room, bot *Player, roundNum int) {
+	room.Mutex.Lock()
+	defer room.Mutex.Unlock()
+	if room.State != "playing" || room.CurrentRound != roundNum || room.RoundState != "playing" || bot.HasAnswered || room.Players[bot.ID] != bot {
+		return
+	}
+	if room.Paused {
+		time.AfterFunc(time.Second, func() { botAnswer(room, bot, roundNum) })
+		return
+	}
+
+	var wrongCards []string
+	answer := ""
+	for _, c := range room.BoardCards {
+		if c.IsMatched {
+			continue
+		}
+		if room.Mode.CheckAnswer(*room.CurrentSong, c.ID) {
+			answer = c.ID
+		} else {
+			wrongCards = append(wrongCards, c.ID)
+		}
+	}
+	onBoard := answer != ""
+
+	// cardID 为空表示喊"没有这首歌"
+	cardID := ""
+	if onBoard {
+		if rand.Float64() < bot.Bot.Accuracy {
+			cardID = answer
+		} else if len(wrongCards) > 0 && rand.Float64() < 0.7 {
+			cardID = wrongCards[rand.Intn(len(wrongCards))]
+		}
+	} else if rand.Float64() >= bot.Bot.NoSongAccuracy && len(wrongCards) > 0 {
+		cardID = wrongCards[rand.Intn(len(wrongCards))]
+	}
+
+	if cardID == "" {
+		playerNoSongLocked(room, bot)
+	} else {
+		playerBuzzLocked(room, bot, cardID)
+	}
+}
@@ -0,0 +1,335 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/gorilla/websocket"
+)
+
+// ==========================================
+// 聊天：长度限制、敏感词过滤、禁言/踢人、历史记录与系统消息
+// ==========================================
+
+// 单条聊天消息最大字符数（按 rune 计，中英文同等对待）
+var chatMaxLen = 200
+
+// 每个房间保留的聊天记录条数，发给中途加入的玩家
+const chatHistorySize = 50
+
+// 默认禁言时长与 /mute 可指定的分钟数范围
+const (
+	defaultMuteDuration = 5 * time.Minute
+	maxMuteMinutes      = 24 * 60
+)
+
+// 房主的聊天命令；其它以 / 开头的消息按普通聊天发送
+var chatCommands = map[string]bool{"/mute": true, "/unmute": true, "/kick": true}
+
+// ChatEntry 是一条聊天记录；System 为 true 表示服务器发出的系统消息
+type ChatEntry struct {
+	Sender string `json:"sender"`
+	Text   string `json:"text"`
+	System bool   `json:"system,omitempty"`
+	Time   int64  `json:"time"`
+}
+
+// ChatFilter 对聊天内容做过滤，返回替换后的文本
+type ChatFilter interface {
+	Filter(text string) string
+}
+
+type noopFilter struct{}
+
+func (noopFilter) Filter(text string) string { return text }
+
+var chatFilter ChatFilter = noopFilter{}
+
+// wordListFilter 按词表把命中的词替换为 *，大小写不敏感，支持中日韩文字
+type wordListFilter struct {
+	words [][]rune
+}
+
+// loadWordListFilter 从文件加载词表，每行一个词，# 开头为注释
+func loadWordListFilter(path string) (*wordListFilter, error) {
+	file, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	file = bytes.TrimPrefix(file, []byte{0xEF, 0xBB, 0xBF})
+
+	f := &wordListFilter{}
+	scanner := bufio.NewScanner(bytes.NewReader(file))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		f.words = append(f.words, []rune(strings.ToLower(line)))
+	}
+	return f, scanner.Err()
+}
+
+func (f *wordListFilter) Filter(text string) string {
+	src := []rune(text)
+	lower := make([]rune, len(src))
+	for i, r := range src {
+		lower[i] = unicode.ToLower(r)
+	}
+	for i := 0; i < len(lower); i++ {
+		for _, w := range f.words {
+			if len(w) == 0 || i+len(w) > len(lower) {
+				continue
+			}
+			matched := true
+			for j, r := range w {
+				if lower[i+j] != r {
+					matched = false
+					break
+				}
+			}
+			if matched {
+				for j := range w {
+					src[i+j] = '*'
+				}
+			}
+		}
+	}
+	return string(src)
+}
+
+// sanitizeChat 去掉控制字符与零宽字符，折叠首尾空白
+func sanitizeChat(text string) string {
+	text = strings.Map(func(r rune) rune {
+		switch {
+		case r == '\n' || r == '\t':
+			return ' '
+		case unicode.IsControl(r), unicode.Is(unicode.Cf, r):
+			return -1
+		}
+		return r
+	}, text)
+	return strings.TrimSpace(text)
+}
+
+// 持有 room.Mutex
+func appendChatHistory(room *Room, entry ChatEntry) {
+	room.ChatHistory = append(room.ChatHistory, entry)
+	if len(room.ChatHistory) > chatHistorySize {
+		room.ChatHistory = room.ChatHistory[len(room.ChatHistory)-chatHistorySize:]
+	}
+}
+
+// 持有 room.Mutex
+func broadcastChatLocked(room *Room, entry ChatEntry) {
+	appendChatHistory(room, entry)
+	chatMsg := WsMessage{
+		Type: "chat_receive",
+		Payload: map[string]interface{}{
+			"sender": entry.Sender,
+			"text":   entry.Text,
+			"system": entry.System,
+			"time":   entry.Time,
+		},
+	}
+	msgBytes, _ := json.Marshal(chatMsg)
+	for _, p := range room.Players {
+		p.send(msgBytes)
+	}
+}
+
+// postSystemMessage 在房间聊天频道里发布一条系统消息
+func postSystemMessage(room *Room, text string) {
+	room.Mutex.Lock()
+	defer room.Mutex.Unlock()
+	postSystemMessageLocked(room, text)
+}
+
+// 持有 room.Mutex
+func postSystemMessageLocked(room *Room, text string) {
+	broadcastChatLocked(room, ChatEntry{Sender: "系统", Text: text, System: true, Time: time.Now().Unix()})
+}
+
+// sendSystemNotice 只发给单个连接的系统提示，不进入房间历史
+func sendSystemNotice(conn *websocket.Conn, text string) {
+	noticeMsg := WsMessage{
+		Type: "chat_receive",
+		Payload: map[string]interface{}{
+			"sender": "系统",
+			"text":   text,
+			"system": true,
+			"time":   time.Now().Unix(),
+		},
+	}
+	nBytes, _ := json.Marshal(noticeMsg)
+	conn.WriteMessage(websocket.TextMessage, nBytes)
+}
+
+// sendChatHistory 把房间的聊天记录发给刚加入的玩家
+func sendChatHistory(room *Room, conn *websocket.Conn) {
+	room.Mutex.Lock()
+	history := make([]ChatEntry, len(room.ChatHistory))
+	copy(history, room.ChatHistory)
+	room.Mutex.Unlock()
+
+	if len(history) == 0 {
+		return
+	}
+	historyMsg := WsMessage{
+		Type:    "chat_history",
+		Payload: map[string]interface{}{"messages": history},
+	}
+	hBytes, _ := json.Marshal(historyMsg)
+	conn.WriteMessage(websocket.TextMessage, hBytes)
+}
+
+// handleChat 处理一条玩家聊天：命令、禁言、长度与过滤
+func handleChat(room *Room, player *Player, text string) {
+	text = sanitizeChat(text)
+	if text == "" {
+		return
+	}
+	if fields := strings.Fields(text); chatCommands[strings.ToLower(fields[0])] {
+		handleChatCommand(room, player, text)
+		return
+	}
+	if n := len([]rune(text)); n > chatMaxLen {
+		sendSystemNotice(player.Conn, fmt.Sprintf("消息过长 (%d/%d 字)，未发送。", n, chatMaxLen))
+		return
+	}
+
+	room.Mutex.Lock()
+	defer room.Mutex.Unlock()
+	if until, muted := room.Muted[player.ID]; muted {
+		if time.Now().Before(until) {
+			left := time.Until(until).Round(time.Second)
+			sendSystemNotice(player.Conn, fmt.Sprintf("你已被房主禁言，剩余 %v。", left))
+			return
+		}
+		delete(room.Muted, player.ID)
+	}
+	broadcastChatLocked(room, ChatEntry{
+		Sender: player.Name,
+		Text:   chatFilter.Filter(text),
+		Time:   time.Now().Unix(),
+	})
+}
+
+// handleChatCommand 处理房主的聊天命令：/mute 玩家 [分钟]、/unmute 玩家、/kick 玩家
+// 玩家可以写昵称或玩家 ID，昵称含空格时用双引号括起来
+func handleChatCommand(room *Room, player *Player, text string) {
+	args := splitChatCommand(text)
+	cmd := strings.ToLower(args[0])
+
+	room.Mutex.Lock()
+	defer room.Mutex.Unlock()
+
+	if room.OwnerID != player.ID {
+		sendSystemNotice(player.Conn, "只有房主可以使用聊天命令。")
+		return
+	}
+	if len(args) < 2 || (cmd != "/mute" && len(args) > 2) || len(args) > 3 {
+		sendSystemNotice(player.Conn, "用法: /mute 玩家 [分钟]、/unmute 玩家、/kick 玩家 (玩家可写昵称或 ID，昵称含空格时加双引号)")
+		return
+	}
+	target := findCommandTarget(room, args[1])
+	if target == nil {
+		sendSystemNotice(player.Conn, fmt.Sprintf("房间里没有名为 [%s] 的玩家。", args[1]))
+		return
+	}
+	if target.ID == player.ID {
+		sendSystemNotice(player.Conn, "不能对自己使用该命令。")
+		return
+	}
+
+	switch cmd {
+	case "/mute":
+		d := defaultMuteDuration
+		if len(args) == 3 {
+			minutes, err := strconv.Atoi(args[2])
+			if err != nil || minutes < 1 || minutes > maxMuteMinutes {
+				sendSystemNotice(player.Conn, fmt.Sprintf("禁言时长需为 1 到 %d 之间的分钟数。", maxMuteMinutes))
+				return
+			}
+			d = time.Duration(minutes) * time.Minute
+		}
+		room.Muted[target.ID] = time.Now().Add(d)
+		postSystemMessageLocked(room, fmt.Sprintf("玩家 [%s] 被房主禁言 %v。", target.Name, d))
+	case "/unmute":
+		delete(room.Muted, target.ID)
+		postSystemMessageLocked(room, fmt.Sprintf("玩家 [%s] 已解除禁言。", target.Name))
+	case "/kick":
+		kickPlayerLocked(room, target, "你已被房主移出房间。")
+	}
+}
+
+// splitChatCommand 按空白切分命令，双引号括起的部分 (可含空格) 作为一个参数
+func splitChatCommand(text string) []string {
+	var args []string
+	var cur strings.Builder
+	quoted, inArg := false, false
+	for _, r := range text {
+		switch {
+		case r == '"':
+			quoted = !quoted
+			inArg = true
+		case unicode.IsSpace(r) && !quoted:
+			if inArg {
+				args = append(args, cur.String())
+				cur.Reset()
+				inArg = false
+			}
+		default:
+			cur.WriteRune(r)
+			inArg = true
+		}
+	}
+	if inArg {
+		args = append(args, cur.String())
+	}
+	return args
+}
+
+// 持有 room.Mutex
+// findCommandTarget 先按玩家 ID 查找，再按昵称查找
+func findCommandTarget(room *Room, who string) *Player {
+	if p, ok := room.Players[who]; ok {
+		return p
+	}
+	return findPlayerByName(room, who)
+}
+
+// 持有 room.Mutex
+func findPlayerByName(room *Room, name string) *Player {
+	for _, p := range room.Players {
+		if p.Name == name {
+			return p
+		}
+	}
+	return nil
+}
+
+// kickPlayerLocked 通知被踢玩家并关闭其连接；
+// 玩家的读循环随之退出，由连接的清理逻辑把他移出房间并广播
+// 持有 room.Mutex
+func kickPlayerLocked(room *Room, target *Player, reason string) {
+	kickMsg := WsMessage{
+		Type:    "kicked",
+		Payload: map[string]interface{}{"message": reason},
+	}
+	kBytes, _ := json.Marshal(kickMsg)
+	target.send(kBytes)
+	target.close()
+	fmt.Printf("玩家 [%s] 被移出房间 [%s]\n", target.Name, room.ID)
+	events.publish(evPlayerKicked, room.ID, map[string]interface{}{"playerId": target.ID, "name": target.Name, "reason": reason})
+	if target.IsBot {
+		removeBotLocked(room, target)
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// ==========================================
+// 客户端 IP 解析
+// 只有直连方属于受信任的反向代理时，才采信 X-Forwarded-For；
+// X-Real-IP 之类的单值头客户端可以自己伪造，只有运维用 -real-ip-header 指明代理会覆盖它时才采信
+// ==========================================
+
+// 默认只信任本机上的反向代理 (nginx 与游戏服务部署在同一台机器)
+var trustedProxies = mustParseCIDRs("127.0.0.1/32,::1/128")
+
+// 受信任代理在没有 X-Forwarded-For 时用来传递客户端 IP 的请求头，留空则不采信任何单值头
+var realIPHeader = ""
+
+func mustParseCIDRs(list string) []*net.IPNet {
+	nets, err := parseCIDRs(list)
+	if err != nil {
+		panic(err)
+	}
+	return nets
+}
+
+// parseCIDRs 解析逗号分隔的 CIDR 列表，单个 IP 视为 /32 或 /128
+func parseCIDRs(list string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, item := range strings.Split(list, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		if !strings.Contains(item, "/") {
+			if ip := net.ParseIP(item); ip != nil && ip.To4() != nil {
+				item += "/32"
+			} else {
+				item += "/128"
+			}
+		}
+		_, n, err := net.ParseCIDR(item)
+		if err != nil {
+			return nil, fmt.Errorf("无效的代理地址 %q: %w", item, err)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+func isTrustedProxy(ip net.IP) bool {
+	for _, n := range trustedProxies {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP 取请求方的真实 IP
+// 从 X-Forwarded-For 的最右侧往左跳过受信任的代理，第一个不受信任的地址即为客户端
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	remote := net.ParseIP(host)
+	if remote == nil || !isTrustedProxy(remote) {
+		return host
+	}
+
+	if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
+		// 某一跳无法解析时无从判断它是否可信，按直连方处理，不再改看其它请求头
+		hops := strings.Split(xff, ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop := strings.TrimSpace(hops[i])
+			ip := net.ParseIP(hop)
+			if ip == nil {
+				return host
+			}
+			if i == 0 || !isTrustedProxy(ip) {
+				return hop
+			}
+		}
+	}
+	if realIPHeader != "" {
+		if xr := strings.TrimSpace(r.Header.Get(realIPHeader)); net.ParseIP(xr) != nil {
+			return xr
+		}
+	}
+	return host
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ==========================================
+// 按局裁剪与缓存题目音频
+// 有 ffmpeg 时只下发本局要播放的片段 (从 startTime 起 playDuration 秒，不重新编码)，
+// prepare_round 中的 startTime 随之变为片段内的位置 (即 0)，客户端不必先下载整首再跳转
+// 同一局同一种协商格式的音频只生成一次，保存在内存中供房间里的所有玩家共享，
+// 用 http.ServeContent 输出，支持 Range 分段请求
+// 没有 ffmpeg 时仍下发整首 (同样按局缓存)，startTime 保持为原曲中的位置
+// 房间开启了音效 (见 effects.go) 时，片段从源文件截取后经滤镜重新编码
+// ==========================================
+
+// 是否按片段裁剪题目音频，可用 -audio-clip=false 关闭
+var audioClipEnabled = true
+
+// 片段末尾多留的秒数，吸收客户端开始播放的延迟
+const clipMargin = 2
+
+// audioMuxer 返回 Content-Type 对应的 ffmpeg 封装格式
+func audioMuxer(ctype string) (string, bool) {
+	for _, t := range audioSourceTypes {
+		if t.ctype == ctype {
+			return t.muxer, true
+		}
+	}
+	return "", false
+}
+
+// roundAudio 是一局要下发的音频：哪首曲目、从原曲第几秒起截取多长，以及按协商格式共享的缓存
+type roundAudio struct {
+	round    int
+	song     Song
+	offset   int // 下发的音频相对原曲的起点 (秒)，不裁剪时为 0
+	duration int // 截取长度 (秒)，0 表示整首
+	effects  *clipEffects
+
+	mu    sync.Mutex
+	clips map[string]*roundClip // 协商出的格式列表 -> 音频
+}
+
+type roundClip struct {
+	ready chan struct{}
+	data  []byte
+	ctype string
+	err   error
+}
+
+// newRoundAudio 为一局准备音频描述；能裁剪时只截取播放的片段，开启音效时总是裁剪
+func newRoundAudio(round int, song Song, startTime, playDuration int, effects audioEffects) *roundAudio {
+	a := &roundAudio{round: round, song: song, clips: make(map[string]*roundClip), effects: effects.roll()}
+	if a.effects != nil || (audioClipEnabled && haveFFmpeg()) {
+		a.offset, a.duration = startTime, playDuration+clipMargin
+	}
+	return a
+}
+
+// relative 把原曲中的时间换算成下发音频中的位置
+func (a *roundAudio) relative(startTime int) int {
+	return startTime - a.offset
+}
+
+// load 返回按请求协商出的音频，同一格式列表的并发请求只生成一次
+// wait 见 resolveAudioVariant：预取时不急，等待转码；本局播放时变体未就绪则先用源文件
+func (a *roundAudio) load(r *http.Request, mode Mode, wait bool) ([]byte, string, error) {
+	formats := negotiateAudioFormats(r)
+	names := make([]string, len(formats))
+	for i, f := range formats {
+		names[i] = f.name
+	}
+	key := strings.Join(names, ",")
+
+	a.mu.Lock()
+	c, ok := a.clips[key]
+	if !ok {
+		c = &roundClip{ready: make(chan struct{})}
+		a.clips[key] = c
+	}
+	a.mu.Unlock()
+	if ok {
+		<-c.ready
+		return c.data, c.ctype, c.err
+	}
+
+	c.data, c.ctype, c.err = a.build(r, mode, wait)
+	if c.err != nil {
+		// 失败不缓存，下一个请求重试
+		a.mu.Lock()
+		delete(a.clips, key)
+		a.mu.Unlock()
+	}
+	close(c.ready)
+	return c.data, c.ctype, c.err
+}
+
+func (a *roundAudio) build(r *http.Request, mode Mode, wait bool) ([]byte, string, error) {
+	srcPath, srcType := mode.AudioAsset(a.song)
+	if a.effects != nil {
+		return a.buildWithEffects(r, srcPath)
+	}
+	path, ctype, err := resolveAudioVariant(r, srcPath, srcType, wait)
+	if err != nil {
+		return nil, "", err
+	}
+	if a.duration == 0 {
+		data, err := os.ReadFile(path)
+		return data, ctype, err
+	}
+
+	muxer, ok := audioMuxer(ctype)
+	if !ok {
+		return nil, "", fmt.Errorf("无法裁剪 %s 格式的音频", ctype)
+	}
+	start := time.Now()
+	if err := os.MkdirAll(audioCacheDir, 0755); err != nil {
+		return nil, "", err
+	}
+	tmp, err := os.CreateTemp(audioCacheDir, ".clip-*"+filepath.Ext(path))
+	if err != nil {
+		return nil, "", err
+	}
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+
+	audioSlots <- struct{}{}
+	err = cutAudio(path, tmp.Name(), muxer, a.offset, a.duration)
+	<-audioSlots
+	if err != nil {
+		return nil, "", err
+	}
+	data, err := os.ReadFile(tmp.Name())
+	if err != nil {
+		return nil, "", err
+	}
+	fmt.Printf("已截取第 %d 局音频片段 %s (%d+%ds, %d 字节)，耗时 %v\n",
+		a.round, srcPath, a.offset, a.duration, len(data), time.Since(start).Round(time.Millisecond))
+	return data, ctype, nil
+}
+
+// buildWithEffects 从源文件截取片段并套用本局的特效，按协商的格式依次尝试编码
+func (a *roundAudio) buildWithEffects(r *http.Request, srcPath string) ([]byte, string, error) {
+	if _, err := os.Stat(srcPath); err != nil {
+		return nil, "", err
+	}
+	if err := os.MkdirAll(audioCacheDir, 0755); err != nil {
+		return nil, "", err
+	}
+	graph := a.effects.filterGraph(audioLoudness)
+	for _, f := range negotiateAudioFormats(r) {
+		if !audioEncoderUsable(f) {
+			continue
+		}
+		start := time.Now()
+		tmp, err := os.CreateTemp(audioCacheDir, ".clip-*"+f.ext)
+		if err != nil {
+			return nil, "", err
+		}
+		tmp.Close()
+
+		audioSlots <- struct{}{}
+		err = transcodeClip(srcPath, tmp.Name(), f, a.offset, a.effects.sourceSeconds(a.duration), graph)
+		<-audioSlots
+		var data []byte
+		if err == nil {
+			data, err = os.ReadFile(tmp.Name())
+		}
+		os.Remove(tmp.Name())
+		if err != nil {
+			// 滤镜本身出错时换格式也没用，但缺少某个编码器时换格式可以成功
+			fmt.Printf("生成第 %d 局特效片段失败 %s (%s): %v\n", a.round, srcPath, f.name, err)
+			if errors.Is(err, errEncoderMissing) {
+				audioEncoderBroken.Store(f.name, true)
+			}
+			continue
+		}
+		fmt.Printf("已生成第 %d 局特效片段 %s (%s, %s, %d 字节)，耗时 %v\n",
+			a.round, srcPath, a.effects, f.name, len(data), time.Since(start).Round(time.Millisecond))
+		return data, f.ctype, nil
+	}
+	return nil, "", fmt.Errorf("没有可用的编码格式")
+}
+
+// serveRoundAudio 输出一局的音频，支持 Range
+// 同一地址每局播放的歌不同，题目音频一律禁止缓存；也不输出 ETag 与 Last-Modified，
+// 否则可以凭响应头认出重复出现的歌
+func serveRoundAudio(w http.ResponseWriter, r *http.Request, mode Mode, a *roundAudio) {
+	data, ctype, err := a.load(r, mode, false)
+	if err != nil {
+		fmt.Printf("严重错误: 准备第 %d 局音频失败 (%s): %v\n", a.round, a.song.ID, err)
+		http.Error(w, "音频文件不存在", http.StatusNotFound)
+		return
+	}
+
+	h := w.Header()
+	h.Set("Cache-Control", "no-store, no-cache, must-revalidate")
+	h.Set("Vary", "Accept")
+	h.Set("Content-Type", ctype)
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+}
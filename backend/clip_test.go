@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+// writeTestAudio 写入曲包中某首歌的音频文件
+func writeTestAudio(t *testing.T, m *packMode, s Song, data []byte) {
+	t.Helper()
+	path, _ := m.AudioAsset(s)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRoundAudioCacheKey(t *testing.T) {
+	setTestFFmpeg(t, false)
+	mode, songs := newTestAudioMode(t, 1)
+	a := newRoundAudio(1, songs[0], 30, 20, audioEffects{})
+	load := func(target string) string {
+		t.Helper()
+		data, ctype, err := a.load(httptest.NewRequest("GET", target, nil), mode, false)
+		if err != nil {
+			t.Fatalf("%s: %v", target, err)
+		}
+		if ctype != "audio/mp4" {
+			t.Fatalf("%s: Content-Type = %s, 期望源文件的 audio/mp4", target, ctype)
+		}
+		return string(data)
+	}
+
+	// 源文件还不存在：失败不缓存，文件出现后同一格式的请求可以重试成功
+	if _, _, err := a.load(httptest.NewRequest("GET", "/api/audio?formats=aac", nil), mode, false); err == nil {
+		t.Fatal("源文件不存在时应当失败")
+	}
+	writeTestAudio(t, mode, songs[0], []byte("第一版"))
+	if got := load("/api/audio?formats=aac"); got != "第一版" {
+		t.Fatalf("重试后 = %q, 期望 %q", got, "第一版")
+	}
+
+	// 之后文件变化不影响已缓存的格式；协商结果相同的格式列表共用缓存
+	cases := []struct {
+		write  string // 请求前先改写源文件
+		target string
+		want   string
+	}{
+		{"第二版", "/api/audio?formats=aac", "第一版"},
+		{"", "/api/audio?formats=AAC,%20flac", "第一版"}, // 未知格式被忽略，协商结果仍为 aac
+		{"", "/api/audio?formats=opus,aac", "第二版"},
+		{"第三版", "/api/audio?formats=aac,opus", "第二版"}, // 按服务端的优先级排序，与 opus,aac 相同
+		{"", "/api/audio?formats=mp3", "第三版"},
+		{"", "/api/audio", "第三版"}, // 没有声明时看 Accept 并以 mp3 兜底，与 mp3 相同
+	}
+	for _, c := range cases {
+		if c.write != "" {
+			writeTestAudio(t, mode, songs[0], []byte(c.write))
+		}
+		if got := load(c.target); got != c.want {
+			t.Errorf("%s = %q, 期望 %q", c.target, got, c.want)
+		}
+	}
+	if n := len(a.clips); n != 3 {
+		t.Fatalf("缓存了 %d 种格式列表, 期望 3", n)
+	}
+}
+
+func TestRoundAudioConcurrentLoad(t *testing.T) {
+	setTestFFmpeg(t, false)
+	mode, songs := newTestAudioMode(t, 1)
+	writeTestAudio(t, mode, songs[0], []byte("同一份"))
+	a := newRoundAudio(1, songs[0], 0, 30, audioEffects{})
+
+	const n = 16
+	results := make(chan []byte, n)
+	for i := 0; i < n; i++ {
+		go func() {
+			data, _, err := a.load(httptest.NewRequest("GET", "/api/audio?formats=aac", nil), mode, false)
+			if err != nil {
+				data = nil
+			}
+			results <- data
+		}()
+	}
+	var first []byte
+	for i := 0; i < n; i++ {
+		data := <-results
+		if data == nil {
+			t.Fatal("并发请求失败")
+		}
+		if first == nil {
+			first = data
+		} else if &data[0] != &first[0] {
+			t.Fatal("同一格式的并发请求应共享同一份音频")
+		}
+	}
+}
+
+func TestNewRoundAudioClipWindow(t *testing.T) {
+	cases := []struct {
+		clip     bool
+		ffmpeg   bool
+		offset   int
+		duration int
+	}{
+		{true, true, 30, 20 + clipMargin},
+		{false, true, 0, 0},
+		{true, false, 0, 0},
+	}
+	for _, c := range cases {
+		setTestAudioClip(t, c.clip)
+		setTestFFmpeg(t, c.ffmpeg)
+		a := newRoundAudio(1, Song{ID: "s"}, 30, 20, audioEffects{})
+		if a.offset != c.offset || a.duration != c.duration {
+			t.Errorf("clip=%v ffmpeg=%v: 片段 %d+%d, 期望 %d+%d", c.clip, c.ffmpeg, a.offset, a.duration, c.offset, c.duration)
+		}
+		// prepare_round 中的起点换算到下发的音频里
+		if got := a.relative(30); got != 30-c.offset {
+			t.Errorf("clip=%v ffmpeg=%v: relative(30) = %d, 期望 %d", c.clip, c.ffmpeg, got, 30-c.offset)
+		}
+	}
+}
+
+func TestServeRoundAudioRange(t *testing.T) {
+	setTestFFmpeg(t, false)
+	mode, songs := newTestAudioMode(t, 1)
+	audio := make([]byte, 1000)
+	for i := range audio {
+		audio[i] = byte(i % 251)
+	}
+	writeTestAudio(t, mode, songs[0], audio)
+	a := newRoundAudio(1, songs[0], 0, 30, audioEffects{})
+	size := len(audio)
+
+	cases := []struct {
+		rangeHeader  string
+		status       int
+		contentRange string
+		body         []byte
+	}{
+		{"", http.StatusOK, "", audio},
+		{"bytes=0-9", http.StatusPartialContent, "bytes 0-9/1000", audio[:10]},
+		{"bytes=500-", http.StatusPartialContent, "bytes 500-999/1000", audio[500:]},
+		{"bytes=-16", http.StatusPartialContent, "bytes 984-999/1000", audio[984:]},
+		{"bytes=990-2000", http.StatusPartialContent, "bytes 990-999/1000", audio[990:]},
+		{fmt.Sprintf("bytes=%d-", size), http.StatusRequestedRangeNotSatisfiable, "bytes */1000", nil},
+	}
+	for _, c := range cases {
+		r := httptest.NewRequest("GET", "/api/audio?roomId=ROOM1&formats=aac", nil)
+		if c.rangeHeader != "" {
+			r.Header.Set("Range", c.rangeHeader)
+		}
+		w := httptest.NewRecorder()
+		serveRoundAudio(w, r, mode, a)
+		res := w.Result()
+		body, _ := io.ReadAll(res.Body)
+
+		if res.StatusCode != c.status {
+			t.Errorf("Range %q: 状态码 %d, 期望 %d", c.rangeHeader, res.StatusCode, c.status)
+			continue
+		}
+		if got := res.Header.Get("Content-Range"); got != c.contentRange {
+			t.Errorf("Range %q: Content-Range = %q, 期望 %q", c.rangeHeader, got, c.contentRange)
+		}
+		if c.body != nil && !bytes.Equal(body, c.body) {
+			t.Errorf("Range %q: 返回 %d 字节, 内容与原音频对应区间不一致", c.rangeHeader, len(body))
+		}
+		if c.status == http.StatusRequestedRangeNotSatisfiable {
+			continue
+		}
+		if got := res.Header.Get("Accept-Ranges"); got != "bytes" {
+			t.Errorf("Range %q: Accept-Ranges = %q, 期望 bytes", c.rangeHeader, got)
+		}
+		if got := res.Header.Get("Content-Type"); got != "audio/mp4" {
+			t.Errorf("Range %q: Content-Type = %q, 期望 audio/mp4", c.rangeHeader, got)
+		}
+		if got := res.Header.Get("Cache-Control"); got != "no-store, no-cache, must-revalidate" {
+			t.Errorf("Range %q: Cache-Control = %q, 题目音频不应被缓存", c.rangeHeader, got)
+		}
+		// 不输出可以用来认出重复歌曲的校验头
+		for _, h := range []string{"ETag", "Last-Modified"} {
+			if v := res.Header.Get(h); v != "" {
+				t.Errorf("Range %q: 不应输出 %s (%q)", c.rangeHeader, h, v)
+			}
+		}
+	}
+
+	// 音频不存在时返回 404
+	missing := newRoundAudio(2, Song{ID: "nope"}, 0, 30, audioEffects{})
+	w := httptest.NewRecorder()
+	serveRoundAudio(w, httptest.NewRequest("GET", "/api/audio?formats=aac", nil), mode, missing)
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("音频不存在时状态码 %d, 期望 404", w.Code)
+	}
+}
@@ -0,0 +1,421 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ==========================================
+// 多节点部署：房间归属目录
+// 每个节点只持有自己创建的房间，目录记录 房间号 -> 节点，
+// 节点再登记自己的对外地址，join_room 落到错误节点时据此返回重定向
+// ==========================================
+
+// RoomDirectory 记录房间归属于哪个节点
+type RoomDirectory interface {
+	// Claim 把房间号登记到 nodeID 名下，房间号已被占用时返回 false
+	Claim(roomID, nodeID string) (bool, error)
+	// Lookup 查询房间所在节点
+	Lookup(roomID string) (nodeID string, ok bool, err error)
+	// Release 释放房间号
+	Release(roomID string) error
+	// RegisterNode 登记（续期）节点的对外地址，ttl 内未续期视为下线
+	RegisterNode(nodeID, addr string, ttl time.Duration) error
+	// NodeAddr 查询节点对外地址，节点已下线时 ok 为 false
+	NodeAddr(nodeID string) (addr string, ok bool, err error)
+}
+
+// clusterNode 描述当前进程在集群中的身份
+type clusterNode struct {
+	ID   string
+	Addr string // 对外地址，如 wss://node1.metagaruta.com，供其它节点重定向
+	Dir  RoomDirectory
+}
+
+// 节点心跳周期与过期时间
+const (
+	nodeHeartbeat = 10 * time.Second
+	nodeTTL       = 30 * time.Second
+)
+
+// 默认单机模式：进程内目录，行为与单进程部署一致
+var cluster = &clusterNode{ID: "local", Dir: newMemoryDirectory()}
+
+// setupCluster 根据启动参数初始化节点身份与目录
+// dirSpec 为空或 "memory" 时使用进程内目录，"redis://host:port/db" 时使用 Redis 协议目录
+func setupCluster(nodeID, addr, dirSpec string) error {
+	var dir RoomDirectory
+	switch {
+	case dirSpec == "" || dirSpec == "memory":
+		dir = newMemoryDirectory()
+	case strings.HasPrefix(dirSpec, "redis://"):
+		d, err := newRedisDirectory(dirSpec)
+		if err != nil {
+			return err
+		}
+		dir = d
+	default:
+		return fmt.Errorf("未知的目录类型: %s", dirSpec)
+	}
+
+	if nodeID == "" {
+		nodeID = "local"
+	}
+	cluster = &clusterNode{ID: nodeID, Addr: addr, Dir: dir}
+
+	if err := dir.RegisterNode(nodeID, addr, nodeTTL); err != nil {
+		return fmt.Errorf("登记节点失败: %w", err)
+	}
+	go func() {
+		for range time.Tick(nodeHeartbeat) {
+			if err := dir.RegisterNode(nodeID, addr, nodeTTL); err != nil {
+				fmt.Println("警告: 节点心跳失败:", err)
+			}
+		}
+	}()
+	return nil
+}
+
+// claimRoom 在目录中登记房间号；若原持有节点已下线则接管该房间号
+func (n *clusterNode) claimRoom(roomID string) (bool, error) {
+	ok, err := n.Dir.Claim(roomID, n.ID)
+	if err != nil || ok {
+		return ok, err
+	}
+	owner, found, err := n.Dir.Lookup(roomID)
+	if err != nil {
+		return false, err
+	}
+	if !found {
+		return n.Dir.Claim(roomID, n.ID)
+	}
+	if owner == n.ID {
+		// 本节点的残留登记（例如上次异常退出）
+		return true, nil
+	}
+	if _, alive, err := n.Dir.NodeAddr(owner); err != nil || alive {
+		return false, err
+	}
+	if err := n.Dir.Release(roomID); err != nil {
+		return false, err
+	}
+	return n.Dir.Claim(roomID, n.ID)
+}
+
+// locateRoom 查询不在本节点的房间，返回所在节点及其对外地址
+func (n *clusterNode) locateRoom(roomID string) (nodeID, addr string, ok bool) {
+	owner, found, err := n.Dir.Lookup(roomID)
+	if err != nil {
+		fmt.Println("警告: 查询房间目录失败:", err)
+		return "", "", false
+	}
+	if !found || owner == n.ID {
+		return "", "", false
+	}
+	addr, alive, err := n.Dir.NodeAddr(owner)
+	if err != nil || !alive {
+		return "", "", false
+	}
+	return owner, addr, true
+}
+
+// releaseRoom 房间销毁时释放房间号
+func (n *clusterNode) releaseRoom(roomID string) {
+	if err := n.Dir.Release(roomID); err != nil {
+		fmt.Printf("警告: 释放房间号 [%s] 失败: %v\n", roomID, err)
+	}
+}
+
+// ==========================================
+// 进程内目录
+// ==========================================
+
+type memoryDirectory struct {
+	mu    sync.Mutex
+	rooms map[string]string
+	nodes map[string]memoryNode
+}
+
+type memoryNode struct {
+	addr    string
+	expires time.Time
+}
+
+func newMemoryDirectory() *memoryDirectory {
+	return &memoryDirectory{
+		rooms: make(map[string]string),
+		nodes: make(map[string]memoryNode),
+	}
+}
+
+func (d *memoryDirectory) Claim(roomID, nodeID string) (bool, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, exists := d.rooms[roomID]; exists {
+		return false, nil
+	}
+	d.rooms[roomID] = nodeID
+	return true, nil
+}
+
+func (d *memoryDirectory) Lookup(roomID string) (string, bool, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	nodeID, ok := d.rooms[roomID]
+	return nodeID, ok, nil
+}
+
+func (d *memoryDirectory) Release(roomID string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.rooms, roomID)
+	return nil
+}
+
+func (d *memoryDirectory) RegisterNode(nodeID, addr string, ttl time.Duration) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.nodes[nodeID] = memoryNode{addr: addr, expires: time.Now().Add(ttl)}
+	return nil
+}
+
+func (d *memoryDirectory) NodeAddr(nodeID string) (string, bool, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	n, ok := d.nodes[nodeID]
+	if !ok || time.Now().After(n.expires) {
+		return "", false, nil
+	}
+	return n.addr, true, nil
+}
+
+// ==========================================
+// Redis 协议目录
+// 只用到 SET NX / SET EX / GET / DEL，兼容 Redis、KeyDB、Valkey 等实现
+// ==========================================
+
+const (
+	redisRoomPrefix = "metagaruta:room:"
+	redisNodePrefix = "metagaruta:node:"
+)
+
+var (
+	// 目录服务返回的 nil 回复
+	errRedisNil = errors.New("redis: nil")
+	// 连接上读到无法解析的数据，需要重连
+	errRedisBroken = errors.New("redis: 协议错误")
+)
+
+type redisDirectory struct {
+	mu       sync.Mutex
+	addr     string
+	password string
+	db       int
+	conn     net.Conn
+	reader   *bufio.Reader
+}
+
+func newRedisDirectory(spec string) (*redisDirectory, error) {
+	u, err := url.Parse(spec)
+	if err != nil {
+		return nil, fmt.Errorf("解析目录地址失败: %w", err)
+	}
+	d := &redisDirectory{addr: u.Host}
+	if !strings.Contains(d.addr, ":") {
+		d.addr += ":6379"
+	}
+	if u.User != nil {
+		d.password, _ = u.User.Password()
+	}
+	if db := strings.TrimPrefix(u.Path, "/"); db != "" {
+		if d.db, err = strconv.Atoi(db); err != nil {
+			return nil, fmt.Errorf("无效的数据库编号: %s", db)
+		}
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err := d.dial(); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// 持有 d.mu
+func (d *redisDirectory) dial() error {
+	conn, err := net.DialTimeout("tcp", d.addr, 3*time.Second)
+	if err != nil {
+		return fmt.Errorf("连接目录服务失败: %w", err)
+	}
+	d.conn = conn
+	d.reader = bufio.NewReader(conn)
+	if d.password != "" {
+		if _, err := d.roundTrip("AUTH", d.password); err != nil {
+			d.close()
+			return err
+		}
+	}
+	if d.db != 0 {
+		if _, err := d.roundTrip("SELECT", strconv.Itoa(d.db)); err != nil {
+			d.close()
+			return err
+		}
+	}
+	return nil
+}
+
+// 持有 d.mu
+func (d *redisDirectory) close() {
+	if d.conn != nil {
+		d.conn.Close()
+		d.conn = nil
+	}
+}
+
+// do 执行一条命令，连接异常时重连一次
+func (d *redisDirectory) do(args ...string) (interface{}, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for attempt := 0; ; attempt++ {
+		if d.conn == nil {
+			if err := d.dial(); err != nil {
+				return nil, err
+			}
+		}
+		reply, err := d.roundTrip(args...)
+		var netErr net.Error
+		if err != nil && attempt == 0 && (errors.As(err, &netErr) || errors.Is(err, io.EOF) || errors.Is(err, errRedisBroken)) {
+			d.close()
+			continue
+		}
+		return reply, err
+	}
+}
+
+// 持有 d.mu
+func (d *redisDirectory) roundTrip(args ...string) (interface{}, error) {
+	d.conn.SetDeadline(time.Now().Add(3 * time.Second))
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "*%d\r\n", len(args))
+	for _, a := range args {
+		fmt.Fprintf(&sb, "$%d\r\n%s\r\n", len(a), a)
+	}
+	if _, err := d.conn.Write([]byte(sb.String())); err != nil {
+		return nil, err
+	}
+	return readRESP(d.reader)
+}
+
+// readRESP 读取一条 RESP 回复
+func readRESP(r *bufio.Reader) (interface{}, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimRight(line, "\r\n")
+	if line == "" {
+		return nil, errRedisBroken
+	}
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, fmt.Errorf("目录服务错误: %s", line[1:])
+	case ':':
+		n, err := strconv.ParseInt(line[1:], 10, 64)
+		if err != nil {
+			return nil, errRedisBroken
+		}
+		return n, nil
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, errRedisBroken
+		}
+		if n < 0 {
+			return nil, errRedisNil
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, errRedisBroken
+		}
+		if n < 0 {
+			return nil, errRedisNil
+		}
+		items := make([]interface{}, n)
+		for i := range items {
+			if items[i], err = readRESP(r); err != nil && !errors.Is(err, errRedisNil) {
+				return nil, err
+			}
+		}
+		return items, nil
+	default:
+		return nil, errRedisBroken
+	}
+}
+
+func (d *redisDirectory) Claim(roomID, nodeID string) (bool, error) {
+	_, err := d.do("SET", redisRoomPrefix+roomID, nodeID, "NX")
+	if errors.Is(err, errRedisNil) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (d *redisDirectory) Lookup(roomID string) (string, bool, error) {
+	return d.getString(redisRoomPrefix + roomID)
+}
+
+func (d *redisDirectory) Release(roomID string) error {
+	_, err := d.do("DEL", redisRoomPrefix+roomID)
+	return err
+}
+
+func (d *redisDirectory) RegisterNode(nodeID, addr string, ttl time.Duration) error {
+	_, err := d.do("SET", redisNodePrefix+nodeID, addr, "EX", strconv.Itoa(int(ttl.Seconds())))
+	return err
+}
+
+func (d *redisDirectory) NodeAddr(nodeID string) (string, bool, error) {
+	return d.getString(redisNodePrefix + nodeID)
+}
+
+func (d *redisDirectory) getString(key string) (string, bool, error) {
+	reply, err := d.do("GET", key)
+	if errors.Is(err, errRedisNil) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	s, ok := reply.(string)
+	if !ok {
+		return "", false, fmt.Errorf("目录服务返回了意外的类型: %T", reply)
+	}
+	return s, true, nil
+}
+
+// nodeHTTPBase 把节点的 ws(s) 对外地址转换为 http(s) 地址，用于 HTTP 接口重定向
+func nodeHTTPBase(addr string) string {
+	addr = strings.TrimSuffix(addr, "/ws")
+	switch {
+	case strings.HasPrefix(addr, "wss://"):
+		return "https://" + strings.TrimPrefix(addr, "wss://")
+	case strings.HasPrefix(addr, "ws://"):
+		return "http://" + strings.TrimPrefix(addr, "ws://")
+	}
+	return strings.TrimSuffix(addr, "/")
+}
@@ -0,0 +1,407 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// respStub 是进程内的 Redis 协议桩，只实现目录用到的 AUTH / SELECT / SET [NX] [EX] / GET / DEL
+type respStub struct {
+	ln       net.Listener
+	password string
+
+	mu       sync.Mutex
+	data     map[string]string
+	expires  map[string]time.Time
+	conns    map[net.Conn]bool
+	commands []string
+}
+
+func newRESPStub(t *testing.T, password string) *respStub {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &respStub{
+		ln:       ln,
+		password: password,
+		data:     make(map[string]string),
+		expires:  make(map[string]time.Time),
+		conns:    make(map[net.Conn]bool),
+	}
+	go s.serve()
+	t.Cleanup(func() {
+		ln.Close()
+		s.dropConns()
+	})
+	return s
+}
+
+func (s *respStub) url(db int) string {
+	if s.password != "" {
+		return fmt.Sprintf("redis://:%s@%s/%d", s.password, s.ln.Addr(), db)
+	}
+	return fmt.Sprintf("redis://%s/%d", s.ln.Addr(), db)
+}
+
+func (s *respStub) serve() {
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.conns[conn] = true
+		s.mu.Unlock()
+		go s.handle(conn)
+	}
+}
+
+// dropConns 断开所有客户端连接，模拟目录服务重启
+func (s *respStub) dropConns() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for c := range s.conns {
+		c.Close()
+		delete(s.conns, c)
+	}
+}
+
+func (s *respStub) handle(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	authed := s.password == ""
+	for {
+		reply, err := readRESP(r)
+		if err != nil {
+			return
+		}
+		items, _ := reply.([]interface{})
+		args := make([]string, len(items))
+		for i, it := range items {
+			args[i], _ = it.(string)
+		}
+		if len(args) == 0 {
+			return
+		}
+		cmd := strings.ToUpper(args[0])
+		s.mu.Lock()
+		s.commands = append(s.commands, cmd)
+		s.mu.Unlock()
+
+		var out string
+		switch {
+		case cmd == "AUTH":
+			if len(args) == 2 && args[1] == s.password {
+				authed = true
+				out = "+OK\r\n"
+			} else {
+				out = "-WRONGPASS invalid password\r\n"
+			}
+		case !authed:
+			out = "-NOAUTH Authentication required.\r\n"
+		case cmd == "SELECT":
+			out = "+OK\r\n"
+		case cmd == "SET":
+			out = s.set(args[1:])
+		case cmd == "GET":
+			if v, ok := s.get(args[1]); ok {
+				out = fmt.Sprintf("$%d\r\n%s\r\n", len(v), v)
+			} else {
+				out = "$-1\r\n"
+			}
+		case cmd == "DEL":
+			s.mu.Lock()
+			_, existed := s.data[args[1]]
+			delete(s.data, args[1])
+			delete(s.expires, args[1])
+			s.mu.Unlock()
+			if existed {
+				out = ":1\r\n"
+			} else {
+				out = ":0\r\n"
+			}
+		default:
+			out = "-ERR unknown command\r\n"
+		}
+		if _, err := conn.Write([]byte(out)); err != nil {
+			return
+		}
+	}
+}
+
+func (s *respStub) get(key string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if exp, ok := s.expires[key]; ok && time.Now().After(exp) {
+		delete(s.data, key)
+		delete(s.expires, key)
+	}
+	v, ok := s.data[key]
+	return v, ok
+}
+
+func (s *respStub) set(args []string) string {
+	key, val := args[0], args[1]
+	nx, ttl := false, time.Duration(0)
+	for i := 2; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "NX":
+			nx = true
+		case "EX":
+			i++
+			sec, _ := strconv.Atoi(args[i])
+			ttl = time.Duration(sec) * time.Second
+		}
+	}
+	if _, exists := s.get(key); exists && nx {
+		return "$-1\r\n"
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data[key] = val
+	delete(s.expires, key)
+	if ttl > 0 {
+		s.expires[key] = time.Now().Add(ttl)
+	}
+	return "+OK\r\n"
+}
+
+func (s *respStub) countCommand(cmd string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for _, c := range s.commands {
+		if c == cmd {
+			n++
+		}
+	}
+	return n
+}
+
+func newTestRedisDirectory(t *testing.T, stub *respStub) *redisDirectory {
+	t.Helper()
+	d, err := newRedisDirectory(stub.url(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		d.mu.Lock()
+		d.close()
+		d.mu.Unlock()
+	})
+	return d
+}
+
+func TestRedisDirectoryClaimLookupRelease(t *testing.T) {
+	stub := newRESPStub(t, "secret")
+	d := newTestRedisDirectory(t, stub)
+
+	if n := stub.countCommand("AUTH"); n != 1 {
+		t.Fatalf("AUTH 次数 = %d, 期望 1", n)
+	}
+	if n := stub.countCommand("SELECT"); n != 1 {
+		t.Fatalf("SELECT 次数 = %d, 期望 1", n)
+	}
+
+	ok, err := d.Claim("K7PXM", "node-a")
+	if err != nil || !ok {
+		t.Fatalf("首次 Claim = %v, %v", ok, err)
+	}
+	ok, err = d.Claim("K7PXM", "node-b")
+	if err != nil || ok {
+		t.Fatalf("重复 Claim = %v, %v, 期望 false", ok, err)
+	}
+	owner, found, err := d.Lookup("K7PXM")
+	if err != nil || !found || owner != "node-a" {
+		t.Fatalf("Lookup = %q, %v, %v", owner, found, err)
+	}
+	if err := d.Release("K7PXM"); err != nil {
+		t.Fatal(err)
+	}
+	if _, found, err := d.Lookup("K7PXM"); err != nil || found {
+		t.Fatalf("释放后 Lookup found = %v, %v", found, err)
+	}
+}
+
+func TestRedisDirectoryBadPassword(t *testing.T) {
+	stub := newRESPStub(t, "secret")
+	spec := strings.Replace(stub.url(0), "secret", "wrong", 1)
+	if _, err := newRedisDirectory(spec); err == nil {
+		t.Fatal("密码错误时应当连接失败")
+	}
+}
+
+func TestRedisDirectoryNodeTTL(t *testing.T) {
+	stub := newRESPStub(t, "")
+	d := newTestRedisDirectory(t, stub)
+
+	if err := d.RegisterNode("node-a", "wss://a.example", 30*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	addr, alive, err := d.NodeAddr("node-a")
+	if err != nil || !alive || addr != "wss://a.example" {
+		t.Fatalf("NodeAddr = %q, %v, %v", addr, alive, err)
+	}
+
+	// 让登记过期
+	stub.mu.Lock()
+	stub.expires[redisNodePrefix+"node-a"] = time.Now().Add(-time.Second)
+	stub.mu.Unlock()
+	if _, alive, err := d.NodeAddr("node-a"); err != nil || alive {
+		t.Fatalf("过期后 NodeAddr alive = %v, %v", alive, err)
+	}
+}
+
+func TestRedisDirectoryReconnect(t *testing.T) {
+	stub := newRESPStub(t, "secret")
+	d := newTestRedisDirectory(t, stub)
+
+	stub.dropConns()
+	ok, err := d.Claim("ABCDE", "node-a")
+	if err != nil || !ok {
+		t.Fatalf("断线后 Claim = %v, %v, 期望自动重连", ok, err)
+	}
+	if n := stub.countCommand("AUTH"); n != 2 {
+		t.Fatalf("重连后 AUTH 次数 = %d, 期望 2", n)
+	}
+}
+
+func TestClusterClaimRoomTakeover(t *testing.T) {
+	stub := newRESPStub(t, "")
+	d := newTestRedisDirectory(t, stub)
+	a := &clusterNode{ID: "node-a", Addr: "wss://a.example", Dir: d}
+	b := &clusterNode{ID: "node-b", Addr: "wss://b.example", Dir: d}
+
+	if err := d.RegisterNode(a.ID, a.Addr, nodeTTL); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := a.claimRoom("QWERT"); err != nil || !ok {
+		t.Fatalf("node-a claimRoom = %v, %v", ok, err)
+	}
+
+	// 持有节点在线时不能抢占，但能查到重定向地址
+	if ok, err := b.claimRoom("QWERT"); err != nil || ok {
+		t.Fatalf("node-b claimRoom = %v, %v, 期望 false", ok, err)
+	}
+	owner, addr, ok := b.locateRoom("QWERT")
+	if !ok || owner != "node-a" || addr != "wss://a.example" {
+		t.Fatalf("locateRoom = %q, %q, %v", owner, addr, ok)
+	}
+	// 本节点的房间不需要重定向
+	if _, _, ok := a.locateRoom("QWERT"); ok {
+		t.Fatal("本节点的房间不应返回重定向")
+	}
+	// 本节点的残留登记可以直接复用
+	if ok, err := a.claimRoom("QWERT"); err != nil || !ok {
+		t.Fatalf("node-a 再次 claimRoom = %v, %v", ok, err)
+	}
+
+	// 持有节点下线后可以接管
+	stub.mu.Lock()
+	delete(stub.data, redisNodePrefix+"node-a")
+	stub.mu.Unlock()
+	if _, _, ok := b.locateRoom("QWERT"); ok {
+		t.Fatal("持有节点下线后不应返回重定向")
+	}
+	if ok, err := b.claimRoom("QWERT"); err != nil || !ok {
+		t.Fatalf("接管下线节点的房间号 = %v, %v", ok, err)
+	}
+	if owner, _, _ := d.Lookup("QWERT"); owner != "node-b" {
+		t.Fatalf("接管后持有节点 = %q", owner)
+	}
+}
+
+func TestReadRESP(t *testing.T) {
+	cases := []struct {
+		in   string
+		want interface{}
+		err  error
+	}{
+		{"+OK\r\n", "OK", nil},
+		{":42\r\n", int64(42), nil},
+		{"$5\r\nhello\r\n", "hello", nil},
+		{"$-1\r\n", nil, errRedisNil},
+		{"*-1\r\n", nil, errRedisNil},
+		{"?\r\n", nil, errRedisBroken},
+		{":x\r\n", nil, errRedisBroken},
+	}
+	for _, c := range cases {
+		got, err := readRESP(bufio.NewReader(strings.NewReader(c.in)))
+		if err != c.err || got != c.want {
+			t.Errorf("readRESP(%q) = %v, %v; 期望 %v, %v", c.in, got, err, c.want, c.err)
+		}
+	}
+
+	got, err := readRESP(bufio.NewReader(strings.NewReader("*2\r\n$1\r\na\r\n$-1\r\n")))
+	items, _ := got.([]interface{})
+	if err != nil || len(items) != 2 || items[0] != "a" || items[1] != nil {
+		t.Errorf("数组回复 = %#v, %v", got, err)
+	}
+
+	if _, err := readRESP(bufio.NewReader(strings.NewReader("-ERR boom\r\n"))); err == nil || !strings.Contains(err.Error(), "ERR boom") {
+		t.Errorf("错误回复 = %v", err)
+	}
+}
+
+func TestNodeHTTPBase(t *testing.T) {
+	cases := map[string]string{
+		"wss://node1.metagaruta.com":    "https://node1.metagaruta.com",
+		"wss://node1.metagaruta.com/ws": "https://node1.metagaruta.com",
+		"ws://10.0.0.2:3000/ws":         "http://10.0.0.2:3000",
+		"https://node2.metagaruta.com/": "https://node2.metagaruta.com",
+		"http://10.0.0.3:3000":          "http://10.0.0.3:3000",
+	}
+	for in, want := range cases {
+		if got := nodeHTTPBase(in); got != want {
+			t.Errorf("nodeHTTPBase(%q) = %q, 期望 %q", in, got, want)
+		}
+	}
+}
+
+// lockProbeDirectory 在 Claim 时检查 globalMutex 是否空闲
+type lockProbeDirectory struct {
+	*memoryDirectory
+	heldDuringClaim bool
+}
+
+func (d *lockProbeDirectory) Claim(roomID, nodeID string) (bool, error) {
+	if globalMutex.TryLock() {
+		globalMutex.Unlock()
+	} else {
+		d.heldDuringClaim = true
+	}
+	return d.memoryDirectory.Claim(roomID, nodeID)
+}
+
+func TestGenerateRoomIDClaimsWithoutGlobalLock(t *testing.T) {
+	dir := &lockProbeDirectory{memoryDirectory: newMemoryDirectory()}
+	saved := cluster
+	cluster = &clusterNode{ID: "test", Dir: dir}
+	t.Cleanup(func() { cluster = saved })
+
+	id, err := generateRoomID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dir.heldDuringClaim {
+		t.Fatal("向目录登记房间号时不应持有 globalMutex")
+	}
+	globalMutex.Lock()
+	pending := pendingRoomIDs[id]
+	delete(pendingRoomIDs, id)
+	globalMutex.Unlock()
+	if !pending {
+		t.Fatal("新房间号应当记在 pendingRoomIDs 中，避免并发创建时重复分配")
+	}
+	if owner, found, _ := dir.Lookup(id); !found || owner != "test" {
+		t.Fatalf("目录登记 = %q, %v", owner, found)
+	}
+}
@@ -0,0 +1,404 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// ==========================================
+// 单个模拟玩家：说真实的 WebSocket 协议
+// 房主建房并在所有人准备后开局，其余机器人加入并准备；
+// 收到 prepare_round 后拉取音频并回 client_ready，play_round 后按反应时间抢答
+// ==========================================
+
+type WsMessage struct {
+	Type    string                 `json:"type"`
+	Payload map[string]interface{} `json:"payload"`
+}
+
+type card struct {
+	ID        string `json:"id"`
+	IsMatched bool   `json:"isMatched"`
+}
+
+type botConfig struct {
+	wsURL      string
+	httpBase   string
+	mode       string
+	accuracy   float64
+	reaction   reactionDist
+	fetchAudio bool
+}
+
+// reactionDist 描述抢答反应时间的分布
+type reactionDist struct {
+	kind   string // normal | uniform | exp | lognormal
+	mean   time.Duration
+	stddev time.Duration
+}
+
+func (d reactionDist) sample() time.Duration {
+	mean, sd := float64(d.mean), float64(d.stddev)
+	var v float64
+	switch d.kind {
+	case "uniform":
+		v = mean - sd + rand.Float64()*2*sd
+	case "exp":
+		v = rand.ExpFloat64() * mean
+	case "lognormal":
+		// 由期望与标准差反推对数正态分布的参数
+		sigma2 := math.Log(1 + (sd*sd)/(mean*mean))
+		mu := math.Log(mean) - sigma2/2
+		v = math.Exp(mu + math.Sqrt(sigma2)*rand.NormFloat64())
+	default:
+		v = mean + sd*rand.NormFloat64()
+	}
+	// 服务端每局最多 45 秒，反应时间限制在合理范围内
+	return time.Duration(math.Min(math.Max(v, float64(50*time.Millisecond)), float64(40*time.Second)))
+}
+
+type bot struct {
+	id    string
+	name  string
+	cfg   *botConfig
+	rec   *recorder
+	orc   *oracle
+	owner bool
+	// 房主在房间人数达到 expect 且其余人都已准备后开局
+	expect int
+
+	conn    *websocket.Conn
+	writeMu sync.Mutex
+
+	roomID     string
+	cards      []card
+	round      int
+	started    bool
+	readySent  bool
+	joinSentAt time.Time
+	startAt    time.Time
+	buzzAt     time.Time // 等待服务端对抢答的回应
+	mu         sync.Mutex
+}
+
+func (b *bot) send(msgType string, payload map[string]interface{}) error {
+	if payload == nil {
+		payload = map[string]interface{}{}
+	}
+	data, _ := json.Marshal(WsMessage{Type: msgType, Payload: payload})
+	b.writeMu.Lock()
+	defer b.writeMu.Unlock()
+	b.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	return b.conn.WriteMessage(websocket.TextMessage, data)
+}
+
+func (b *bot) dial(ctx context.Context) error {
+	dialer := websocket.Dialer{HandshakeTimeout: 10 * time.Second}
+	start := time.Now()
+	conn, resp, err := dialer.DialContext(ctx, b.cfg.wsURL, nil)
+	if err != nil {
+		if resp != nil {
+			return fmt.Errorf("连接被拒绝: HTTP %d", resp.StatusCode)
+		}
+		return fmt.Errorf("连接失败: %v", err)
+	}
+	b.rec.observe("ws_connect", time.Since(start))
+	b.rec.inc("connections")
+	b.conn = conn
+	return nil
+}
+
+// play 连接服务器并完成一局游戏；房主通过 roomIDs 把房间号告诉其余机器人
+// 返回 nil 表示正常打完一局
+func (b *bot) play(ctx context.Context, roomIDs chan<- string, joinRoom string) error {
+	if err := b.dial(ctx); err != nil {
+		return err
+	}
+	defer b.conn.Close()
+	go func() {
+		<-ctx.Done()
+		b.conn.Close()
+	}()
+
+	if b.owner {
+		b.startAt = time.Now()
+		if err := b.send("create_room", map[string]interface{}{
+			"playerName": b.name,
+			"playerId":   b.id,
+			"gameMode":   b.cfg.mode,
+		}); err != nil {
+			return err
+		}
+	} else {
+		b.roomID = joinRoom
+		b.joinSentAt = time.Now()
+		if err := b.send("join_room", map[string]interface{}{
+			"roomId":     joinRoom,
+			"playerName": b.name,
+			"playerId":   b.id,
+		}); err != nil {
+			return err
+		}
+	}
+
+	for {
+		_, data, err := b.conn.ReadMessage()
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return fmt.Errorf("连接意外断开: %v", err)
+		}
+		b.rec.inc("messages_in")
+		var msg WsMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			b.rec.fail("无法解析的服务端消息")
+			continue
+		}
+		done, err := b.handle(msg, roomIDs)
+		if err != nil || done {
+			return err
+		}
+	}
+}
+
+// handle 处理一条服务端消息，done 为 true 表示本局结束
+func (b *bot) handle(msg WsMessage, roomIDs chan<- string) (done bool, err error) {
+	switch msg.Type {
+	case "room_created":
+		b.roomID, _ = msg.Payload["roomId"].(string)
+		b.rec.observe("create_room", time.Since(b.startAt))
+		b.rec.inc("rooms_created")
+		roomIDs <- b.roomID
+
+	case "room_state_update":
+		b.onRoomState(msg.Payload)
+
+	case "game_started":
+		b.mu.Lock()
+		b.cards = decodeCards(msg.Payload["cards"])
+		b.mu.Unlock()
+		if b.owner {
+			b.rec.observe("start_game", time.Since(b.startAt))
+			b.rec.inc("games_started")
+		}
+
+	case "prepare_round":
+		round, _ := msg.Payload["round"].(float64)
+		b.mu.Lock()
+		b.round = int(round)
+		b.buzzAt = time.Time{}
+		b.mu.Unlock()
+		go b.prepare(int(round))
+
+	case "play_round":
+		b.mu.Lock()
+		round := b.round
+		b.mu.Unlock()
+		delay := b.cfg.reaction.sample()
+		time.AfterFunc(delay, func() { b.buzz(round) })
+
+	case "wrong_answer":
+		b.ackBuzz()
+
+	case "round_end":
+		b.ackBuzz()
+		b.mu.Lock()
+		b.cards = decodeCards(msg.Payload["cards"])
+		b.round = -1
+		b.mu.Unlock()
+		if b.owner {
+			b.rec.inc("rounds")
+		}
+
+	case "game_over":
+		if b.owner {
+			b.rec.inc("games_finished")
+		}
+		return true, nil
+
+	case "error":
+		text, _ := msg.Payload["message"].(string)
+		return false, fmt.Errorf("服务端错误: %s", text)
+	case "rate_limited":
+		b.rec.fail("被限流 (rate_limited)")
+	case "redirect":
+		return false, fmt.Errorf("房间在其它节点上 (redirect)，请直接压测该节点")
+	case "kicked", "room_closed":
+		text, _ := msg.Payload["message"].(string)
+		return false, fmt.Errorf("被移出房间: %s", text)
+	}
+	return false, nil
+}
+
+func (b *bot) onRoomState(payload map[string]interface{}) {
+	players, _ := payload["players"].([]interface{})
+	if !b.owner {
+		if !b.readySent {
+			b.readySent = true
+			b.rec.observe("join_room", time.Since(b.joinSentAt))
+			b.rec.inc("joins")
+			if err := b.send("toggle_ready", nil); err != nil {
+				b.rec.fail("发送 toggle_ready 失败")
+			}
+		}
+		return
+	}
+	if b.started || len(players) < b.expect {
+		return
+	}
+	for _, p := range players {
+		pm, _ := p.(map[string]interface{})
+		if id, _ := pm["id"].(string); id == b.id {
+			continue
+		}
+		if ready, _ := pm["gameReady"].(bool); !ready {
+			return
+		}
+	}
+	b.started = true
+	b.startAt = time.Now()
+	if err := b.send("start_game", nil); err != nil {
+		b.rec.fail("发送 start_game 失败")
+	}
+}
+
+// prepare 模拟浏览器加载音频，加载完成后回 client_ready
+func (b *bot) prepare(round int) {
+	if b.cfg.fetchAudio {
+		b.fetchAudio()
+	}
+	b.mu.Lock()
+	current := b.round
+	b.mu.Unlock()
+	if current != round {
+		return
+	}
+	if err := b.send("client_ready", nil); err != nil {
+		b.rec.fail("发送 client_ready 失败")
+	}
+}
+
+func (b *bot) fetchAudio() {
+	start := time.Now()
+	u := b.cfg.httpBase + "/api/audio?roomId=" + url.QueryEscape(b.roomID) + "&t=" + fmt.Sprint(start.UnixNano())
+	resp, err := http.Get(u)
+	if err != nil {
+		b.rec.fail("音频请求失败: " + err.Error())
+		return
+	}
+	defer resp.Body.Close()
+	b.rec.observe("audio_ttfb", time.Since(start))
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(io.Discard, resp.Body)
+		b.rec.fail(fmt.Sprintf("音频请求返回 HTTP %d", resp.StatusCode))
+		return
+	}
+	n, err := io.Copy(io.Discard, resp.Body)
+	if err != nil {
+		b.rec.fail("音频下载中断")
+		return
+	}
+	b.rec.observe("audio_total", time.Since(start))
+	b.rec.add("audio_bytes", n)
+}
+
+// buzz 按设定的正确率作答：答对时选中正确的牌 (或"不在场上")，答错时随机选一个错误选项
+func (b *bot) buzz(round int) {
+	b.mu.Lock()
+	if b.round != round {
+		b.mu.Unlock()
+		return
+	}
+	var open []string
+	for _, c := range b.cards {
+		if !c.IsMatched {
+			open = append(open, c.ID)
+		}
+	}
+	b.mu.Unlock()
+
+	answer, known := b.orc.answer(b.roomID)
+	onBoard := false
+	for _, id := range open {
+		onBoard = onBoard || id == answer
+	}
+
+	// 选项里 "" 代表 "不在场上"
+	choice := ""
+	switch {
+	case !known:
+		b.rec.inc("buzz_guess")
+		if i := rand.Intn(len(open) + 1); i < len(open) {
+			choice = open[i]
+		}
+	case rand.Float64() < b.cfg.accuracy:
+		if onBoard {
+			choice = answer
+		}
+	default:
+		var wrong []string
+		for _, id := range open {
+			if id != answer {
+				wrong = append(wrong, id)
+			}
+		}
+		if onBoard {
+			wrong = append(wrong, "")
+		}
+		if len(wrong) > 0 {
+			choice = wrong[rand.Intn(len(wrong))]
+		}
+	}
+
+	b.mu.Lock()
+	if b.round != round {
+		b.mu.Unlock()
+		return
+	}
+	// "不在场上" 答对时服务端不会立即回应，只统计抢牌的往返延迟
+	if choice != "" {
+		b.buzzAt = time.Now()
+	}
+	b.mu.Unlock()
+
+	var err error
+	if choice == "" {
+		b.rec.inc("no_song")
+		err = b.send("no_song", nil)
+	} else {
+		b.rec.inc("buzzes")
+		err = b.send("buzz", map[string]interface{}{"cardId": choice})
+	}
+	if err != nil {
+		b.rec.fail("发送抢答失败")
+	}
+}
+
+// ackBuzz 收到抢答回应 (wrong_answer 或 round_end) 时记录往返延迟
+func (b *bot) ackBuzz() {
+	b.mu.Lock()
+	at := b.buzzAt
+	b.buzzAt = time.Time{}
+	b.mu.Unlock()
+	if !at.IsZero() {
+		b.rec.observe("buzz_ack", time.Since(at))
+	}
+}
+
+func decodeCards(v interface{}) []card {
+	data, _ := json.Marshal(v)
+	var cards []card
+	json.Unmarshal(data, &cards)
+	return cards
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// ==========================================
+// mgbot: 模拟玩家压测工具
+// 按房间启动若干机器人，完整走完 建房/加入/准备/开局/抢答 流程，
+// 结束后输出各环节延迟分位数与错误统计，用于摸清单节点能承载的房间与玩家上限
+//
+// 压测单机发起大量连接时，服务端需以 -ratelimit-exempt 放行压测机 IP，
+// 并用 -max-rooms 调高房间上限；要让机器人按 -accuracy 答对，服务端还需开启 -loadtest-answers
+// ==========================================
+
+func main() {
+	addr := flag.String("addr", "ws://127.0.0.1:3000/ws", "游戏服务 WebSocket 地址")
+	rooms := flag.Int("rooms", 2, "同时进行的房间数")
+	perRoom := flag.Int("per-room", 4, "每个房间的机器人数 (含房主，最多 8)")
+	mode := flag.String("mode", "vocaloid", "游戏模式: vocaloid / touhou")
+	games := flag.Int("games", 1, "每个房间连续进行的局数 (-duration 优先)")
+	duration := flag.Duration("duration", 0, "压测总时长，到时立即结束；0 表示打完 -games 局为止")
+	ramp := flag.Duration("ramp", 200*time.Millisecond, "相邻房间启动的间隔，避免瞬间建连")
+	accuracy := flag.Float64("accuracy", 0.6, "抢答正确率 (0~1)，需要能访问管理接口且服务端开启 -loadtest-answers 才能答对")
+	reactionKind := flag.String("reaction-dist", "lognormal", "反应时间分布: normal / uniform / exp / lognormal")
+	reactionMean := flag.Duration("reaction-mean", 3*time.Second, "平均反应时间")
+	reactionStd := flag.Duration("reaction-stddev", 1500*time.Millisecond, "反应时间标准差 (uniform 时为半宽)")
+	fetchAudio := flag.Bool("fetch-audio", true, "每局是否拉取 /api/audio")
+	adminAddr := flag.String("admin-addr", "", "管理接口地址，默认与游戏服务相同")
+	token := flag.String("token", os.Getenv("METAGARUTA_ADMIN_TOKEN"), "管理令牌，用于获取正确答案 (默认读取 METAGARUTA_ADMIN_TOKEN)")
+	jsonOut := flag.Bool("json", false, "以 JSON 输出压测结果")
+	flag.Parse()
+
+	if *perRoom < 1 || *perRoom > 8 {
+		fmt.Fprintln(os.Stderr, "-per-room 需在 1 到 8 之间")
+		os.Exit(2)
+	}
+	switch *reactionKind {
+	case "normal", "uniform", "exp", "lognormal":
+	default:
+		fmt.Fprintf(os.Stderr, "不支持的反应时间分布: %s\n", *reactionKind)
+		os.Exit(2)
+	}
+	httpBase, err := httpBaseFromWS(*addr)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if *adminAddr == "" {
+		*adminAddr = httpBase
+	}
+
+	cfg := &botConfig{
+		wsURL:      *addr,
+		httpBase:   httpBase,
+		mode:       *mode,
+		accuracy:   *accuracy,
+		reaction:   reactionDist{kind: *reactionKind, mean: *reactionMean, stddev: *reactionStd},
+		fetchAudio: *fetchAudio,
+	}
+	rec := newRecorder()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if *duration > 0 {
+		ctx, cancel = context.WithTimeout(ctx, *duration)
+		defer cancel()
+	}
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sigCh
+		fmt.Fprintln(os.Stderr, "\n收到中断信号，正在结束压测...")
+		cancel()
+	}()
+
+	stop := make(chan struct{})
+	orc := newOracle(strings.TrimSuffix(*adminAddr, "/"), *token, rec)
+	if err := orc.poll(); err != nil {
+		orc.unavailable(err)
+	}
+	go orc.run(300*time.Millisecond, stop)
+	go progress(rec, stop)
+
+	fmt.Fprintf(os.Stderr, "开始压测: %d 个房间 x %d 个机器人 -> %s\n", *rooms, *perRoom, *addr)
+	var wg sync.WaitGroup
+	for i := 0; i < *rooms; i++ {
+		wg.Add(1)
+		go func(slot int) {
+			defer wg.Done()
+			for g := 0; ctx.Err() == nil && (*duration > 0 || g < *games); g++ {
+				if err := runRoom(ctx, cfg, rec, orc, slot, g, *perRoom); err != nil && ctx.Err() == nil {
+					// 失败后稍等再重试，避免在服务端拒绝时空转
+					time.Sleep(time.Second)
+				}
+			}
+		}(i)
+		select {
+		case <-ctx.Done():
+		case <-time.After(*ramp):
+		}
+	}
+	wg.Wait()
+	close(stop)
+
+	rep := rec.summarize()
+	if *jsonOut {
+		rep.writeJSON(os.Stdout)
+	} else {
+		rep.writeText(os.Stdout)
+	}
+	if len(rep.Errors) > 0 {
+		os.Exit(1)
+	}
+}
+
+// runRoom 让一组机器人打一局：房主建房，其余机器人拿到房间号后加入
+func runRoom(ctx context.Context, cfg *botConfig, rec *recorder, orc *oracle, slot, game, players int) error {
+	roomCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	newBot := func(n int) *bot {
+		return &bot{
+			id:     fmt.Sprintf("mgbot-%d-%d-%d-%d", os.Getpid(), slot, game, n),
+			name:   fmt.Sprintf("bot%02d_%d", slot, n),
+			cfg:    cfg,
+			rec:    rec,
+			orc:    orc,
+			owner:  n == 0,
+			expect: players,
+		}
+	}
+
+	roomIDs := make(chan string, 1)
+	var wg sync.WaitGroup
+	var firstErr error
+	var errOnce sync.Once
+	finish := func(err error) {
+		if err == nil || roomCtx.Err() != nil {
+			return
+		}
+		rec.fail(err.Error())
+		errOnce.Do(func() {
+			firstErr = err
+			// 任一机器人失败，整个房间都无法继续，提前结束
+			cancel()
+		})
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		finish(newBot(0).play(roomCtx, roomIDs, ""))
+	}()
+
+	var roomID string
+	select {
+	case roomID = <-roomIDs:
+	case <-roomCtx.Done():
+	case <-time.After(15 * time.Second):
+		finish(fmt.Errorf("等待建房超时"))
+	}
+	if roomID != "" {
+		for n := 1; n < players; n++ {
+			wg.Add(1)
+			go func(n int) {
+				defer wg.Done()
+				finish(newBot(n).play(roomCtx, nil, roomID))
+			}(n)
+		}
+	}
+	wg.Wait()
+	return firstErr
+}
+
+// progress 每 5 秒向 stderr 打印一次进度
+func progress(rec *recorder, stop <-chan struct{}) {
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			fmt.Fprintf(os.Stderr, "[%s] 连接 %d  房间 %d  开局 %d  完成 %d  回合 %d  抢答 %d  错误 %d\n",
+				time.Now().Format("15:04:05"),
+				rec.counter("connections"), rec.counter("rooms_created"),
+				rec.counter("games_started"), rec.counter("games_finished"),
+				rec.counter("rounds"), rec.counter("buzzes")+rec.counter("no_song"),
+				rec.errorCount())
+		}
+	}
+}
+
+// httpBaseFromWS 由 ws(s)://host/ws 推出 http(s)://host
+func httpBaseFromWS(addr string) (string, error) {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return "", fmt.Errorf("无效的地址 %q: %v", addr, err)
+	}
+	switch u.Scheme {
+	case "ws":
+		u.Scheme = "http"
+	case "wss":
+		u.Scheme = "https"
+	default:
+		return "", fmt.Errorf("地址需以 ws:// 或 wss:// 开头: %s", addr)
+	}
+	u.Path, u.RawQuery = "", ""
+	return strings.TrimSuffix(u.String(), "/"), nil
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// ==========================================
+// 答案来源：轮询管理接口 /api/admin/status 得到各房间本局正确歌牌的 ID (answerCardId)
+// 服务端需以 -loadtest-answers 启动才会公开答案，且需配置管理令牌 (或服务端以 -admin-allow-local 启动且本机运行)，
+// 机器人才能按 -accuracy 答对，否则只能盲猜
+// 管理接口不可用属于预期内的降级，只提示一次并计入 oracle_unavailable，不算压测错误
+// ==========================================
+
+type oracle struct {
+	base   string
+	token  string
+	client *http.Client
+	rec    *recorder
+
+	mu        sync.Mutex
+	songs     map[string]string // roomId -> answerCardId
+	available bool
+	warned    bool
+}
+
+func newOracle(base, token string, rec *recorder) *oracle {
+	return &oracle{
+		base:   base,
+		token:  token,
+		client: &http.Client{Timeout: 3 * time.Second},
+		rec:    rec,
+		songs:  make(map[string]string),
+	}
+}
+
+// run 持续轮询直到 stop 关闭
+func (o *oracle) run(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := o.poll(); err != nil {
+			o.unavailable(err)
+		}
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// unavailable 记录一次轮询失败，机器人在恢复前改为盲猜
+func (o *oracle) unavailable(err error) {
+	o.rec.inc("oracle_unavailable")
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.available = false
+	if !o.warned {
+		o.warned = true
+		fmt.Fprintf(os.Stderr, "警告: 无法访问管理接口 (%v)，机器人将随机作答\n", err)
+	}
+}
+
+func (o *oracle) poll() error {
+	req, err := http.NewRequest(http.MethodGet, o.base+"/api/admin/status", nil)
+	if err != nil {
+		return err
+	}
+	if o.token != "" {
+		req.Header.Set("Authorization", "Bearer "+o.token)
+	}
+	start := time.Now()
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("管理接口返回 HTTP %d", resp.StatusCode)
+	}
+	var status struct {
+		Answers bool `json:"loadtestAnswers"`
+		Rooms   []struct {
+			ID           string `json:"id"`
+			AnswerCardID string `json:"answerCardId"`
+		} `json:"rooms"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		return err
+	}
+	o.rec.observe("admin_status", time.Since(start))
+	if !status.Answers {
+		return fmt.Errorf("服务端未开启 -loadtest-answers")
+	}
+
+	songs := make(map[string]string, len(status.Rooms))
+	for _, rm := range status.Rooms {
+		songs[rm.ID] = rm.AnswerCardID
+	}
+	o.mu.Lock()
+	o.songs = songs
+	o.available = true
+	o.mu.Unlock()
+	return nil
+}
+
+// answer 返回房间本局正确歌牌的 ID；管理接口不可用时 ok 为 false
+func (o *oracle) answer(roomID string) (string, bool) {
+	if o == nil {
+		return "", false
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	id, ok := o.songs[roomID]
+	return id, o.available && ok && id != ""
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ==========================================
+// 压测统计：延迟分位数、计数器与错误汇总
+// ==========================================
+
+type recorder struct {
+	mu       sync.Mutex
+	started  time.Time
+	samples  map[string][]time.Duration
+	counters map[string]int64
+	errors   map[string]int64
+}
+
+func newRecorder() *recorder {
+	return &recorder{
+		started:  time.Now(),
+		samples:  make(map[string][]time.Duration),
+		counters: make(map[string]int64),
+		errors:   make(map[string]int64),
+	}
+}
+
+// observe 记录一次延迟样本
+func (r *recorder) observe(metric string, d time.Duration) {
+	r.mu.Lock()
+	r.samples[metric] = append(r.samples[metric], d)
+	r.mu.Unlock()
+}
+
+func (r *recorder) add(counter string, n int64) {
+	r.mu.Lock()
+	r.counters[counter] += n
+	r.mu.Unlock()
+}
+
+func (r *recorder) inc(counter string) { r.add(counter, 1) }
+
+// fail 记录一个错误，kind 相同的错误合并计数
+func (r *recorder) fail(kind string) {
+	r.mu.Lock()
+	r.errors[kind]++
+	r.mu.Unlock()
+}
+
+func (r *recorder) counter(name string) int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.counters[name]
+}
+
+func (r *recorder) errorCount() int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var n int64
+	for _, c := range r.errors {
+		n += c
+	}
+	return n
+}
+
+type latencySummary struct {
+	Metric string  `json:"metric"`
+	Count  int     `json:"count"`
+	P50    float64 `json:"p50Ms"`
+	P90    float64 `json:"p90Ms"`
+	P99    float64 `json:"p99Ms"`
+	Max    float64 `json:"maxMs"`
+}
+
+type errorSummary struct {
+	Kind  string `json:"kind"`
+	Count int64  `json:"count"`
+}
+
+type report struct {
+	ElapsedSec float64          `json:"elapsedSec"`
+	Latencies  []latencySummary `json:"latencies"`
+	Counters   map[string]int64 `json:"counters"`
+	Errors     []errorSummary   `json:"errors"`
+}
+
+func (r *recorder) summarize() report {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	rep := report{
+		ElapsedSec: time.Since(r.started).Seconds(),
+		Latencies:  make([]latencySummary, 0, len(r.samples)),
+		Counters:   make(map[string]int64, len(r.counters)),
+		Errors:     make([]errorSummary, 0, len(r.errors)),
+	}
+	for metric, samples := range r.samples {
+		sorted := make([]time.Duration, len(samples))
+		copy(sorted, samples)
+		sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+		rep.Latencies = append(rep.Latencies, latencySummary{
+			Metric: metric,
+			Count:  len(sorted),
+			P50:    ms(percentile(sorted, 0.50)),
+			P90:    ms(percentile(sorted, 0.90)),
+			P99:    ms(percentile(sorted, 0.99)),
+			Max:    ms(sorted[len(sorted)-1]),
+		})
+	}
+	sort.Slice(rep.Latencies, func(i, j int) bool { return rep.Latencies[i].Metric < rep.Latencies[j].Metric })
+	for k, v := range r.counters {
+		rep.Counters[k] = v
+	}
+	for kind, n := range r.errors {
+		rep.Errors = append(rep.Errors, errorSummary{Kind: kind, Count: n})
+	}
+	sort.Slice(rep.Errors, func(i, j int) bool { return rep.Errors[i].Count > rep.Errors[j].Count })
+	return rep
+}
+
+// percentile 取已排序样本的分位数 (最近秩法)
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	idx := int(float64(len(sorted))*p+0.5) - 1
+	if idx < 0 {
+		idx = 0
+	}
+	if idx >= len(sorted) {
+		idx = len(sorted) - 1
+	}
+	return sorted[idx]
+}
+
+func ms(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+func (rep report) writeJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+	return enc.Encode(rep)
+}
+
+func (rep report) writeText(w io.Writer) {
+	fmt.Fprintf(w, "\n========== 压测结果 (%.1f 秒) ==========\n", rep.ElapsedSec)
+
+	fmt.Fprintf(w, "\n%-14s %6s %10s %10s %10s %10s\n", "延迟(ms)", "样本", "p50", "p90", "p99", "max")
+	for _, l := range rep.Latencies {
+		fmt.Fprintf(w, "%-16s %8d %10.1f %10.1f %10.1f %10.1f\n", l.Metric, l.Count, l.P50, l.P90, l.P99, l.Max)
+	}
+
+	fmt.Fprintln(w, "\n计数:")
+	names := make([]string, 0, len(rep.Counters))
+	for k := range rep.Counters {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	for _, k := range names {
+		fmt.Fprintf(w, "  %-22s %d\n", k, rep.Counters[k])
+	}
+
+	if len(rep.Errors) == 0 {
+		fmt.Fprintln(w, "\n错误: 无")
+		return
+	}
+	fmt.Fprintln(w, "\n错误:")
+	for _, e := range rep.Errors {
+		fmt.Fprintf(w, "  %6d  %s\n", e.Count, strings.ReplaceAll(e.Kind, "\n", " "))
+	}
+}
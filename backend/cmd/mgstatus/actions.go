@@ -0,0 +1,158 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// ==========================================
+// 运维操作子命令：close-room / kick / announce / reload-catalog / delete-pack
+// 调用服务端需要认证的管理接口，并打印操作结果
+// ==========================================
+
+func init() {
+	subcommands["close-room"] = cmdCloseRoom
+	subcommands["kick"] = cmdKick
+	subcommands["announce"] = cmdAnnounce
+	subcommands["reload-catalog"] = cmdReloadCatalog
+	subcommands["delete-pack"] = cmdDeletePack
+}
+
+// actionRequest 对应服务端的 adminRequest
+type actionRequest struct {
+	RoomID   string `json:"roomId,omitempty"`
+	PlayerID string `json:"playerId,omitempty"`
+	Ban      bool   `json:"ban,omitempty"`
+	Reason   string `json:"reason,omitempty"`
+	Message  string `json:"message,omitempty"`
+	PackID   string `json:"packId,omitempty"`
+}
+
+// runAction 发送请求并按输出格式打印结果，返回退出码
+func runAction(c commonFlags, path string, body actionRequest) int {
+	res, err := newAdminClient(c.addr, c.token).postAction(path, body)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		var nf *errNotFound
+		if errors.As(err, &nf) {
+			return exitNotFound
+		}
+		return exitError
+	}
+	if c.output == "table" {
+		fmt.Println("✅ " + res.Message)
+		return exitOK
+	}
+	tab := tabular{headers: []string{"OK", "MESSAGE"}, rows: [][]string{{"true", res.Message}}}
+	if err := writeOutput(os.Stdout, c.output, res, tab); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitError
+	}
+	return exitOK
+}
+
+func cmdCloseRoom(args []string, defaults commonFlags) int {
+	var c commonFlags
+	var reason string
+	positional, ok := parseSubcommand("close-room", args, defaults, &c, func(fs *flag.FlagSet) {
+		fs.StringVar(&reason, "reason", "", "发给房间内玩家的关闭原因")
+	})
+	if !ok {
+		return exitUsage
+	}
+	if len(positional) != 1 {
+		fmt.Fprintln(os.Stderr, "用法: mgstatus close-room <房间号> [--reason 原因]")
+		return exitUsage
+	}
+	return runAction(c, "/api/admin/rooms/close", actionRequest{RoomID: positional[0], Reason: reason})
+}
+
+func cmdKick(args []string, defaults commonFlags) int {
+	var c commonFlags
+	var ban bool
+	positional, ok := parseSubcommand("kick", args, defaults, &c, func(fs *flag.FlagSet) {
+		fs.BoolVar(&ban, "ban", false, "同时封禁该玩家，使其无法再加入此房间")
+	})
+	if !ok {
+		return exitUsage
+	}
+	if len(positional) != 2 {
+		fmt.Fprintln(os.Stderr, "用法: mgstatus kick <房间号> <玩家ID或昵称> [--ban]")
+		return exitUsage
+	}
+	roomID := strings.ToUpper(positional[0])
+	playerID, code := resolvePlayer(c, roomID, positional[1])
+	if code != exitOK {
+		return code
+	}
+	return runAction(c, "/api/admin/rooms/kick", actionRequest{RoomID: roomID, PlayerID: playerID, Ban: ban})
+}
+
+// resolvePlayer 允许按昵称指定玩家：房间内有同名玩家时换成其 ID，否则原样作为 ID 使用
+func resolvePlayer(c commonFlags, roomID, who string) (string, int) {
+	status, code := fetchOrExit(c)
+	if code != exitOK {
+		return "", code
+	}
+	for _, rm := range status.Rooms {
+		if rm.ID != roomID {
+			continue
+		}
+		for _, p := range rm.Players {
+			if p.ID == who {
+				return p.ID, exitOK
+			}
+		}
+		for _, p := range rm.Players {
+			if p.Name == who {
+				return p.ID, exitOK
+			}
+		}
+		return who, exitOK
+	}
+	fmt.Fprintf(os.Stderr, "房间 [%s] 不存在\n", roomID)
+	return "", exitNotFound
+}
+
+func cmdAnnounce(args []string, defaults commonFlags) int {
+	var c commonFlags
+	positional, ok := parseSubcommand("announce", args, defaults, &c, nil)
+	if !ok {
+		return exitUsage
+	}
+	message := strings.TrimSpace(strings.Join(positional, " "))
+	if message == "" {
+		fmt.Fprintln(os.Stderr, "用法: mgstatus announce \"公告内容\"")
+		return exitUsage
+	}
+	return runAction(c, "/api/admin/announce", actionRequest{Message: message})
+}
+
+func cmdReloadCatalog(args []string, defaults commonFlags) int {
+	var c commonFlags
+	positional, ok := parseSubcommand("reload-catalog", args, defaults, &c, nil)
+	if !ok {
+		return exitUsage
+	}
+	if len(positional) != 0 {
+		fmt.Fprintln(os.Stderr, "用法: mgstatus reload-catalog")
+		return exitUsage
+	}
+	return runAction(c, "/api/admin/catalog/reload", actionRequest{})
+}
+
+func cmdDeletePack(args []string, defaults commonFlags) int {
+	var c commonFlags
+	positional, ok := parseSubcommand("delete-pack", args, defaults, &c, nil)
+	if !ok {
+		return exitUsage
+	}
+	if len(positional) != 1 {
+		fmt.Fprintln(os.Stderr, "用法: mgstatus delete-pack <曲包ID>")
+		return exitUsage
+	}
+	return runAction(c, "/api/admin/packs/delete", actionRequest{PackID: strings.TrimPrefix(positional[0], "pack:")})
+}
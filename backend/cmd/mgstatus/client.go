@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// adminClient 访问游戏服务的管理接口
+type adminClient struct {
+	base  string
+	token string
+	http  *http.Client
+}
+
+// newAdminClient 支持 http(s)://host:port、host:port 与 unix:/path 三种地址
+func newAdminClient(addr, token string) *adminClient {
+	c := &adminClient{token: token, http: &http.Client{Timeout: 5 * time.Second}}
+	switch {
+	case strings.HasPrefix(addr, "unix:"):
+		path := strings.TrimPrefix(addr, "unix:")
+		c.base = "http://unix"
+		c.http.Transport = &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", path)
+			},
+		}
+	case strings.HasPrefix(addr, "http://"), strings.HasPrefix(addr, "https://"):
+		c.base = strings.TrimSuffix(addr, "/")
+	default:
+		c.base = "http://" + strings.TrimSuffix(addr, "/")
+	}
+	return c
+}
+
+func (c *adminClient) newRequest(method, path string) (*http.Request, error) {
+	req, err := http.NewRequest(method, c.base+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	return req, nil
+}
+
+// getJSON 发起 GET 请求并解析 JSON 响应
+func (c *adminClient) getJSON(path string, out interface{}) error {
+	req, err := c.newRequest(http.MethodGet, path)
+	if err != nil {
+		return err
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("无法连接到游戏服务: %v\n   请确认游戏服务正在运行 (%s)", err, c.base)
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized:
+		return fmt.Errorf("请求失败: HTTP 401，请通过 -token 或 METAGARUTA_ADMIN_TOKEN 提供管理令牌")
+	case resp.StatusCode != http.StatusOK:
+		return fmt.Errorf("请求失败: HTTP %d", resp.StatusCode)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("解析响应失败: %v", err)
+	}
+	return nil
+}
+
+func (c *adminClient) fetchStatus() (StatusResponse, error) {
+	var status StatusResponse
+	err := c.getJSON("/api/admin/status", &status)
+	return status, err
+}
+
+// adminResult 是服务端管理操作的统一响应
+type adminResult struct {
+	OK      bool   `json:"ok"`
+	Message string `json:"message"`
+}
+
+// errNotFound 表示服务端返回 404 (房间或玩家不存在)
+type errNotFound struct{ msg string }
+
+func (e *errNotFound) Error() string { return e.msg }
+
+// postAction 以 JSON 请求体调用管理操作；非 2xx 响应转换为错误
+func (c *adminClient) postAction(path string, body interface{}) (adminResult, error) {
+	var res adminResult
+	data, err := json.Marshal(body)
+	if err != nil {
+		return res, err
+	}
+	req, err := c.newRequest(http.MethodPost, path)
+	if err != nil {
+		return res, err
+	}
+	req.Body = io.NopCloser(bytes.NewReader(data))
+	req.ContentLength = int64(len(data))
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return res, fmt.Errorf("无法连接到游戏服务: %v\n   请确认游戏服务正在运行 (%s)", err, c.base)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusUnauthorized {
+		return res, fmt.Errorf("请求失败: HTTP 401，请通过 -token 或 METAGARUTA_ADMIN_TOKEN 提供管理令牌")
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return res, fmt.Errorf("请求失败: HTTP %d", resp.StatusCode)
+	}
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return res, &errNotFound{msg: res.Message}
+	case resp.StatusCode != http.StatusOK || !res.OK:
+		return res, fmt.Errorf("操作失败: %s", res.Message)
+	}
+	return res, nil
+}
+
+// streamEvents 订阅管理事件流，逐条回调；连接断开或出错时返回
+// 流式请求不能沿用带整体超时的 http.Client
+func (c *adminClient) streamEvents(query string, lastID uint64, fn func(ev Event)) error {
+	req, err := c.newRequest(http.MethodGet, "/api/admin/events?"+query)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	if lastID > 0 {
+		req.Header.Set("Last-Event-ID", strconv.FormatUint(lastID, 10))
+	}
+	resp, err := (&http.Client{Transport: c.http.Transport}).Do(req)
+	if err != nil {
+		return fmt.Errorf("无法连接到游戏服务: %v", err)
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized:
+		return fmt.Errorf("请求失败: HTTP 401，请通过 -token 或 METAGARUTA_ADMIN_TOKEN 提供管理令牌")
+	case resp.StatusCode != http.StatusOK:
+		return fmt.Errorf("请求失败: HTTP %d", resp.StatusCode)
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64<<10), 1<<20)
+	var event, data string
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case line == "":
+			if event == "lagged" {
+				fmt.Fprintf(os.Stderr, "警告: 事件消费过慢，服务端丢弃了部分事件 %s\n", data)
+			} else if data != "" {
+				var ev Event
+				if err := json.Unmarshal([]byte(data), &ev); err == nil {
+					fn(ev)
+				}
+			}
+			event, data = "", ""
+		case strings.HasPrefix(line, "event:"):
+			event = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+		case strings.HasPrefix(line, "data:"):
+			data += strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return io.ErrUnexpectedEOF
+}
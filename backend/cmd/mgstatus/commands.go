@@ -0,0 +1,307 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ==========================================
+// 子命令：rooms / room <id> / players / catalog
+// 退出码供监控脚本使用
+// ==========================================
+
+const (
+	exitOK        = 0
+	exitError     = 1 // 无法连接或请求失败
+	exitUsage     = 2 // 参数错误
+	exitUnhealthy = 3 // 检查未通过，如题库为空
+	exitNotFound  = 4 // 指定的房间不存在
+)
+
+// commonFlags 是每个子命令都接受的参数，未指定时沿用全局参数
+type commonFlags struct {
+	addr   string
+	token  string
+	output string
+	mode   string
+	state  string
+}
+
+func (c *commonFlags) register(fs *flag.FlagSet, defaults commonFlags) {
+	fs.StringVar(&c.addr, "addr", defaults.addr, "游戏服务(或管理接口)地址")
+	fs.StringVar(&c.token, "token", defaults.token, "管理令牌")
+	fs.StringVar(&c.output, "output", "table", "输出格式: "+strings.Join(outputFormats, "|"))
+	fs.StringVar(&c.mode, "mode", "", "按游戏模式过滤 (vocaloid / touhou)")
+	fs.StringVar(&c.state, "state", "", "按房间状态过滤 (waiting / playing)")
+}
+
+func (c *commonFlags) validate() error {
+	for _, f := range outputFormats {
+		if c.output == f {
+			return nil
+		}
+	}
+	return fmt.Errorf("不支持的输出格式: %s (可选: %s)", c.output, strings.Join(outputFormats, ", "))
+}
+
+func (c *commonFlags) matchRoom(rm RoomInfo) bool {
+	if c.mode != "" && !strings.EqualFold(rm.GameMode, c.mode) {
+		return false
+	}
+	if c.state != "" && !strings.EqualFold(rm.State, c.state) {
+		return false
+	}
+	return true
+}
+
+// subcommand 执行后返回退出码
+type subcommand func(args []string, defaults commonFlags) int
+
+var subcommands = map[string]subcommand{
+	"rooms":   cmdRooms,
+	"room":    cmdRoom,
+	"players": cmdPlayers,
+	"catalog": cmdCatalog,
+}
+
+// parseSubcommand 解析子命令参数；位置参数可以出现在选项之前或之后
+// extra 用于注册子命令特有的选项，可为 nil
+func parseSubcommand(name string, args []string, defaults commonFlags, c *commonFlags, extra func(fs *flag.FlagSet)) ([]string, bool) {
+	fs := flag.NewFlagSet("mgstatus "+name, flag.ContinueOnError)
+	c.register(fs, defaults)
+	if extra != nil {
+		extra(fs)
+	}
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, false
+		}
+		if fs.NArg() == 0 {
+			break
+		}
+		positional = append(positional, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+	if err := c.validate(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return nil, false
+	}
+	return positional, true
+}
+
+func fetchOrExit(c commonFlags) (StatusResponse, int) {
+	status, err := newAdminClient(c.addr, c.token).fetchStatus()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return status, exitError
+	}
+	return status, exitOK
+}
+
+func roomRow(rm RoomInfo) []string {
+	return []string{
+		rm.ID,
+		rm.GameMode,
+		rm.State,
+		strconv.Itoa(rm.CurrentRound),
+		rm.RoundState,
+		strconv.FormatBool(rm.Paused),
+		strconv.Itoa(rm.PlayerCount),
+		fmt.Sprintf("%d/%d", rm.MatchedCards, rm.BoardCards),
+		strconv.Itoa(rm.SongPoolSize),
+		strconv.FormatBool(rm.Private),
+	}
+}
+
+var roomHeaders = []string{"ID", "MODE", "STATE", "ROUND", "ROUND_STATE", "PAUSED", "PLAYERS", "MATCHED", "POOL", "PRIVATE"}
+
+func cmdRooms(args []string, defaults commonFlags) int {
+	var c commonFlags
+	if _, ok := parseSubcommand("rooms", args, defaults, &c, nil); !ok {
+		return exitUsage
+	}
+	status, code := fetchOrExit(c)
+	if code != exitOK {
+		return code
+	}
+
+	rooms := make([]RoomInfo, 0)
+	tab := tabular{headers: roomHeaders}
+	sortRooms(status.Rooms)
+	for _, rm := range status.Rooms {
+		if c.matchRoom(rm) {
+			rooms = append(rooms, rm)
+			tab.rows = append(tab.rows, roomRow(rm))
+		}
+	}
+	if err := writeOutput(os.Stdout, c.output, rooms, tab); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitError
+	}
+	return exitOK
+}
+
+func cmdRoom(args []string, defaults commonFlags) int {
+	var c commonFlags
+	positional, ok := parseSubcommand("room", args, defaults, &c, nil)
+	if !ok {
+		return exitUsage
+	}
+	if len(positional) != 1 {
+		fmt.Fprintln(os.Stderr, "用法: mgstatus room <房间号> [--output json|yaml|table|csv]")
+		return exitUsage
+	}
+	roomID := strings.ToUpper(positional[0])
+
+	status, code := fetchOrExit(c)
+	if code != exitOK {
+		return code
+	}
+	for _, rm := range status.Rooms {
+		if rm.ID != roomID {
+			continue
+		}
+		if c.output == "table" {
+			printRoom(rm)
+			return exitOK
+		}
+		if err := writeOutput(os.Stdout, c.output, rm, playerTable([]RoomInfo{rm})); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitError
+		}
+		return exitOK
+	}
+	fmt.Fprintf(os.Stderr, "房间 [%s] 不存在\n", roomID)
+	return exitNotFound
+}
+
+// playerRecord 是 players 子命令的一行，带上所在房间
+type playerRecord struct {
+	RoomID      string `json:"roomId"`
+	GameMode    string `json:"gameMode"`
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	Score       int    `json:"score"`
+	HasAnswered bool   `json:"hasAnswered"`
+	GameReady   bool   `json:"gameReady"`
+	IsOwner     bool   `json:"isOwner"`
+}
+
+func playerRecords(rooms []RoomInfo) []playerRecord {
+	records := make([]playerRecord, 0)
+	for _, rm := range rooms {
+		for _, p := range rm.Players {
+			records = append(records, playerRecord{
+				RoomID:      rm.ID,
+				GameMode:    rm.GameMode,
+				ID:          p.ID,
+				Name:        p.Name,
+				Score:       p.Score,
+				HasAnswered: p.HasAnswered,
+				GameReady:   p.GameReady,
+				IsOwner:     p.ID == rm.OwnerID,
+			})
+		}
+	}
+	return records
+}
+
+func playerTable(rooms []RoomInfo) tabular {
+	tab := tabular{headers: []string{"ROOM", "MODE", "ID", "NAME", "SCORE", "ANSWERED", "READY", "OWNER"}}
+	for _, p := range playerRecords(rooms) {
+		tab.rows = append(tab.rows, []string{
+			p.RoomID, p.GameMode, p.ID, p.Name, strconv.Itoa(p.Score),
+			strconv.FormatBool(p.HasAnswered), strconv.FormatBool(p.GameReady), strconv.FormatBool(p.IsOwner),
+		})
+	}
+	return tab
+}
+
+func cmdPlayers(args []string, defaults commonFlags) int {
+	var c commonFlags
+	if _, ok := parseSubcommand("players", args, defaults, &c, nil); !ok {
+		return exitUsage
+	}
+	status, code := fetchOrExit(c)
+	if code != exitOK {
+		return code
+	}
+
+	var rooms []RoomInfo
+	sortRooms(status.Rooms)
+	for _, rm := range status.Rooms {
+		if c.matchRoom(rm) {
+			rooms = append(rooms, rm)
+		}
+	}
+	if err := writeOutput(os.Stdout, c.output, playerRecords(rooms), playerTable(rooms)); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitError
+	}
+	return exitOK
+}
+
+// catalogInfo 是 catalog 子命令的输出
+type catalogInfo struct {
+	Catalogs map[string]int `json:"catalogs"`
+	Healthy  bool           `json:"healthy"`
+}
+
+// catalogCounts 返回各模式的题库规模；旧版服务端没有 catalogs 字段时退回固定的两个题库
+func catalogCounts(s StatusResponse) map[string]int {
+	if len(s.Catalogs) > 0 {
+		return s.Catalogs
+	}
+	return map[string]int{"vocaloid": s.VocaloidSongs, "touhou": s.TouhouChars}
+}
+
+func catalogNames(s StatusResponse) []string {
+	counts := catalogCounts(s)
+	names := make([]string, 0, len(counts))
+	for name := range counts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// cmdCatalog 输出题库规模；任一题库为空 (或低于 --min) 时以 exitUnhealthy 退出
+func cmdCatalog(args []string, defaults commonFlags) int {
+	var c commonFlags
+	min := 1
+	if _, ok := parseSubcommand("catalog", args, defaults, &c, func(fs *flag.FlagSet) {
+		fs.IntVar(&min, "min", 1, "每个题库至少应有的条目数，低于该值视为不健康")
+	}); !ok {
+		return exitUsage
+	}
+	status, code := fetchOrExit(c)
+	if code != exitOK {
+		return code
+	}
+
+	info := catalogInfo{Catalogs: catalogCounts(status), Healthy: true}
+	tab := tabular{headers: []string{"CATALOG", "COUNT"}}
+	var low []string
+	for _, name := range catalogNames(status) {
+		n := info.Catalogs[name]
+		tab.rows = append(tab.rows, []string{name, strconv.Itoa(n)})
+		if n < min {
+			info.Healthy = false
+			low = append(low, fmt.Sprintf("%s=%d", name, n))
+		}
+	}
+	if err := writeOutput(os.Stdout, c.output, info, tab); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitError
+	}
+	if !info.Healthy {
+		fmt.Fprintf(os.Stderr, "题库不健康: %s (要求至少 %d)\n", strings.Join(low, " "), min)
+		return exitUnhealthy
+	}
+	return exitOK
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ==========================================
+// events 子命令：实时打印服务端的管理事件流
+// 连接断开后自动重连，并从最后收到的事件继续
+// ==========================================
+
+func init() {
+	subcommands["events"] = cmdEvents
+}
+
+// Event 对应服务端推送的一条管理事件
+type Event struct {
+	ID     uint64                 `json:"id"`
+	Time   string                 `json:"time"`
+	Type   string                 `json:"type"`
+	RoomID string                 `json:"roomId,omitempty"`
+	Data   map[string]interface{} `json:"data,omitempty"`
+}
+
+func cmdEvents(args []string, defaults commonFlags) int {
+	var c commonFlags
+	var room, types string
+	positional, ok := parseSubcommand("events", args, defaults, &c, func(fs *flag.FlagSet) {
+		fs.StringVar(&room, "room", "", "只看指定房间 (逗号分隔)")
+		fs.StringVar(&types, "type", "", "只看指定事件类型 (逗号分隔)，如 round_ended,buzz")
+	})
+	if !ok {
+		return exitUsage
+	}
+	if len(positional) > 0 && room == "" {
+		room = strings.Join(positional, ",")
+	}
+
+	q := url.Values{}
+	if room != "" {
+		q.Set("room", strings.ToUpper(room))
+	}
+	if types != "" {
+		q.Set("type", types)
+	}
+
+	client := newAdminClient(c.addr, c.token)
+	printer := newEventPrinter(c.output)
+	var lastID uint64
+	for {
+		err := client.streamEvents(q.Encode(), lastID, func(ev Event) {
+			lastID = ev.ID
+			printer(ev)
+		})
+		if strings.Contains(err.Error(), "HTTP 401") {
+			fmt.Fprintln(os.Stderr, err)
+			return exitError
+		}
+		fmt.Fprintf(os.Stderr, "事件流断开 (%v)，3 秒后重连...\n", err)
+		time.Sleep(3 * time.Second)
+	}
+}
+
+// newEventPrinter 按输出格式逐条打印事件；json 为每行一个对象，yaml 以 --- 分隔
+func newEventPrinter(format string) func(ev Event) {
+	switch format {
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetEscapeHTML(false)
+		return func(ev Event) { enc.Encode(ev) }
+	case "yaml":
+		return func(ev Event) {
+			fmt.Println("---")
+			writeYAML(os.Stdout, ev)
+		}
+	case "csv":
+		cw := csv.NewWriter(os.Stdout)
+		cw.Write([]string{"ID", "TIME", "ROOM", "TYPE", "DATA"})
+		cw.Flush()
+		return func(ev Event) {
+			data, _ := json.Marshal(ev.Data)
+			cw.Write([]string{fmt.Sprint(ev.ID), ev.Time, ev.RoomID, ev.Type, string(data)})
+			cw.Flush()
+		}
+	}
+	return func(ev Event) {
+		room := ev.RoomID
+		if room == "" {
+			room = "-"
+		}
+		fmt.Printf("%s  %s  %s  %s\n", ev.Time, padRight(room, 5), padRight(ev.Type, 14), eventDetail(ev.Data))
+	}
+}
+
+// eventDetail 把事件数据排成 key=value，便于人工阅读
+func eventDetail(data map[string]interface{}) string {
+	keys := make([]string, 0, len(data))
+	for k := range data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		v := data[k]
+		if m, ok := v.(map[string]interface{}); ok {
+			b, _ := json.Marshal(m)
+			v = string(b)
+		}
+		parts = append(parts, fmt.Sprintf("%s=%v", k, v))
+	}
+	return strings.Join(parts, " ")
+}
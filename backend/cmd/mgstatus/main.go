@@ -1,11 +1,11 @@
 package main
 
 import (
-	"encoding/json"
+	"flag"
 	"fmt"
-	"net/http"
 	"os"
 	"strings"
+	"time"
 )
 
 type PlayerInfo struct {
@@ -20,8 +20,11 @@ type RoomInfo struct {
 	ID           string       `json:"id"`
 	OwnerID      string       `json:"ownerId"`
 	GameMode     string       `json:"gameMode"`
+	Private      bool         `json:"private"`
 	State        string       `json:"state"`
 	RoundState   string       `json:"roundState"`
+	Paused       bool         `json:"paused"`
+	PhaseLeftMs  int64        `json:"phaseRemainingMs"`
 	CurrentRound int          `json:"currentRound"`
 	PlayerCount  int          `json:"playerCount"`
 	Players      []PlayerInfo `json:"players"`
@@ -31,40 +34,98 @@ type RoomInfo struct {
 	CurrentSong  string       `json:"currentSong,omitempty"`
 }
 
+type AbuseStats struct {
+	Warned        int64 `json:"warned"`
+	Dropped       int64 `json:"dropped"`
+	Disconnected  int64 `json:"disconnected"`
+	Banned        int64 `json:"banned"`
+	RejectedConns int64 `json:"rejectedConns"`
+	OversizedMsgs int64 `json:"oversizedMessages"`
+}
+
 type StatusResponse struct {
-	Timestamp     string     `json:"timestamp"`
-	TotalRooms    int        `json:"totalRooms"`
-	TotalPlayers  int        `json:"totalPlayers"`
-	VocaloidSongs int        `json:"vocaloidSongs"`
-	TouhouChars   int        `json:"touhouChars"`
-	Rooms         []RoomInfo `json:"rooms"`
+	Timestamp     string         `json:"timestamp"`
+	NodeID        string         `json:"nodeId"`
+	TotalRooms    int            `json:"totalRooms"`
+	MaxRooms      int            `json:"maxRooms"`
+	TotalPlayers  int            `json:"totalPlayers"`
+	VocaloidSongs int            `json:"vocaloidSongs"`
+	TouhouChars   int            `json:"touhouChars"`
+	Catalogs      map[string]int `json:"catalogs"`
+	Abuse         AbuseStats     `json:"abuse"`
+	BannedIPs     int            `json:"bannedIps"`
+	Rooms         []RoomInfo     `json:"rooms"`
 }
 
 func main() {
-	url := "http://127.0.0.1:3000/api/admin/status"
+	addr := flag.String("addr", envOr("METAGARUTA_ADDR", "http://127.0.0.1:3000"), "游戏服务(或管理接口)地址，如 http://127.0.0.1:3000 或 unix:/run/metagaruta/admin.sock")
+	token := flag.String("token", os.Getenv("METAGARUTA_ADMIN_TOKEN"), "管理令牌 (默认读取 METAGARUTA_ADMIN_TOKEN)")
+	watch := flag.Bool("watch", false, "全屏实时刷新模式")
+	interval := flag.Duration("interval", time.Second, "watch 模式下的刷新间隔")
+	flag.Usage = usage
+	flag.Parse()
 
-	resp, err := http.Get(url)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "无法连接到游戏服务: %v\n", err)
-		fmt.Fprintln(os.Stderr, "   请确认游戏服务正在运行 (端口 3000)")
-		os.Exit(1)
+	if flag.NArg() > 0 {
+		cmd, ok := subcommands[flag.Arg(0)]
+		if !ok {
+			fmt.Fprintf(os.Stderr, "未知子命令: %s\n\n", flag.Arg(0))
+			usage()
+			os.Exit(exitUsage)
+		}
+		os.Exit(cmd(flag.Args()[1:], commonFlags{addr: *addr, token: *token}))
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != 200 {
-		fmt.Fprintf(os.Stderr, "请求失败: HTTP %d\n", resp.StatusCode)
-		os.Exit(1)
+	client := newAdminClient(*addr, *token)
+
+	if *watch {
+		if err := runWatch(client, *interval); err != nil {
+			fmt.Fprintf(os.Stderr, "watch 模式异常退出: %v\n", err)
+			os.Exit(1)
+		}
+		return
 	}
 
-	var status StatusResponse
-	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
-		fmt.Fprintf(os.Stderr, "解析响应失败: %v\n", err)
+	status, err := client.fetchStatus()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
 	}
-
 	printStatus(status)
 }
 
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintln(out, "用法: mgstatus [全局选项] [子命令] [选项]")
+	fmt.Fprintln(out, "")
+	fmt.Fprintln(out, "不带子命令时打印完整状态，或以 -watch 进入实时面板。")
+	fmt.Fprintln(out, "")
+	fmt.Fprintln(out, "子命令:")
+	fmt.Fprintln(out, "  rooms              列出房间")
+	fmt.Fprintln(out, "  room <房间号>      查看单个房间 (不存在时退出码 4)")
+	fmt.Fprintln(out, "  players            列出所有玩家")
+	fmt.Fprintln(out, "  catalog            题库规模 (题库为空时退出码 3)")
+	fmt.Fprintln(out, "  events [--room 房间号] [--type 类型]  实时事件流")
+	fmt.Fprintln(out, "")
+	fmt.Fprintln(out, "运维操作:")
+	fmt.Fprintln(out, "  close-room <房间号> [--reason 原因]      关闭房间并断开所有玩家")
+	fmt.Fprintln(out, "  kick <房间号> <玩家ID或昵称> [--ban]     将玩家移出房间")
+	fmt.Fprintln(out, "  announce \"公告内容\"                     向所有房间发送公告")
+	fmt.Fprintln(out, "  reload-catalog                           重新加载题库")
+	fmt.Fprintln(out, "  delete-pack <曲包ID>                     删除曲包 (正被房间使用时拒绝)")
+	fmt.Fprintln(out, "")
+	fmt.Fprintln(out, "子命令选项: --output json|yaml|table|csv  --mode vocaloid|touhou  --state waiting|playing")
+	fmt.Fprintln(out, "")
+	fmt.Fprintln(out, "全局选项:")
+	flag.PrintDefaults()
+}
+
+func envOr(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
+
 func printStatus(s StatusResponse) {
 	line := strings.Repeat("═", 56)
 	thinLine := strings.Repeat("─", 56)
@@ -73,10 +134,15 @@ func printStatus(s StatusResponse) {
 	fmt.Println("  Metagaruta 游戏服务状态")
 	fmt.Println(line)
 	fmt.Printf("  时间        %s\n", s.Timestamp)
-	fmt.Printf("  活跃房间    %d\n", s.TotalRooms)
+	fmt.Printf("  节点        %s\n", s.NodeID)
+	fmt.Printf("  活跃房间    %d / %d\n", s.TotalRooms, s.MaxRooms)
 	fmt.Printf("  在线玩家    %d\n", s.TotalPlayers)
-	fmt.Printf("  Vocaloid 曲库  %d 首\n", s.VocaloidSongs)
-	fmt.Printf("  东方角色库      %d 个\n", s.TouhouChars)
+	for _, name := range catalogNames(s) {
+		fmt.Printf("  题库 %-10s %d\n", name, catalogCounts(s)[name])
+	}
+	fmt.Printf("  限流        警告 %d / 丢弃 %d / 断开 %d / 封禁 %d (当前 %d 个 IP)\n",
+		s.Abuse.Warned, s.Abuse.Dropped, s.Abuse.Disconnected, s.Abuse.Banned, s.BannedIPs)
+	fmt.Printf("  拒绝连接    %d    超长消息 %d\n", s.Abuse.RejectedConns, s.Abuse.OversizedMsgs)
 	fmt.Println(thinLine)
 
 	if len(s.Rooms) == 0 {
@@ -86,40 +152,7 @@ func printStatus(s StatusResponse) {
 	}
 
 	for i, rm := range s.Rooms {
-		modeStr := "Vocaloid"
-		if rm.GameMode == "touhou" {
-			modeStr = "东方"
-		}
-		stateStr := stateText(rm.State)
-		roundStateStr := roundStateText(rm.RoundState)
-
-		fmt.Printf("  房间 #%s  [%s]  %s\n", rm.ID, modeStr, stateStr)
-		fmt.Printf("    回合: %d    回合状态: %s\n", rm.CurrentRound, roundStateStr)
-		fmt.Printf("    牌面: %d/%d 已匹配    题库剩余: %d\n", rm.MatchedCards, rm.BoardCards, rm.SongPoolSize)
-		if rm.CurrentSong != "" {
-			fmt.Printf("    当前曲目: %s\n", rm.CurrentSong)
-		}
-
-		if len(rm.Players) == 0 {
-			fmt.Println("    玩家: (无)")
-		} else {
-			fmt.Println("    ┌────────────────┬──────┬──────┬──────┐")
-			fmt.Println("    │ 玩家名         │ 分数 │ 已答 │ 准备 │")
-			fmt.Println("    ├────────────────┼──────┼──────┼──────┤")
-			for _, p := range rm.Players {
-				name := padRight(p.Name, 14)
-				ownerMark := ""
-				if p.ID == rm.OwnerID {
-					ownerMark = "*"
-				}
-				answered := boolMark(p.HasAnswered)
-				ready := boolMark(p.GameReady)
-				fmt.Printf("    │ %s%s│ %4d │  %s   │  %s   │\n", name, ownerMark, p.Score, answered, ready)
-			}
-			fmt.Println("    └────────────────┴──────┴──────┴──────┘")
-			fmt.Println("    (* = 房主)")
-		}
-
+		printRoom(rm)
 		if i < len(s.Rooms)-1 {
 			fmt.Println(thinLine)
 		}
@@ -127,6 +160,50 @@ func printStatus(s StatusResponse) {
 	fmt.Println(line)
 }
 
+// printRoom 以表格形式打印单个房间的详情
+func printRoom(rm RoomInfo) {
+	modeStr := "Vocaloid"
+	if rm.GameMode == "touhou" {
+		modeStr = "东方"
+	}
+	stateStr := stateText(rm.State)
+	roundStateStr := roundStateText(rm.RoundState)
+	if rm.Paused {
+		roundStateStr += " (已暂停)"
+	}
+
+	privateStr := ""
+	if rm.Private {
+		privateStr = "  🔒私密"
+	}
+	fmt.Printf("  房间 #%s  [%s]  %s%s\n", rm.ID, modeStr, stateStr, privateStr)
+	fmt.Printf("    回合: %d    回合状态: %s\n", rm.CurrentRound, roundStateStr)
+	fmt.Printf("    牌面: %d/%d 已匹配    题库剩余: %d\n", rm.MatchedCards, rm.BoardCards, rm.SongPoolSize)
+	if rm.CurrentSong != "" {
+		fmt.Printf("    当前曲目: %s\n", rm.CurrentSong)
+	}
+
+	if len(rm.Players) == 0 {
+		fmt.Println("    玩家: (无)")
+	} else {
+		fmt.Println("    ┌────────────────┬──────┬──────┬──────┐")
+		fmt.Println("    │ 玩家名         │ 分数 │ 已答 │ 准备 │")
+		fmt.Println("    ├────────────────┼──────┼──────┼──────┤")
+		for _, p := range rm.Players {
+			name := padRight(p.Name, 14)
+			ownerMark := ""
+			if p.ID == rm.OwnerID {
+				ownerMark = "*"
+			}
+			answered := boolMark(p.HasAnswered)
+			ready := boolMark(p.GameReady)
+			fmt.Printf("    │ %s%s│ %4d │  %s   │  %s   │\n", name, ownerMark, p.Score, answered, ready)
+		}
+		fmt.Println("    └────────────────┴──────┴──────┴──────┘")
+		fmt.Println("    (* = 房主)")
+	}
+}
+
 func stateText(s string) string {
 	switch s {
 	case "waiting":
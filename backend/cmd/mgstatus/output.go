@@ -0,0 +1,227 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ==========================================
+// 机器可读输出：json / yaml / csv / table
+// ==========================================
+
+var outputFormats = []string{"table", "json", "yaml", "csv"}
+
+// tabular 是可以按表格 (table/csv) 输出的数据
+type tabular struct {
+	headers []string
+	rows    [][]string
+}
+
+// writeOutput 按格式输出；data 用于 json/yaml，tab 用于 table/csv
+func writeOutput(w io.Writer, format string, data interface{}, tab tabular) error {
+	switch format {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		enc.SetEscapeHTML(false)
+		return enc.Encode(data)
+	case "yaml":
+		return writeYAML(w, data)
+	case "csv":
+		cw := csv.NewWriter(w)
+		cw.Write(tab.headers)
+		cw.WriteAll(tab.rows)
+		return cw.Error()
+	case "table":
+		writeTable(w, tab)
+		return nil
+	}
+	return fmt.Errorf("不支持的输出格式: %s (可选: %s)", format, strings.Join(outputFormats, ", "))
+}
+
+// writeTable 输出按显示宽度对齐的纯文本表格
+func writeTable(w io.Writer, tab tabular) {
+	widths := make([]int, len(tab.headers))
+	for i, h := range tab.headers {
+		widths[i] = displayWidth(h)
+	}
+	for _, row := range tab.rows {
+		for i, cell := range row {
+			if dw := displayWidth(cell); i < len(widths) && dw > widths[i] {
+				widths[i] = dw
+			}
+		}
+	}
+	writeRow := func(cells []string) {
+		var sb strings.Builder
+		for i, cell := range cells {
+			if i == len(cells)-1 {
+				sb.WriteString(cell)
+			} else {
+				sb.WriteString(padRight(cell, widths[i]+2))
+			}
+		}
+		fmt.Fprintln(w, strings.TrimRight(sb.String(), " "))
+	}
+	writeRow(tab.headers)
+	for _, row := range tab.rows {
+		writeRow(row)
+	}
+}
+
+// displayWidth 估算字符串的终端显示宽度（CJK 字符按 2 计）
+func displayWidth(s string) int {
+	w := 0
+	for _, r := range s {
+		if r > 0x7F {
+			w += 2
+		} else {
+			w++
+		}
+	}
+	return w
+}
+
+// writeYAML 把结构体/切片/map 输出为 YAML，字段名取自 json 标签
+func writeYAML(w io.Writer, data interface{}) error {
+	var sb strings.Builder
+	yamlValue(&sb, reflect.ValueOf(data), 0, false)
+	_, err := io.WriteString(w, strings.TrimPrefix(sb.String(), "\n"))
+	return err
+}
+
+func yamlValue(sb *strings.Builder, v reflect.Value, indent int, inList bool) {
+	for v.Kind() == reflect.Interface || v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			sb.WriteString(" null\n")
+			return
+		}
+		v = v.Elem()
+	}
+	pad := strings.Repeat("  ", indent)
+
+	switch v.Kind() {
+	case reflect.Struct:
+		fields := yamlStructFields(v)
+		if len(fields) == 0 {
+			sb.WriteString(" {}\n")
+			return
+		}
+		if !inList {
+			sb.WriteString("\n")
+		}
+		for i, f := range fields {
+			if inList && i == 0 {
+				sb.WriteString(f.name + ":")
+			} else {
+				sb.WriteString(pad + f.name + ":")
+			}
+			yamlValue(sb, f.value, indent+1, false)
+		}
+	case reflect.Map:
+		if v.Len() == 0 {
+			sb.WriteString(" {}\n")
+			return
+		}
+		keys := v.MapKeys()
+		sort.Slice(keys, func(i, j int) bool { return fmt.Sprint(keys[i]) < fmt.Sprint(keys[j]) })
+		if !inList {
+			sb.WriteString("\n")
+		}
+		for i, k := range keys {
+			if inList && i == 0 {
+				sb.WriteString(yamlScalar(fmt.Sprint(k)) + ":")
+			} else {
+				sb.WriteString(pad + yamlScalar(fmt.Sprint(k)) + ":")
+			}
+			yamlValue(sb, v.MapIndex(k), indent+1, false)
+		}
+	case reflect.Slice, reflect.Array:
+		if v.Len() == 0 {
+			sb.WriteString(" []\n")
+			return
+		}
+		sb.WriteString("\n")
+		for i := 0; i < v.Len(); i++ {
+			sb.WriteString(pad + "- ")
+			elem := v.Index(i)
+			for elem.Kind() == reflect.Interface || elem.Kind() == reflect.Pointer {
+				elem = elem.Elem()
+			}
+			switch elem.Kind() {
+			case reflect.Struct, reflect.Map:
+				yamlValue(sb, elem, indent+1, true)
+			default:
+				var item strings.Builder
+				yamlValue(&item, elem, indent+1, false)
+				sb.WriteString(strings.TrimPrefix(item.String(), " "))
+			}
+		}
+	case reflect.String:
+		sb.WriteString(" " + yamlScalar(v.String()) + "\n")
+	case reflect.Bool:
+		sb.WriteString(" " + strconv.FormatBool(v.Bool()) + "\n")
+	default:
+		sb.WriteString(" " + fmt.Sprint(v.Interface()) + "\n")
+	}
+}
+
+type yamlField struct {
+	name  string
+	value reflect.Value
+}
+
+func yamlStructFields(v reflect.Value) []yamlField {
+	var fields []yamlField
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if !sf.IsExported() {
+			continue
+		}
+		name := sf.Name
+		omitEmpty := false
+		if tag := sf.Tag.Get("json"); tag != "" {
+			parts := strings.Split(tag, ",")
+			if parts[0] == "-" {
+				continue
+			}
+			if parts[0] != "" {
+				name = parts[0]
+			}
+			for _, opt := range parts[1:] {
+				omitEmpty = omitEmpty || opt == "omitempty"
+			}
+		}
+		fv := v.Field(i)
+		if omitEmpty && fv.IsZero() {
+			continue
+		}
+		fields = append(fields, yamlField{name: name, value: fv})
+	}
+	return fields
+}
+
+// yamlScalar 在需要时给字符串加引号，避免被解析成数字、布尔或其它结构
+func yamlScalar(s string) string {
+	if s == "" {
+		return `""`
+	}
+	switch strings.ToLower(s) {
+	case "true", "false", "yes", "no", "on", "off", "null", "~":
+		return strconv.Quote(s)
+	}
+	if _, err := strconv.ParseFloat(s, 64); err == nil {
+		return strconv.Quote(s)
+	}
+	if strings.ContainsAny(s, ":#{}[],&*!|>'\"%@`\n\t") || strings.TrimSpace(s) != s || strings.HasPrefix(s, "-") || strings.HasPrefix(s, "?") {
+		return strconv.Quote(s)
+	}
+	return s
+}
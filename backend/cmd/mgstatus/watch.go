@@ -0,0 +1,299 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// ==========================================
+// watch 模式：全屏实时刷新的终端面板
+// 房间列表 ↑/↓ 选择，Enter 进入房间详情，Esc 返回，q 退出
+// ==========================================
+
+const (
+	keyNone = iota
+	keyUp
+	keyDown
+	keyEnter
+	keyBack
+	keyQuit
+)
+
+type watchState struct {
+	status    StatusResponse
+	fetchedAt time.Time
+	err       error
+	selected  int
+	detailID  string // 非空时显示该房间详情
+	interval  time.Duration
+}
+
+type fetchResult struct {
+	status StatusResponse
+	err    error
+}
+
+func runWatch(client *adminClient, interval time.Duration) error {
+	restore, err := enterRawMode()
+	if err != nil {
+		return fmt.Errorf("无法切换终端到原始模式: %w", err)
+	}
+	// 切换到备用屏幕并隐藏光标，退出时恢复
+	fmt.Print("\x1b[?1049h\x1b[?25l")
+	defer func() {
+		fmt.Print("\x1b[?25h\x1b[?1049l")
+		restore()
+	}()
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGTERM, syscall.SIGHUP)
+	defer signal.Stop(sigCh)
+
+	keys := make(chan int, 8)
+	go readKeys(keys)
+
+	results := make(chan fetchResult, 1)
+	fetch := func() {
+		status, err := client.fetchStatus()
+		results <- fetchResult{status, err}
+	}
+	go fetch()
+
+	st := &watchState{interval: interval}
+	fetchTicker := time.NewTicker(interval)
+	defer fetchTicker.Stop()
+	// 倒计时每秒变化，单独以更高频率重绘
+	drawTicker := time.NewTicker(250 * time.Millisecond)
+	defer drawTicker.Stop()
+
+	for {
+		select {
+		case <-sigCh:
+			return nil
+		case k := <-keys:
+			if st.handleKey(k) {
+				return nil
+			}
+		case res := <-results:
+			if res.err != nil {
+				st.err = res.err
+			} else {
+				st.status, st.err, st.fetchedAt = res.status, nil, time.Now()
+				sortRooms(st.status.Rooms)
+			}
+		case <-fetchTicker.C:
+			go fetch()
+			continue
+		case <-drawTicker.C:
+		}
+		draw(st)
+	}
+}
+
+// handleKey 处理按键，返回 true 表示退出
+func (st *watchState) handleKey(k int) bool {
+	rooms := st.status.Rooms
+	switch k {
+	case keyQuit:
+		return true
+	case keyUp:
+		if st.detailID == "" && st.selected > 0 {
+			st.selected--
+		}
+	case keyDown:
+		if st.detailID == "" && st.selected < len(rooms)-1 {
+			st.selected++
+		}
+	case keyEnter:
+		if st.detailID == "" && st.selected < len(rooms) {
+			st.detailID = rooms[st.selected].ID
+		}
+	case keyBack:
+		st.detailID = ""
+	}
+	return false
+}
+
+func sortRooms(rooms []RoomInfo) {
+	sort.Slice(rooms, func(i, j int) bool { return rooms[i].ID < rooms[j].ID })
+}
+
+func draw(st *watchState) {
+	var b strings.Builder
+	b.WriteString("\x1b[H\x1b[2J")
+
+	s := st.status
+	fmt.Fprintf(&b, " \x1b[1mMetagaruta 实时状态\x1b[0m  节点 %s  %s  房间 %d/%d  玩家 %d  (每 %v 刷新)\n",
+		s.NodeID, time.Now().Format("15:04:05"), s.TotalRooms, s.MaxRooms, s.TotalPlayers, st.interval)
+	if st.err != nil {
+		fmt.Fprintf(&b, " \x1b[31m%s\x1b[0m\n", strings.ReplaceAll(st.err.Error(), "\n", " "))
+	}
+	b.WriteString(" " + strings.Repeat("─", 72) + "\n")
+
+	if st.detailID != "" {
+		drawRoomDetail(&b, st)
+		b.WriteString("\n Esc 返回列表  q 退出\n")
+	} else {
+		drawRoomList(&b, st)
+		b.WriteString("\n ↑/↓ 选择  Enter 查看房间  q 退出\n")
+	}
+
+	// 原始模式下换行不会自动回到行首
+	fmt.Print(strings.ReplaceAll(b.String(), "\n", "\r\n"))
+}
+
+func drawRoomList(b *strings.Builder, st *watchState) {
+	rooms := st.status.Rooms
+	if len(rooms) == 0 {
+		b.WriteString("  (当前没有活跃房间)\n")
+		return
+	}
+	if st.selected >= len(rooms) {
+		st.selected = len(rooms) - 1
+	}
+
+	fmt.Fprintf(b, "   %s%s%s%s%s%s%s%s\n",
+		padRight("房间", 8), padRight("模式", 10), padRight("状态", 8), padRight("回合", 6),
+		padRight("阶段", 16), padRight("剩余", 7), padRight("玩家", 6), "牌面")
+	for i, rm := range rooms {
+		cursor := "  "
+		if i == st.selected {
+			cursor = "\x1b[7m>"
+		}
+		fmt.Fprintf(b, " %s %s%s%s%s%s%s%s%d/%d\x1b[0m\n",
+			cursor,
+			padRight(rm.ID, 8),
+			padRight(modeText(rm.GameMode), 10),
+			padRight(stateText(rm.State), 8),
+			padRight(fmt.Sprintf("%d", rm.CurrentRound), 6),
+			padRight(phaseText(rm), 16),
+			padRight(countdownText(st, rm), 7),
+			padRight(fmt.Sprintf("%d", rm.PlayerCount), 6),
+			rm.MatchedCards, rm.BoardCards)
+	}
+}
+
+func drawRoomDetail(b *strings.Builder, st *watchState) {
+	var rm *RoomInfo
+	for i := range st.status.Rooms {
+		if st.status.Rooms[i].ID == st.detailID {
+			rm = &st.status.Rooms[i]
+		}
+	}
+	if rm == nil {
+		fmt.Fprintf(b, "  房间 #%s 已不存在\n", st.detailID)
+		return
+	}
+
+	fmt.Fprintf(b, "  房间 #%s  [%s]  %s\n", rm.ID, modeText(rm.GameMode), stateText(rm.State))
+	fmt.Fprintf(b, "  回合 %d    阶段 %s    剩余 %s\n", rm.CurrentRound, phaseText(*rm), countdownText(st, *rm))
+	fmt.Fprintf(b, "  牌面 %d/%d 已匹配    题库剩余 %d\n", rm.MatchedCards, rm.BoardCards, rm.SongPoolSize)
+	if rm.CurrentSong != "" {
+		fmt.Fprintf(b, "  当前曲目 %s\n", rm.CurrentSong)
+	}
+	b.WriteString("\n")
+
+	players := make([]PlayerInfo, len(rm.Players))
+	copy(players, rm.Players)
+	sort.SliceStable(players, func(i, j int) bool { return players[i].Score > players[j].Score })
+
+	fmt.Fprintf(b, "  %s%s%s%s%s\n", padRight("#", 4), padRight("玩家", 18), padRight("分数", 8), padRight("已答", 6), "准备")
+	for i, p := range players {
+		name := p.Name
+		if p.ID == rm.OwnerID {
+			name += " *"
+		}
+		fmt.Fprintf(b, "  %s%s%s%s%s\n",
+			padRight(fmt.Sprintf("%d", i+1), 4),
+			padRight(name, 18),
+			padRight(fmt.Sprintf("%d", p.Score), 8),
+			padRight(boolMark(p.HasAnswered), 6),
+			boolMark(p.GameReady))
+	}
+	b.WriteString("  (* = 房主)\n")
+}
+
+func modeText(mode string) string {
+	if mode == "touhou" {
+		return "东方"
+	}
+	return "Vocaloid"
+}
+
+func phaseText(rm RoomInfo) string {
+	s := roundStateText(rm.RoundState)
+	if rm.Paused {
+		s += "(暂停)"
+	}
+	return s
+}
+
+// countdownText 根据抓取时刻的剩余时间推算当前剩余秒数
+func countdownText(st *watchState, rm RoomInfo) string {
+	if rm.PhaseLeftMs <= 0 {
+		return "-"
+	}
+	left := time.Duration(rm.PhaseLeftMs) * time.Millisecond
+	if !rm.Paused {
+		left -= time.Since(st.fetchedAt)
+	}
+	if left < 0 {
+		left = 0
+	}
+	secs := int(left.Round(time.Second).Seconds())
+	return fmt.Sprintf("%d:%02d", secs/60, secs%60)
+}
+
+// readKeys 从标准输入解析按键
+func readKeys(keys chan<- int) {
+	buf := make([]byte, 8)
+	for {
+		n, err := os.Stdin.Read(buf)
+		if err != nil {
+			keys <- keyQuit
+			return
+		}
+		k := keyNone
+		seq := string(buf[:n])
+		switch {
+		case seq == "\x1b[A" || seq == "k":
+			k = keyUp
+		case seq == "\x1b[B" || seq == "j":
+			k = keyDown
+		case seq == "\r" || seq == "\n" || seq == "\x1b[C" || seq == "l":
+			k = keyEnter
+		case seq == "\x1b" || seq == "\x7f" || seq == "\x1b[D" || seq == "h" || seq == "b":
+			k = keyBack
+		case seq == "q" || seq == "Q" || seq == "\x03":
+			k = keyQuit
+		}
+		if k != keyNone {
+			keys <- k
+		}
+	}
+}
+
+// enterRawMode 借助 stty 把终端切换为原始模式，返回恢复函数
+func enterRawMode() (func(), error) {
+	saved, err := stty("-g")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := stty("raw", "-echo"); err != nil {
+		return nil, err
+	}
+	return func() { stty(strings.TrimSpace(saved)) }, nil
+}
+
+func stty(args ...string) (string, error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = os.Stdin
+	out, err := cmd.Output()
+	return string(out), err
+}
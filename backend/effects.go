@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/rand"
+	"strings"
+)
+
+// ==========================================
+// 题目音频特效 (防听歌识曲 / 挑战模式)
+// 房间可开启: 变调 (最多 ±maxPitchShift 个半音)、变速 (最多 ±maxTempoChange%)、
+// 加噪 (1-3 档粉红噪声)、闷音 (低通滤波) 与倒放
+// 变调与变速的幅度每局随机抽取，同一局所有玩家听到的相同
+// 特效在截取片段时由 ffmpeg 处理 (见 clip.go)，开启特效的房间需要服务器安装 ffmpeg
+// ==========================================
+
+const (
+	maxPitchShift  = 3  // 半音
+	maxTempoChange = 25 // 百分比
+	maxNoiseLevel  = 3
+)
+
+// 各噪声档位的振幅 (混音后相对于响度统一后的音乐)
+var noiseAmplitudes = [maxNoiseLevel + 1]float64{0, 0.02, 0.05, 0.1}
+
+// 闷音模式的低通截止频率 (Hz)
+const muffledCutoff = 800
+
+// audioEffects 是房间的特效设置，零值表示不加特效
+type audioEffects struct {
+	Pitch   int  `json:"pitch,omitempty"` // 变调幅度上限 (半音)
+	Tempo   int  `json:"tempo,omitempty"` // 变速幅度上限 (%)
+	Noise   int  `json:"noise,omitempty"` // 噪声档位
+	Muffled bool `json:"muffled,omitempty"`
+	Reverse bool `json:"reverse,omitempty"`
+}
+
+func (e audioEffects) active() bool {
+	return e != audioEffects{}
+}
+
+func (e audioEffects) String() string {
+	var parts []string
+	if e.Pitch > 0 {
+		parts = append(parts, fmt.Sprintf("变调±%d", e.Pitch))
+	}
+	if e.Tempo > 0 {
+		parts = append(parts, fmt.Sprintf("变速±%d%%", e.Tempo))
+	}
+	if e.Noise > 0 {
+		parts = append(parts, fmt.Sprintf("噪声%d档", e.Noise))
+	}
+	if e.Muffled {
+		parts = append(parts, "闷音")
+	}
+	if e.Reverse {
+		parts = append(parts, "倒放")
+	}
+	return strings.Join(parts, " ")
+}
+
+// parseAudioEffects 解析 create_room / set_audio_effects 中的 effects 对象，缺省为不加特效
+func parseAudioEffects(raw interface{}) (audioEffects, error) {
+	var e audioEffects
+	if raw == nil {
+		return e, nil
+	}
+	b, _ := json.Marshal(raw)
+	if err := json.Unmarshal(b, &e); err != nil {
+		return e, fmt.Errorf("音效设置格式有误")
+	}
+	if e.Pitch < 0 || e.Pitch > maxPitchShift {
+		return e, fmt.Errorf("变调幅度应在 0-%d 个半音之间", maxPitchShift)
+	}
+	if e.Tempo < 0 || e.Tempo > maxTempoChange {
+		return e, fmt.Errorf("变速幅度应在 0-%d%% 之间", maxTempoChange)
+	}
+	if e.Noise < 0 || e.Noise > maxNoiseLevel {
+		return e, fmt.Errorf("噪声档位应在 0-%d 之间", maxNoiseLevel)
+	}
+	if e.active() && !haveFFmpeg() {
+		return e, fmt.Errorf("服务器未安装 ffmpeg，无法使用音效")
+	}
+	return e, nil
+}
+
+// clipEffects 是某一局实际使用的特效参数
+type clipEffects struct {
+	pitch   float64 // 半音
+	tempo   float64 // 播放速度倍数
+	noise   int
+	muffled bool
+	reverse bool
+}
+
+// roll 按房间设置为一局抽取特效参数；变调至少半个半音、变速至少上限的四分之一，避免抽到几乎不变的值
+func (e audioEffects) roll() *clipEffects {
+	if !e.active() {
+		return nil
+	}
+	c := &clipEffects{tempo: 1, noise: e.Noise, muffled: e.Muffled, reverse: e.Reverse}
+	if e.Pitch > 0 {
+		c.pitch = randomMagnitude(0.5, float64(e.Pitch))
+	}
+	if e.Tempo > 0 {
+		c.tempo = 1 + randomMagnitude(float64(e.Tempo)/400, float64(e.Tempo)/100)
+	}
+	return c
+}
+
+// randomMagnitude 返回绝对值在 [lo, hi] 之间、正负随机的数
+func randomMagnitude(lo, hi float64) float64 {
+	v := lo + rand.Float64()*(hi-lo)
+	if rand.Intn(2) == 0 {
+		return -v
+	}
+	return v
+}
+
+// sourceSeconds 播放 seconds 秒需要截取的原曲长度 (变速后同样时长对应的原曲更长或更短)
+func (c *clipEffects) sourceSeconds(seconds int) int {
+	return int(math.Ceil(float64(seconds) * c.tempo))
+}
+
+// filterGraph 生成 ffmpeg -filter_complex 的滤镜图，输出标签为 [out]
+// 变调用改采样率实现 (音高与速度一起变)，再用 atempo 把速度校正到目标倍数
+func (c *clipEffects) filterGraph(loudness float64) string {
+	chain := []string{"aresample=48000"}
+	ratio := math.Pow(2, c.pitch/12)
+	if c.pitch != 0 {
+		chain = append(chain, fmt.Sprintf("asetrate=%.0f", 48000*ratio), "aresample=48000")
+	}
+	if speed := c.tempo / ratio; math.Abs(speed-1) > 1e-3 {
+		chain = append(chain, fmt.Sprintf("atempo=%.4f", speed))
+	}
+	if c.muffled {
+		chain = append(chain, fmt.Sprintf("lowpass=f=%d", muffledCutoff))
+	}
+	if c.reverse {
+		chain = append(chain, "areverse")
+	}
+	if loudness != 0 {
+		chain = append(chain, fmt.Sprintf("loudnorm=I=%g:TP=-1.5:LRA=11", loudness), "aresample=48000")
+	}
+	graph := "[0:a]" + strings.Join(chain, ",")
+	if c.noise == 0 {
+		return graph + "[out]"
+	}
+	// amix 会把每路音量除以输入数，混音后再放大回来
+	return graph + fmt.Sprintf("[music];anoisesrc=color=pink:sample_rate=48000:amplitude=%g[noise];"+
+		"[music][noise]amix=inputs=2:duration=first:dropout_transition=0,volume=2[out]", noiseAmplitudes[c.noise])
+}
+
+func (c *clipEffects) String() string {
+	parts := []string{fmt.Sprintf("pitch=%+.1f", c.pitch), fmt.Sprintf("tempo=%.2f", c.tempo)}
+	if c.noise > 0 {
+		parts = append(parts, fmt.Sprintf("noise=%d", c.noise))
+	}
+	if c.muffled {
+		parts = append(parts, "muffled")
+	}
+	if c.reverse {
+		parts = append(parts, "reverse")
+	}
+	return strings.Join(parts, " ")
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ==========================================
+// 管理事件流 (Server-Sent Events)
+// GET /api/admin/events?room=K7PXM,ABCDE&type=round_ended,buzz
+// 房间创建/销毁、玩家进出、回合开始/结束、抢答结果与错误实时推送，
+// 断线重连时通过 Last-Event-ID 补发缓冲区内错过的事件
+// ==========================================
+
+// 事件类型
+const (
+	evRoomCreated   = "room_created"
+	evRoomDestroyed = "room_destroyed"
+	evPlayerJoined  = "player_joined"
+	evPlayerLeft    = "player_left"
+	evPlayerKicked  = "player_kicked"
+	evOwnerChanged  = "owner_changed"
+	evGameStarted   = "game_started"
+	evRoundStarted  = "round_started"
+	evRoundEnded    = "round_ended"
+	evGameOver      = "game_over"
+	evBuzz          = "buzz"
+	evNoSong        = "no_song"
+	evError         = "error"
+	evAdminAction   = "admin_action"
+)
+
+type adminEvent struct {
+	ID     uint64                 `json:"id"`
+	Time   string                 `json:"time"`
+	Type   string                 `json:"type"`
+	RoomID string                 `json:"roomId,omitempty"`
+	Data   map[string]interface{} `json:"data,omitempty"`
+}
+
+const (
+	eventBacklogSize = 512 // 用于断线补发的事件缓冲
+	eventSubBuffer   = 256 // 每个订阅者的发送队列，满了则丢弃并提示
+)
+
+type eventFilter struct {
+	rooms map[string]bool // 为空表示不过滤
+	types map[string]bool
+}
+
+func (f eventFilter) match(e adminEvent) bool {
+	if len(f.rooms) > 0 && !f.rooms[e.RoomID] {
+		return false
+	}
+	if len(f.types) > 0 && !f.types[e.Type] {
+		return false
+	}
+	return true
+}
+
+type eventSub struct {
+	ch      chan adminEvent
+	filter  eventFilter
+	dropped int // 持有 eventHub.mu
+}
+
+type eventHub struct {
+	mu      sync.Mutex
+	nextID  uint64
+	backlog []adminEvent
+	subs    map[*eventSub]struct{}
+}
+
+var events = &eventHub{subs: make(map[*eventSub]struct{})}
+
+// publish 记录并分发一条事件；从不阻塞，可以在持有房间锁时调用
+func (h *eventHub) publish(typ, roomID string, data map[string]interface{}) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.nextID++
+	e := adminEvent{
+		ID:     h.nextID,
+		Time:   time.Now().Format("2006-01-02 15:04:05.000"),
+		Type:   typ,
+		RoomID: roomID,
+		Data:   data,
+	}
+	h.backlog = append(h.backlog, e)
+	if len(h.backlog) > eventBacklogSize {
+		h.backlog = h.backlog[len(h.backlog)-eventBacklogSize:]
+	}
+	for sub := range h.subs {
+		if !sub.filter.match(e) {
+			continue
+		}
+		select {
+		case sub.ch <- e:
+		default:
+			sub.dropped++
+		}
+	}
+}
+
+// subscribe 注册订阅者，并返回 ID 大于 lastID 的缓冲事件供补发
+func (h *eventHub) subscribe(filter eventFilter, lastID uint64) (*eventSub, []adminEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	sub := &eventSub{ch: make(chan adminEvent, eventSubBuffer), filter: filter}
+	h.subs[sub] = struct{}{}
+	var missed []adminEvent
+	if lastID > 0 {
+		for _, e := range h.backlog {
+			if e.ID > lastID && filter.match(e) {
+				missed = append(missed, e)
+			}
+		}
+	}
+	return sub, missed
+}
+
+func (h *eventHub) unsubscribe(sub *eventSub) {
+	h.mu.Lock()
+	delete(h.subs, sub)
+	h.mu.Unlock()
+}
+
+// takeDropped 取出并清零订阅者被丢弃的事件数
+func (h *eventHub) takeDropped(sub *eventSub) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	n := sub.dropped
+	sub.dropped = 0
+	return n
+}
+
+func (h *eventHub) subscriberCount() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.subs)
+}
+
+// eventScores 把玩家列表压缩为 名字 -> 分数，电脑玩家不计入
+func eventScores(players []Player) map[string]int {
+	scores := make(map[string]int, len(players))
+	for _, p := range players {
+		if !p.IsBot {
+			scores[p.Name] = p.Score
+		}
+	}
+	return scores
+}
+
+func parseEventList(v string, upper bool) map[string]bool {
+	set := make(map[string]bool)
+	for _, item := range strings.Split(v, ",") {
+		item = strings.TrimSpace(item)
+		if upper {
+			item = normalizeRoomID(item)
+		}
+		if item != "" {
+			set[item] = true
+		}
+	}
+	return set
+}
+
+func handleAdminEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "不支持流式响应", http.StatusInternalServerError)
+		return
+	}
+	q := r.URL.Query()
+	filter := eventFilter{
+		rooms: parseEventList(q.Get("room"), true),
+		types: parseEventList(q.Get("type"), false),
+	}
+	lastID, _ := strconv.ParseUint(r.Header.Get("Last-Event-ID"), 10, 64)
+	if v := q.Get("lastEventId"); v != "" {
+		lastID, _ = strconv.ParseUint(v, 10, 64)
+	}
+
+	sub, missed := events.subscribe(filter, lastID)
+	defer events.unsubscribe(sub)
+
+	w.Header().Set("Content-Type", "text/event-stream; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Connection", "keep-alive")
+	// 告诉 nginx 不要缓冲事件流
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, "retry: 3000\n\n")
+	for _, e := range missed {
+		writeSSE(w, e)
+	}
+	flusher.Flush()
+	fmt.Printf("管理事件流已连接: %s (当前 %d 个订阅)\n", adminActor(r), events.subscriberCount())
+
+	heartbeat := time.NewTicker(15 * time.Second)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case e := <-sub.ch:
+			if n := events.takeDropped(sub); n > 0 {
+				fmt.Fprintf(w, "event: lagged\ndata: {\"dropped\":%d}\n\n", n)
+			}
+			writeSSE(w, e)
+			flusher.Flush()
+		case <-heartbeat.C:
+			// 注释行作为心跳，防止代理因空闲断开连接
+			fmt.Fprint(w, ": ping\n\n")
+			flusher.Flush()
+		}
+	}
+}
+
+func writeSSE(w http.ResponseWriter, e adminEvent) {
+	data, _ := json.Marshal(e)
+	fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.ID, e.Type, data)
+}
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// ==========================================
+// 题库元数据与筛选表达式
+// 建房时可用 filter 只从符合条件的歌曲中出题，例如:
+//   year>=2015 && vocalist=Miku
+//   (game="东方红魔乡" || game=东方妖妖梦) && !tag=arrange
+// 比较符: = (或 ==) != > >= < <= ~ (包含，不区分大小写)
+// 字符串比较不区分大小写；vocalist / tag 等列表字段只要有一项满足即可
+// ==========================================
+
+// SongMeta 是题目的可选元数据，Vocaloid 写在 songs.json 的每首歌上，
+// 东方写在角色或 tracks 的曲目上，自定义曲包写在 pack.json 的每首歌上
+type SongMeta struct {
+	Producer string     `json:"producer,omitempty"`
+	Year     int        `json:"year,omitempty"`
+	Vocalist stringList `json:"vocalist,omitempty"`
+	Tags     stringList `json:"tags,omitempty"`
+	Game     string     `json:"game,omitempty"`  // 东方: 出典作品
+	Album    string     `json:"album,omitempty"` // 东方: 收录专辑
+}
+
+// stringList 在 JSON 中既可以写成单个字符串也可以写成数组
+type stringList []string
+
+func (l *stringList) UnmarshalJSON(b []byte) error {
+	var one string
+	if err := json.Unmarshal(b, &one); err == nil {
+		*l = nil
+		if one != "" {
+			*l = stringList{one}
+		}
+		return nil
+	}
+	var many []string
+	if err := json.Unmarshal(b, &many); err != nil {
+		return err
+	}
+	*l = many
+	return nil
+}
+
+// 表达式长度与嵌套上限，防止恶意输入
+const (
+	maxFilterLen   = 500
+	maxFilterDepth = 16
+)
+
+type filterField struct {
+	numeric bool
+	num     func(s *Song) int
+	strs    func(s *Song) []string
+}
+
+var filterFields = map[string]filterField{
+	"id":        {strs: func(s *Song) []string { return []string{s.ID} }},
+	"mode":      {strs: func(s *Song) []string { return []string{s.Mode} }},
+	"title":     {strs: func(s *Song) []string { return []string{s.TitleOriginal, s.TitleTranslation} }},
+	"character": {strs: func(s *Song) []string { return []string{s.CharacterName} }},
+	"producer":  {strs: func(s *Song) []string { return []string{s.Producer} }},
+	"vocalist":  {strs: func(s *Song) []string { return s.Vocalist }},
+	"tag":       {strs: func(s *Song) []string { return s.Tags }},
+	"game":      {strs: func(s *Song) []string { return []string{s.Game} }},
+	"album":     {strs: func(s *Song) []string { return []string{s.Album} }},
+	"year":      {numeric: true, num: func(s *Song) int { return s.Year }},
+	"duration":  {numeric: true, num: func(s *Song) int { return s.Duration }},
+}
+
+// 字段别名
+var filterFieldAliases = map[string]string{"tags": "tag", "vocalists": "vocalist"}
+
+// songFilter 是编译好的筛选条件，nil 表示不筛选
+type songFilter struct {
+	text  string
+	match func(s *Song) bool
+}
+
+func (f *songFilter) Match(s *Song) bool {
+	return f == nil || f.match(s)
+}
+
+func (f *songFilter) String() string {
+	if f == nil {
+		return ""
+	}
+	return f.text
+}
+
+// parseSongFilter 编译筛选表达式，空串返回 nil
+func parseSongFilter(text string) (*songFilter, error) {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return nil, nil
+	}
+	if len(text) > maxFilterLen {
+		return nil, fmt.Errorf("筛选条件过长 (最多 %d 字节)", maxFilterLen)
+	}
+	toks, err := tokenizeFilter(text)
+	if err != nil {
+		return nil, err
+	}
+	p := &filterParser{toks: toks}
+	match, err := p.parseOr(0)
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.toks) {
+		return nil, fmt.Errorf("筛选条件在 %q 附近有多余内容", p.toks[p.pos].text)
+	}
+	return &songFilter{text: text, match: match}, nil
+}
+
+type filterToken struct {
+	kind string // word | op | and | or | not | ( | )
+	text string
+}
+
+func tokenizeFilter(text string) ([]filterToken, error) {
+	var toks []filterToken
+	rs := []rune(text)
+	for i := 0; i < len(rs); {
+		r := rs[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(' || r == ')':
+			toks = append(toks, filterToken{kind: string(r), text: string(r)})
+			i++
+		case r == '&' || r == '|':
+			if i+1 >= len(rs) || rs[i+1] != r {
+				return nil, fmt.Errorf("筛选条件中的 %c 应写成 %c%c", r, r, r)
+			}
+			kind := "and"
+			if r == '|' {
+				kind = "or"
+			}
+			toks = append(toks, filterToken{kind: kind, text: string(rs[i : i+2])})
+			i += 2
+		case r == '!' && (i+1 >= len(rs) || rs[i+1] != '='):
+			toks = append(toks, filterToken{kind: "not", text: "!"})
+			i++
+		case strings.ContainsRune("=!<>~", r):
+			op := string(r)
+			if i+1 < len(rs) && rs[i+1] == '=' && r != '~' {
+				op += "="
+			}
+			if op == "==" {
+				op = "="
+			}
+			toks = append(toks, filterToken{kind: "op", text: op})
+			i += len([]rune(op))
+			if op == "=" && i < len(rs) && rs[i] == '=' {
+				i++
+			}
+		case r == '"':
+			j := i + 1
+			for j < len(rs) && rs[j] != '"' {
+				j++
+			}
+			if j >= len(rs) {
+				return nil, fmt.Errorf("筛选条件中的引号没有闭合")
+			}
+			toks = append(toks, filterToken{kind: "word", text: string(rs[i+1 : j])})
+			i = j + 1
+		default:
+			j := i
+			for j < len(rs) && !unicode.IsSpace(rs[j]) && !strings.ContainsRune(`()&|!=<>~"`, rs[j]) {
+				j++
+			}
+			toks = append(toks, filterToken{kind: "word", text: string(rs[i:j])})
+			i = j
+		}
+	}
+	return toks, nil
+}
+
+type filterParser struct {
+	toks []filterToken
+	pos  int
+}
+
+func (p *filterParser) peek(kind string) bool {
+	return p.pos < len(p.toks) && p.toks[p.pos].kind == kind
+}
+
+func (p *filterParser) parseOr(depth int) (func(*Song) bool, error) {
+	left, err := p.parseAnd(depth)
+	if err != nil {
+		return nil, err
+	}
+	for p.peek("or") {
+		p.pos++
+		right, err := p.parseAnd(depth)
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(s *Song) bool { return l(s) || right(s) }
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseAnd(depth int) (func(*Song) bool, error) {
+	left, err := p.parseUnary(depth)
+	if err != nil {
+		return nil, err
+	}
+	for p.peek("and") {
+		p.pos++
+		right, err := p.parseUnary(depth)
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(s *Song) bool { return l(s) && right(s) }
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseUnary(depth int) (func(*Song) bool, error) {
+	if depth > maxFilterDepth {
+		return nil, fmt.Errorf("筛选条件嵌套过深")
+	}
+	switch {
+	case p.peek("not"):
+		p.pos++
+		inner, err := p.parseUnary(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		return func(s *Song) bool { return !inner(s) }, nil
+	case p.peek("("):
+		p.pos++
+		inner, err := p.parseOr(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		if !p.peek(")") {
+			return nil, fmt.Errorf("筛选条件缺少右括号")
+		}
+		p.pos++
+		return inner, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *filterParser) parseComparison() (func(*Song) bool, error) {
+	if p.pos+3 > len(p.toks) {
+		return nil, fmt.Errorf("筛选条件不完整，应为 字段 比较符 值")
+	}
+	name, op, val := p.toks[p.pos], p.toks[p.pos+1], p.toks[p.pos+2]
+	if name.kind != "word" || op.kind != "op" || val.kind != "word" {
+		return nil, fmt.Errorf("筛选条件在 %q 附近有误，应为 字段 比较符 值", name.text)
+	}
+	p.pos += 3
+
+	key := strings.ToLower(name.text)
+	if alias, ok := filterFieldAliases[key]; ok {
+		key = alias
+	}
+	field, ok := filterFields[key]
+	if !ok {
+		return nil, fmt.Errorf("未知的筛选字段: %s", name.text)
+	}
+
+	if field.numeric {
+		want, err := strconv.Atoi(val.text)
+		if err != nil {
+			return nil, fmt.Errorf("%s 需要与整数比较: %s", name.text, val.text)
+		}
+		cmp, ok := numericComparators[op.text]
+		if !ok {
+			return nil, fmt.Errorf("%s 不支持比较符 %s", name.text, op.text)
+		}
+		return func(s *Song) bool { return cmp(field.num(s), want) }, nil
+	}
+
+	want := strings.ToLower(val.text)
+	equal := func(v string) bool { return strings.EqualFold(v, want) }
+	contains := func(v string) bool { return strings.Contains(strings.ToLower(v), want) }
+	switch op.text {
+	case "=":
+		return func(s *Song) bool { return anyString(field.strs(s), equal) }, nil
+	case "!=":
+		return func(s *Song) bool { return !anyString(field.strs(s), equal) }, nil
+	case "~":
+		return func(s *Song) bool { return anyString(field.strs(s), contains) }, nil
+	}
+	return nil, fmt.Errorf("%s 只支持 = != ~ 比较", name.text)
+}
+
+var numericComparators = map[string]func(a, b int) bool{
+	"=":  func(a, b int) bool { return a == b },
+	"!=": func(a, b int) bool { return a != b },
+	">":  func(a, b int) bool { return a > b },
+	">=": func(a, b int) bool { return a >= b },
+	"<":  func(a, b int) bool { return a < b },
+	"<=": func(a, b int) bool { return a <= b },
+}
+
+func anyString(vals []string, pred func(string) bool) bool {
+	for _, v := range vals {
+		if v != "" && pred(v) {
+			return true
+		}
+	}
+	return false
+}
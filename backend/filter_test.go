@@ -0,0 +1,208 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestParseSongFilterMatch(t *testing.T) {
+	miku := &Song{ID: "s1", TitleOriginal: "千本桜", Mode: "vocaloid", Duration: 240,
+		SongMeta: SongMeta{Producer: "黒うさP", Year: 2011, Vocalist: stringList{"Hatsune Miku"}, Tags: stringList{"rock"}}}
+	arrange := &Song{ID: "t1", TitleOriginal: "亡き王女の為のセプテット", Mode: "touhou", CharacterName: "蕾米莉亚",
+		SongMeta: SongMeta{Game: "东方红魔乡", Tags: stringList{"arrange"}}}
+
+	cases := []struct {
+		filter string
+		song   *Song
+		want   bool
+	}{
+		{"year>=2010", miku, true},
+		{"year<2010", miku, false},
+		{"year==2011", miku, true},
+		{"year=2011", miku, true},
+		{"year!=2011", miku, false},
+		{"duration>200 && duration<=240", miku, true},
+		{"vocalist=\"hatsune miku\"", miku, true},
+		{"vocalists~MIKU", miku, true},
+		{"tags=rock", miku, true},
+		{"producer!=黒うさP", miku, false},
+		{"title~千本", miku, true},
+		{"!tag=arrange", miku, true},
+		{"!tag=arrange", arrange, false},
+		{"! tag=arrange", arrange, false},
+		{"!!tag=arrange", arrange, true},
+		{"tag!=arrange", arrange, false},
+		{"(game=\"东方红魔乡\" || game=东方妖妖梦) && !tag=arrange", arrange, false},
+		{"(game=\"东方红魔乡\" || game=东方妖妖梦) && tag=arrange", arrange, true},
+		{"mode=vocaloid || character=蕾米莉亚", arrange, true},
+		{"mode=vocaloid && character=蕾米莉亚", arrange, false},
+		// && 优先于 ||
+		{"mode=touhou || mode=vocaloid && year>3000", arrange, true},
+		{"(mode=touhou || mode=vocaloid) && year>3000", arrange, false},
+		// 空字段不参与比较
+		{"producer~\"\"", arrange, false},
+	}
+	for _, c := range cases {
+		f, err := parseSongFilter(c.filter)
+		if err != nil {
+			t.Errorf("parseSongFilter(%q) 出错: %v", c.filter, err)
+			continue
+		}
+		if got := f.Match(c.song); got != c.want {
+			t.Errorf("%q 对 %s 的结果 = %v, 期望 %v", c.filter, c.song.ID, got, c.want)
+		}
+		if f.String() != c.filter {
+			t.Errorf("String() = %q, 期望原文 %q", f.String(), c.filter)
+		}
+	}
+}
+
+func TestParseSongFilterEmpty(t *testing.T) {
+	f, err := parseSongFilter("   ")
+	if err != nil || f != nil {
+		t.Fatalf("空白筛选条件 = %v, %v, 期望 nil, nil", f, err)
+	}
+	if !f.Match(&Song{}) || f.String() != "" {
+		t.Fatal("nil 筛选条件应匹配所有题目")
+	}
+}
+
+func TestParseSongFilterErrors(t *testing.T) {
+	cases := []struct {
+		filter string
+		errHas string
+	}{
+		{"year>=2010 & year<2020", "&&"},
+		{"year>=2010 | year<2020", "||"},
+		{"title=\"abc", "引号"},
+		{"(year=2010", "右括号"},
+		{"year=2010)", "多余内容"},
+		{"year>", "不完整"},
+		{"year 2010 =", "有误"},
+		{"singer=miku", "未知的筛选字段"},
+		{"year=abc", "整数"},
+		{"year~20", "不支持比较符"},
+		{"title>abc", "只支持"},
+		{strings.Repeat("(", maxFilterDepth+1) + "year=1" + strings.Repeat(")", maxFilterDepth+1), "嵌套过深"},
+		{strings.Repeat("!", maxFilterDepth+1) + "year=1", "嵌套过深"},
+		{"title=" + strings.Repeat("a", maxFilterLen), "过长"},
+	}
+	for _, c := range cases {
+		_, err := parseSongFilter(c.filter)
+		if err == nil {
+			t.Errorf("parseSongFilter(%.40q) 应当失败", c.filter)
+			continue
+		}
+		if !strings.Contains(err.Error(), c.errHas) {
+			t.Errorf("parseSongFilter(%.40q) 的错误 %q 应包含 %q", c.filter, err, c.errHas)
+		}
+	}
+
+	// 嵌套刚好在上限内、长度刚好 500 字节时可以通过
+	ok := []string{
+		strings.Repeat("(", maxFilterDepth) + "year=1" + strings.Repeat(")", maxFilterDepth),
+		"title=" + strings.Repeat("a", maxFilterLen-len("title=")),
+	}
+	for _, text := range ok {
+		if _, err := parseSongFilter(text); err != nil {
+			t.Errorf("parseSongFilter(%.40q) 出错: %v", text, err)
+		}
+	}
+}
+
+func TestTokenizeFilterOperators(t *testing.T) {
+	toks, err := tokenizeFilter(`year==2010&&!tag!=x||a~"b c"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, tok := range toks {
+		got = append(got, tok.kind+":"+tok.text)
+	}
+	want := "word:year op:= word:2010 and:&& not:! word:tag op:!= word:x or:|| word:a op:~ word:b c"
+	if strings.Join(got, " ") != want {
+		t.Fatalf("tokenizeFilter = %s\n期望 %s", strings.Join(got, " "), want)
+	}
+}
+
+// putTestPack 把内存中构造的曲包放进 packCache，供 resolveRoomMode 按 ID 选用
+func putTestPack(t *testing.T, id string, years ...int) {
+	t.Helper()
+	m := &packMode{
+		manifest: packManifest{ID: id, Name: "测试曲包"},
+		dir:      t.TempDir(),
+		byID:     make(map[string]*packSong),
+	}
+	for i, y := range years {
+		m.manifest.Songs = append(m.manifest.Songs, packSong{
+			ID:            fmt.Sprintf("s%d", i),
+			TitleOriginal: fmt.Sprintf("曲 %d", i),
+			Duration:      60,
+			SongMeta:      SongMeta{Year: y},
+		})
+	}
+	for i := range m.manifest.Songs {
+		m.byID[m.manifest.Songs[i].ID] = &m.manifest.Songs[i]
+	}
+	packCache.Lock()
+	packCache.packs[id] = m
+	packCache.Unlock()
+	t.Cleanup(func() {
+		packCache.Lock()
+		delete(packCache.packs, id)
+		delete(packCache.lastUse, id)
+		packCache.Unlock()
+	})
+}
+
+func TestResolveRoomModeFilterPoolSize(t *testing.T) {
+	const id = "0123456789ab"
+	years := make([]int, 0, 24)
+	for i := 0; i < 24; i++ {
+		years = append(years, 2000+i%3) // 2000 / 2001 / 2002 各 8 首
+	}
+	putTestPack(t, id, years...)
+
+	cases := []struct {
+		gameMode string
+		catalogs []string
+		filter   string
+		pool     int // 0 表示应当拒绝建房
+	}{
+		{"", nil, "", 24},
+		{"", nil, "year>=2001", 16},
+		{"", nil, "year=2001", 0}, // 8 首，不足一局的牌数
+		{"", nil, "year>2002", 0},
+		{mixedModeName, []string{"vocaloid", packModePrefix + id}, "", 24},
+		{mixedModeName, []string{"vocaloid", packModePrefix + id}, "year!=2000", 16},
+		{mixedModeName, []string{"vocaloid", packModePrefix + id}, "year<2001", 0},
+	}
+	for _, c := range cases {
+		packID := ""
+		if c.gameMode == "" {
+			packID = id
+		}
+		mode, filter, err := resolveRoomMode(c.gameMode, packID, c.catalogs, c.filter)
+		if c.pool == 0 {
+			if err == nil || !strings.Contains(err.Error(), "不足一局") {
+				t.Errorf("%s %q: err = %v, 期望因题目不足拒绝", c.gameMode, c.filter, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s %q: 出错 %v", c.gameMode, c.filter, err)
+			continue
+		}
+		if n := len(mode.BuildPool(0, filter)); n != c.pool {
+			t.Errorf("%s %q: 题目池 %d 首, 期望 %d", c.gameMode, c.filter, n, c.pool)
+		}
+	}
+
+	if _, _, err := resolveRoomMode("", id, nil, "year=="); err == nil {
+		t.Error("筛选条件有误时应当拒绝建房")
+	}
+	if _, _, err := resolveRoomMode("", "ffffffffffff", nil, ""); err == nil {
+		t.Error("曲包不存在时应当拒绝建房")
+	}
+}
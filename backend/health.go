@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"time"
+)
+
+// ==========================================
+// 健康检查与构建信息
+// /healthz 进程存活；/readyz 题库与音频目录就绪；/version 构建版本
+// 部署脚本据此确认新二进制可用后再放流量
+// ==========================================
+
+// protocolVersion 是 WebSocket 消息协议的版本，协议有不兼容改动时递增
+const protocolVersion = 1
+
+// 构建时通过 -ldflags 注入，例如:
+// go build -ldflags "-X main.buildCommit=$(git rev-parse HEAD) -X main.buildTime=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+var (
+	buildCommit string
+	buildTime   string
+)
+
+var processStartedAt = time.Now()
+
+func registerHealthRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("/healthz", handleHealthz)
+	mux.HandleFunc("/readyz", handleReadyz)
+	mux.HandleFunc("/version", handleVersion)
+}
+
+type versionInfo struct {
+	Commit          string `json:"commit"`
+	Modified        bool   `json:"modified"`
+	BuildTime       string `json:"buildTime"`
+	GoVersion       string `json:"goVersion"`
+	ProtocolVersion int    `json:"protocolVersion"`
+	NodeID          string `json:"nodeId"`
+}
+
+// currentVersion 优先使用 -ldflags 注入的值，未注入时从 Go 自带的 VCS 构建信息中读取
+func currentVersion() versionInfo {
+	v := versionInfo{
+		Commit:          buildCommit,
+		BuildTime:       buildTime,
+		GoVersion:       runtime.Version(),
+		ProtocolVersion: protocolVersion,
+		NodeID:          cluster.ID,
+	}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, s := range info.Settings {
+			switch s.Key {
+			case "vcs.revision":
+				if v.Commit == "" {
+					v.Commit = s.Value
+				}
+			case "vcs.time":
+				if v.BuildTime == "" {
+					v.BuildTime = s.Value
+				}
+			case "vcs.modified":
+				v.Modified = s.Value == "true"
+			}
+		}
+	}
+	if v.Commit == "" {
+		v.Commit = "unknown"
+	}
+	return v
+}
+
+// handleHealthz 只要进程能处理请求就返回 200
+func handleHealthz(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"status":    "ok",
+		"uptimeSec": int(time.Since(processStartedAt).Seconds()),
+	})
+}
+
+type readyCheck struct {
+	Name   string `json:"name"`
+	OK     bool   `json:"ok"`
+	Detail string `json:"detail"`
+}
+
+// handleReadyz 检查每个模式的题库已加载且音频目录可读，任一失败返回 503
+func handleReadyz(w http.ResponseWriter, r *http.Request) {
+	sizes := catalogSizes()
+	var checks []readyCheck
+	for _, m := range allModes() {
+		n := sizes[m.Name()]
+		checks = append(checks,
+			readyCheck{Name: m.Name() + "_catalog", OK: n > 0, Detail: fmt.Sprintf("%d 条题目", n)},
+			checkReadableDir(m.Name()+"_audio", m.AudioDir()),
+		)
+	}
+
+	ready := true
+	for _, c := range checks {
+		ready = ready && c.OK
+	}
+	status, code := "ready", http.StatusOK
+	if !ready {
+		status, code = "not_ready", http.StatusServiceUnavailable
+	}
+	writeJSON(w, code, map[string]interface{}{
+		"status": status,
+		"checks": checks,
+	})
+}
+
+// checkReadableDir 确认目录存在且至少能列出一个条目
+func checkReadableDir(name, dir string) readyCheck {
+	f, err := os.Open(dir)
+	if err != nil {
+		return readyCheck{Name: name, Detail: err.Error()}
+	}
+	defer f.Close()
+	if _, err := f.Readdirnames(1); err != nil {
+		if err == io.EOF {
+			return readyCheck{Name: name, Detail: dir + " 为空"}
+		}
+		return readyCheck{Name: name, Detail: err.Error()}
+	}
+	return readyCheck{Name: name, OK: true, Detail: dir}
+}
+
+func handleVersion(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, currentVersion())
+}
@@ -0,0 +1,611 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ==========================================
+// 牌面图片处理
+// /api/picture 支持 w 参数按宽度缩小 (向上取 imageWidthStep 的整数倍，不放大)，
+// 并按 Accept 协商 AVIF / WebP；生成的变体缓存在 imageCacheDir
+// 缩放与 JPEG/PNG 编码用标准库完成，AVIF/WebP 需要 ffmpeg，没有时回落到原格式
+// 每个变体有强 ETag (源文件路径、大小、修改时间与变体参数的哈希) 与 Last-Modified，
+// 条件请求命中时返回 304
+// /api/picture/sprite 把一局场上所有牌的图片拼成一张 4 列的雪碧图，一次请求取完；
+// 每副牌的雪碧图都不同，不写入 imageCacheDir，只按房间缓存在内存中
+// ==========================================
+
+var (
+	imageCacheDir = "image-cache"
+	// 图片响应的浏览器缓存时长，过期后凭 ETag 重新验证
+	imageMaxAge = 24 * time.Hour
+)
+
+// 请求宽度按步长向上取整并限制上限，避免任意宽度把缓存撑爆
+const (
+	imageWidthStep = 32
+	maxImageWidth  = 1024
+)
+
+// 源图片的最大边长，防止解码超大图片占满内存
+const maxSourceImageSide = 8192
+
+// 雪碧图：4 列，单元格与牌面一样是 2:3
+const (
+	spriteColumns      = 4
+	defaultSpriteWidth = 128
+)
+
+// 图片 id 只允许这些字符 (各模式还会再做自己的校验)
+var imageIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+type imageFormat struct {
+	ext   string
+	ctype string
+}
+
+var (
+	formatJPEG = imageFormat{".jpg", "image/jpeg"}
+	formatPNG  = imageFormat{".png", "image/png"}
+	formatWebP = imageFormat{".webp", "image/webp"}
+	formatAVIF = imageFormat{".avif", "image/avif"}
+)
+
+// imageFormatByExt 题库与曲包中的图片只接受 JPEG 和 PNG
+func imageFormatByExt(path string) (imageFormat, bool) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".jpg", ".jpeg":
+		return formatJPEG, true
+	case ".png":
+		return formatPNG, true
+	}
+	return imageFormat{}, false
+}
+
+func imageFormatByType(ctype string) imageFormat {
+	if ctype == formatPNG.ctype {
+		return formatPNG
+	}
+	return formatJPEG
+}
+
+// 同时进行的图片生成数
+var imageSlots = make(chan struct{}, 4)
+
+// 同一变体并发请求时只生成一次
+var imageBuilds = newVariantBuilds()
+
+// ffmpeg 缺少编码器的格式，之后不再协商
+var imageEncoderBroken sync.Map
+
+func imageEncoderUsable(f imageFormat) bool {
+	if _, broken := imageEncoderBroken.Load(f.ext); broken {
+		return false
+	}
+	return haveFFmpeg()
+}
+
+// snapImageWidth 解析 w 参数，空串表示原尺寸
+func snapImageWidth(raw string) (int, error) {
+	if raw == "" {
+		return 0, nil
+	}
+	w, err := strconv.Atoi(raw)
+	if err != nil || w <= 0 {
+		return 0, fmt.Errorf("无效的宽度: %s", raw)
+	}
+	w = (w + imageWidthStep - 1) / imageWidthStep * imageWidthStep
+	return min(w, maxImageWidth), nil
+}
+
+// acceptQuality 返回 Accept 头中明确列出的某个类型的 q 值，未列出时为 0
+// 不看 image/* 与 */*，只有浏览器明确声明支持才升级格式
+func acceptQuality(accept, ctype string) float64 {
+	for _, part := range strings.Split(accept, ",") {
+		fields := strings.Split(part, ";")
+		if !strings.EqualFold(strings.TrimSpace(fields[0]), ctype) {
+			continue
+		}
+		q := 1.0
+		for _, param := range fields[1:] {
+			if v, ok := strings.CutPrefix(strings.TrimSpace(param), "q="); ok {
+				if f, err := strconv.ParseFloat(v, 64); err == nil {
+					q = f
+				}
+			}
+		}
+		return q
+	}
+	return 0
+}
+
+// negotiateImageFormat 在客户端接受且能编码的 AVIF / WebP 中选 q 值最高的 (相同时优先 AVIF)，
+// 都不行时保持原格式
+func negotiateImageFormat(accept string, src imageFormat) imageFormat {
+	best, bestQ := src, 0.0
+	for _, f := range []imageFormat{formatAVIF, formatWebP} {
+		if q := acceptQuality(accept, f.ctype); q > bestQ && imageEncoderUsable(f) {
+			best, bestQ = f, q
+		}
+	}
+	return best
+}
+
+// imageSource 是参与生成变体的一个源文件，st 为 nil 表示空缺 (雪碧图中没有图片的牌)
+type imageSource struct {
+	path string
+	st   os.FileInfo
+}
+
+func statImageSource(path string) (imageSource, error) {
+	st, err := os.Stat(path)
+	return imageSource{path: path, st: st}, err
+}
+
+// imageVariantKey 由源文件与变体参数得出缓存键，同时用作 ETag
+func imageVariantKey(kind string, srcs []imageSource, width int, f imageFormat) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s|%d|%s", kind, width, f.ext)
+	for _, src := range srcs {
+		if src.st == nil {
+			fmt.Fprintf(h, "|-")
+			continue
+		}
+		fmt.Fprintf(h, "|%s|%d|%d", src.path, src.st.Size(), src.st.ModTime().UnixNano())
+	}
+	return hex.EncodeToString(h.Sum(nil)[:16])
+}
+
+// latestModTime 取所有源文件中最新的修改时间，作为 Last-Modified
+func latestModTime(srcs []imageSource) time.Time {
+	var t time.Time
+	for _, src := range srcs {
+		if src.st != nil && src.st.ModTime().After(t) {
+			t = src.st.ModTime()
+		}
+	}
+	return t
+}
+
+// etagMatches 判断 If-None-Match 是否包含该 ETag
+func etagMatches(header, etag string) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == etag || tag == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// notModified 按 If-None-Match (优先) 与 If-Modified-Since 判断是否可返回 304
+func notModified(r *http.Request, etag string, modTime time.Time) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		return etagMatches(inm, etag)
+	}
+	if ims, err := http.ParseTime(r.Header.Get("If-Modified-Since")); err == nil && !modTime.IsZero() {
+		return !modTime.Truncate(time.Second).After(ims)
+	}
+	return false
+}
+
+// imageVariant 描述一次图片响应：由哪些源文件、以什么宽度与格式生成
+type imageVariant struct {
+	kind   string // single / sprite，参与缓存键
+	srcs   []imageSource
+	width  int
+	src    imageFormat // 单图不缩放、不转码时直接输出 srcs[0]
+	render func() (image.Image, error)
+	// 非空时由它生成并缓存变体 (雪碧图的房间内存缓存)，否则缓存到 imageCacheDir
+	memory func(key string, format imageFormat) ([]byte, error)
+	// 额外的响应头 (雪碧图的布局信息)
+	headers map[string]string
+}
+
+// serveImageVariant 协商格式、处理条件请求，必要时生成并缓存变体后输出
+func serveImageVariant(w http.ResponseWriter, r *http.Request, v imageVariant) {
+	format := negotiateImageFormat(r.Header.Get("Accept"), v.src)
+	modTime := latestModTime(v.srcs)
+	passthrough := v.kind == "single" && v.width == 0
+
+	for {
+		key := imageVariantKey(v.kind, v.srcs, v.width, format)
+		etag := `"` + key + `"`
+		h := w.Header()
+		h.Set("Vary", "Accept")
+		h.Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(imageMaxAge.Seconds())))
+		h.Set("ETag", etag)
+		if !modTime.IsZero() {
+			h.Set("Last-Modified", modTime.UTC().Format(http.TimeFormat))
+		}
+		for k, val := range v.headers {
+			h.Set(k, val)
+		}
+		if notModified(r, etag, modTime) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		var data []byte
+		path := ""
+		if passthrough && format == v.src {
+			path = v.srcs[0].path
+		} else {
+			var err error
+			if v.memory != nil {
+				data, err = v.memory(key, format)
+			} else {
+				path, err = buildImageVariant(key, format, v.render)
+			}
+			if err != nil {
+				fmt.Printf("生成图片变体失败 %s (w=%d %s): %v\n", v.srcs[0].path, v.width, format.ext, err)
+				if format != v.src {
+					// AVIF/WebP 编码失败时这次改用原格式；缺少编码器时之后也不再协商该格式
+					if errors.Is(err, errEncoderMissing) {
+						imageEncoderBroken.Store(format.ext, true)
+					}
+					format = v.src
+					continue
+				}
+				h.Del("ETag")
+				h.Del("Last-Modified")
+				http.Error(w, "图片处理失败", http.StatusInternalServerError)
+				return
+			}
+		}
+
+		h.Set("Content-Type", format.ctype)
+		if v.memory != nil {
+			http.ServeContent(w, r, "", modTime, bytes.NewReader(data))
+			return
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			http.Error(w, "图片不存在", http.StatusNotFound)
+			return
+		}
+		defer f.Close()
+		http.ServeContent(w, r, "", modTime, f)
+		return
+	}
+}
+
+// serveImage 输出单张图片的缩放 / 转码变体
+func serveImage(w http.ResponseWriter, r *http.Request, srcPath, srcType string) {
+	src, err := statImageSource(srcPath)
+	if err != nil {
+		http.Error(w, "图片不存在", http.StatusNotFound)
+		return
+	}
+	width, err := snapImageWidth(r.URL.Query().Get("w"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	serveImageVariant(w, r, imageVariant{
+		kind:  "single",
+		srcs:  []imageSource{src},
+		width: width,
+		src:   imageFormatByType(srcType),
+		render: func() (image.Image, error) {
+			img, err := decodeImageFile(srcPath)
+			if err != nil {
+				return nil, err
+			}
+			if b := img.Bounds(); width > 0 && width < b.Dx() {
+				img = scaleImage(img, b, width, max(1, b.Dy()*width/b.Dx()))
+			}
+			return img, nil
+		},
+	})
+}
+
+// resolvePictureURL 把牌上的 /api/picture 地址解析为源文件
+func resolvePictureURL(raw string) (string, string, bool) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", "", false
+	}
+	q := u.Query()
+	if !imageIDPattern.MatchString(q.Get("id")) {
+		return "", "", false
+	}
+	mode, ok := lookupMode(q.Get("mode"))
+	if !ok {
+		return "", "", false
+	}
+	return mode.CardImage(q.Get("id"))
+}
+
+// handlePictureSprite 处理 /api/picture/sprite?roomId=&w=
+// 按房间当前场上牌的顺序排成 4 列，第 i 张牌位于 (i%4, i/4)，没有图片的牌留空；
+// 单元格尺寸由 X-Sprite-Cell 给出 (宽x高)
+func handlePictureSprite(w http.ResponseWriter, r *http.Request) {
+	roomID := normalizeRoomID(r.URL.Query().Get("roomId"))
+	globalMutex.Lock()
+	room, exists := rooms[roomID]
+	globalMutex.Unlock()
+	if !exists {
+		if _, addr, ok := cluster.locateRoom(roomID); ok {
+			http.Redirect(w, r, nodeHTTPBase(addr)+r.URL.RequestURI(), http.StatusTemporaryRedirect)
+			return
+		}
+		http.Error(w, "房间不存在", http.StatusNotFound)
+		return
+	}
+
+	width := defaultSpriteWidth
+	if raw := r.URL.Query().Get("w"); raw != "" {
+		var err error
+		if width, err = snapImageWidth(raw); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		width = min(width, maxImageWidth/spriteColumns)
+	}
+	height := width * 3 / 2
+
+	room.Mutex.Lock()
+	urls := make([]string, len(room.BoardCards))
+	for i, c := range room.BoardCards {
+		urls[i] = c.PictureUrl
+	}
+	if room.Sprites == nil {
+		room.Sprites = &spriteCache{}
+	}
+	sprites := room.Sprites
+	room.Mutex.Unlock()
+	if len(urls) == 0 {
+		http.Error(w, "本局还没有发牌", http.StatusNotFound)
+		return
+	}
+
+	srcs := make([]imageSource, len(urls))
+	for i, u := range urls {
+		srcs[i] = imageSource{path: "-"}
+		if u == "" {
+			continue
+		}
+		if path, _, ok := resolvePictureURL(u); ok {
+			if src, err := statImageSource(path); err == nil {
+				srcs[i] = src
+			}
+		}
+	}
+
+	rows := (len(srcs) + spriteColumns - 1) / spriteColumns
+	render := renderSprite(srcs, width, height, rows)
+	serveImageVariant(w, r, imageVariant{
+		kind:  "sprite",
+		srcs:  srcs,
+		width: width,
+		src:   formatJPEG,
+		memory: func(key string, format imageFormat) ([]byte, error) {
+			return sprites.load(strings.Join(urls, "\n"), key, func() ([]byte, error) {
+				return renderImageBytes(room.ID+"-"+key, format, render)
+			})
+		},
+		headers: map[string]string{
+			"X-Sprite-Columns": strconv.Itoa(spriteColumns),
+			"X-Sprite-Cell":    fmt.Sprintf("%dx%d", width, height),
+			"X-Sprite-Count":   strconv.Itoa(len(srcs)),
+		},
+		render: render,
+	})
+}
+
+// renderSprite 返回按场上牌顺序拼接雪碧图的函数
+func renderSprite(srcs []imageSource, width, height, rows int) func() (image.Image, error) {
+	return func() (image.Image, error) {
+		sheet := image.NewRGBA(image.Rect(0, 0, width*spriteColumns, height*rows))
+		draw.Draw(sheet, sheet.Bounds(), image.NewUniform(color.RGBA{0x2c, 0x30, 0x44, 0xff}), image.Point{}, draw.Src)
+		for i, src := range srcs {
+			if src.st == nil {
+				continue
+			}
+			img, err := decodeImageFile(src.path)
+			if err != nil {
+				fmt.Printf("雪碧图跳过无法解码的图片 %s: %v\n", src.path, err)
+				continue
+			}
+			cell := scaleImage(img, coverCrop(img.Bounds(), width, height), width, height)
+			at := image.Pt(i%spriteColumns*width, i/spriteColumns*height)
+			draw.Draw(sheet, cell.Bounds().Add(at), cell, image.Point{}, draw.Src)
+		}
+		return sheet, nil
+	}
+}
+
+// spriteCache 是一个房间的雪碧图缓存，只保留当前这副牌 (board) 的各个变体，换牌后整体清空
+type spriteCache struct {
+	mu      sync.Mutex
+	board   string
+	entries map[string]*spriteEntry
+}
+
+type spriteEntry struct {
+	ready chan struct{}
+	data  []byte
+	err   error
+}
+
+// load 返回当前这副牌的 key 变体，同一变体的并发请求只生成一次；生成失败不缓存
+func (c *spriteCache) load(board, key string, build func() ([]byte, error)) ([]byte, error) {
+	c.mu.Lock()
+	if c.board != board {
+		c.board = board
+		c.entries = make(map[string]*spriteEntry)
+	}
+	e, ok := c.entries[key]
+	if !ok {
+		e = &spriteEntry{ready: make(chan struct{})}
+		c.entries[key] = e
+	}
+	c.mu.Unlock()
+	if ok {
+		<-e.ready
+		return e.data, e.err
+	}
+
+	e.data, e.err = build()
+	if e.err != nil {
+		c.mu.Lock()
+		if c.entries[key] == e {
+			delete(c.entries, key)
+		}
+		c.mu.Unlock()
+	}
+	close(e.ready)
+	return e.data, e.err
+}
+
+// renderImageBytes 生成变体并直接返回内容，临时文件用完即删；name 需在同时进行的生成中唯一
+func renderImageBytes(name string, format imageFormat, render func() (image.Image, error)) ([]byte, error) {
+	out := filepath.Join(imageCacheDir, ".mem-"+name+format.ext)
+	defer os.Remove(out)
+	if err := renderImageVariant(name, out, format, render); err != nil {
+		return nil, err
+	}
+	return os.ReadFile(out)
+}
+
+// buildImageVariant 返回缓存中的变体路径，不存在时调用 render 生成
+func buildImageVariant(key string, format imageFormat, render func() (image.Image, error)) (string, error) {
+	out := filepath.Join(imageCacheDir, key+format.ext)
+	err := imageBuilds.build(out, func() error {
+		return renderImageVariant(key, out, format, render)
+	})
+	if err != nil {
+		return "", err
+	}
+	return out, nil
+}
+
+func renderImageVariant(key, out string, format imageFormat, render func() (image.Image, error)) error {
+	imageSlots <- struct{}{}
+	defer func() { <-imageSlots }()
+
+	start := time.Now()
+	if err := os.MkdirAll(imageCacheDir, 0755); err != nil {
+		return err
+	}
+	img, err := render()
+	if err != nil {
+		return err
+	}
+
+	// 同一变体同时只有一个生成者，临时文件名按 key 区分即可
+	tmp := filepath.Join(imageCacheDir, ".tmp-"+key+format.ext)
+	defer os.Remove(tmp)
+	switch format {
+	case formatJPEG:
+		err = writeImageFile(tmp, func(w io.Writer) error { return jpeg.Encode(w, img, &jpeg.Options{Quality: 85}) })
+	case formatPNG:
+		err = writeImageFile(tmp, func(w io.Writer) error { return png.Encode(w, img) })
+	default:
+		// 先输出无损 PNG，再交给 ffmpeg 编码
+		lossless := filepath.Join(imageCacheDir, ".tmp-"+key+".png")
+		defer os.Remove(lossless)
+		err = writeImageFile(lossless, func(w io.Writer) error { return png.Encode(w, img) })
+		if err == nil {
+			err = encodeImage(lossless, tmp, format)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, out); err != nil {
+		return err
+	}
+	b := img.Bounds()
+	fmt.Printf("已生成图片变体 %s (%dx%d %s)，耗时 %v\n", key, b.Dx(), b.Dy(), format.ext, time.Since(start).Round(time.Millisecond))
+	return nil
+}
+
+func writeImageFile(path string, encode func(w io.Writer) error) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := encode(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func decodeImageFile(path string) (image.Image, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	cfg, _, err := image.DecodeConfig(f)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Width > maxSourceImageSide || cfg.Height > maxSourceImageSide {
+		return nil, fmt.Errorf("图片尺寸过大: %dx%d", cfg.Width, cfg.Height)
+	}
+	if _, err := f.Seek(0, 0); err != nil {
+		return nil, err
+	}
+	img, _, err := image.Decode(f)
+	return img, err
+}
+
+// coverCrop 返回居中裁剪出 width:height 比例的源区域 (同 CSS object-fit: cover)
+func coverCrop(b image.Rectangle, width, height int) image.Rectangle {
+	w, h := b.Dx(), b.Dy()
+	if w*height > h*width {
+		cw := h * width / height
+		x := b.Min.X + (w-cw)/2
+		return image.Rect(x, b.Min.Y, x+cw, b.Max.Y)
+	}
+	ch := w * height / width
+	y := b.Min.Y + (h-ch)/2
+	return image.Rect(b.Min.X, y, b.Max.X, y+ch)
+}
+
+// scaleImage 把源图的 area 区域按面积平均缩放到 width x height
+func scaleImage(src image.Image, area image.Rectangle, width, height int) image.Image {
+	dst := image.NewRGBA64(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		sy0 := area.Min.Y + y*area.Dy()/height
+		sy1 := max(sy0+1, area.Min.Y+(y+1)*area.Dy()/height)
+		for x := 0; x < width; x++ {
+			sx0 := area.Min.X + x*area.Dx()/width
+			sx1 := max(sx0+1, area.Min.X+(x+1)*area.Dx()/width)
+			var r, g, bl, a, n uint64
+			for sy := sy0; sy < sy1; sy++ {
+				for sx := sx0; sx < sx1; sx++ {
+					cr, cg, cb, ca := src.At(sx, sy).RGBA()
+					r, g, bl, a = r+uint64(cr), g+uint64(cg), bl+uint64(cb), a+uint64(ca)
+					n++
+				}
+			}
+			dst.SetRGBA64(x, y, color.RGBA64{uint16(r / n), uint16(g / n), uint16(bl / n), uint16(a / n)})
+		}
+	}
+	return dst
+}
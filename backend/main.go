@@ -2,11 +2,12 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"math/rand"
 	"net/http"
 	"os"
-	"path/filepath"
 	"sync"
 	"time"
 
@@ -20,7 +21,24 @@ type Player struct {
 	HasAnswered bool            `json:"hasAnswered"`
 	GameReady   bool            `json:"gameReady"`
 	IsReady     bool            `json:"-"`
+	JoinedAt    time.Time       `json:"-"`
 	Conn        *websocket.Conn `json:"-"`
+	// 电脑玩家：没有连接，由服务端驱动
+	IsBot bool           `json:"isBot"`
+	Bot   *botDifficulty `json:"-"`
+}
+
+// send 向玩家发送一条已编码的消息；机器人玩家没有连接，直接忽略
+func (p *Player) send(data []byte) {
+	if p.Conn != nil {
+		p.Conn.WriteMessage(websocket.TextMessage, data)
+	}
+}
+
+func (p *Player) close() {
+	if p.Conn != nil {
+		p.Conn.Close()
+	}
 }
 
 type Song struct {
@@ -28,15 +46,12 @@ type Song struct {
 	TitleOriginal    string `json:"title_original"`
 	TitleTranslation string `json:"title_translation"`
 	Duration         int    `json:"duration"`
-	CharacterID      int    `json:"-"` // touhou
-	CharacterName    string `json:"-"` // touhou
-}
-
-type TouhouCharacter struct {
-	ID         int            `json:"id"`
-	Character  string         `json:"character"`
-	MusicCount int            `json:"music_count"`
-	Data       map[string]int `json:"data"` // songId -> duration
+	CharacterID      int    `json:"-"`               // touhou
+	CharacterName    string `json:"-"`               // touhou
+	Mode             string `json:"-"`               // 出题的模式名，混合题库时用于定位音频与判题
+	Cover            string `json:"cover,omitempty"` // vocaloid: vocaloid/cover 下的封面文件名，可选
+	TrackSeed        int64  `json:"-"`               // touhou: 本局游戏选定的曲目子集，见 trackSongs
+	SongMeta
 }
 
 type Card struct {
@@ -46,13 +61,15 @@ type Card struct {
 	IsMatched        bool   `json:"isMatched"`
 	CharacterID      int    `json:"characterId,omitempty"`   // touhou
 	CharacterName    string `json:"characterName,omitempty"` // touhou
-	PictureUrl       string `json:"pictureUrl,omitempty"`    // touhou
+	PictureUrl       string `json:"pictureUrl,omitempty"`    // touhou 立绘，其他模式为封面
 }
 
 type Room struct {
 	ID       string
 	OwnerID  string
 	GameMode string
+	Mode     Mode
+	Filter   *songFilter // 建房时指定的题目筛选条件，nil 表示整个题库
 	Players  map[string]*Player
 	Mutex    sync.Mutex
 
@@ -64,7 +81,36 @@ type Room struct {
 	CurrentSongIndex int           `json:"-"`
 	RoundState       string        `json:"-"`
 	TimerCancel      chan struct{} `json:"-"`
+	TimerDeadline    time.Time     `json:"-"`
+	TimerFire        func(*Room)   `json:"-"` // 当前定时器到期时执行的动作，暂停后恢复时重新挂上
+	Paused           bool          `json:"-"`
+	PausedRemaining  time.Duration `json:"-"`
 	NoSongCorrect    bool          `json:"-"`
+	// 单人练习模式的进度，普通房间为 nil
+	Practice *practiceSession `json:"-"`
+	// 每局从牌对应的全部曲目中重新抽取 (东方)，PlayedTracks 记录本局游戏已播放的曲目
+	AllTracks    bool            `json:"-"`
+	PlayedTracks map[string]bool `json:"-"`
+	// 牌面显示: both / art / title，开局发牌时生效
+	CardDisplay string `json:"-"`
+	// 题目音频特效 (变调、变速、噪声等)，零值表示不加，见 effects.go
+	Effects audioEffects `json:"-"`
+	// 预先选好的下一局 (客户端正在预取其加密音频) 与本局使用的预选，见 preload.go
+	NextRound      *roundPlan `json:"-"`
+	PreloadedRound *roundPlan `json:"-"`
+	// 本局下发的音频片段及其按格式的共享缓存，见 clip.go
+	RoundAudio *roundAudio `json:"-"`
+	// 当前这副牌的雪碧图，只保存在内存中，随房间释放，见 images.go
+	Sprites *spriteCache `json:"-"`
+
+	// 私密房间：需要密码或邀请码才能加入
+	Private      bool   `json:"-"`
+	PasswordHash []byte `json:"-"`
+	InviteToken  string `json:"-"`
+
+	ChatHistory []ChatEntry          `json:"-"`
+	Muted       map[string]time.Time `json:"-"` // playerID -> 禁言截止时间
+	Banned      map[string]bool      `json:"-"` // 被房主封禁的 playerID，房间存续期间有效
 }
 
 // 统一 JSON 格式
@@ -73,124 +119,242 @@ type WsMessage struct {
 	Payload map[string]interface{} `json:"payload"`
 }
 
-// 全局题库
-var globalSongs []Song
-var globalTouhouChars []TouhouCharacter
+// 全局题库由各游戏模式持有 (见 mode.go)
+// 可通过管理接口在运行时重新加载，读写需持有 catalogMutex
+var catalogMutex sync.RWMutex
 
 var (
 	rooms = make(map[string]*Room)
-	// globalMutex 保护对 rooms map 的并发读写
+	// 本节点最多同时存在的房间数，可通过管理接口在运行时调整
+	maxRooms = 10
+	// globalMutex 保护对 rooms map 与 maxRooms 的并发读写
 	globalMutex = sync.Mutex{}
 
 	upgrader = websocket.Upgrader{
 		CheckOrigin: func(r *http.Request) bool { return true },
 	}
+
+	// 压测时在管理状态与 round_started 事件中附带本局答案，供 mgbot 按正确率作答；默认关闭，
+	// 否则任何能看到管理面板或事件日志的人都能在局中看到答案
+	exposeRoundAnswers = false
 )
 
 func main() {
-	loadSongs()
-	loadTouhouChars()
+	listenAddr := flag.String("listen", ":3000", "HTTP 监听地址")
+	nodeID := flag.String("node-id", "", "集群节点 ID，多节点部署时每个进程唯一")
+	publicAddr := flag.String("public-addr", "", "本节点对外地址 (如 wss://node1.metagaruta.com)，其它节点据此重定向玩家")
+	directory := flag.String("directory", "memory", "房间目录: memory 或 redis://[:password@]host:port/db")
+	codeAlphabet := flag.String("room-code-alphabet", defaultRoomCodeAlphabet, "房间号字母表 (易混淆字符 0/O/1/I/L 会被剔除)")
+	codeLength := flag.Int("room-code-length", 5, "房间号长度")
+	flag.IntVar(&chatMaxLen, "chat-max-len", chatMaxLen, "单条聊天消息最大字数")
+	chatFilterPath := flag.String("chat-filter", "", "敏感词表文件，每行一个词")
+	flag.StringVar(&audit.path, "audit-log", audit.path, "管理操作审计日志文件，留空则只保留在内存中")
+	flag.IntVar(&maxRooms, "max-rooms", maxRooms, "本节点最多同时存在的房间数")
+	flag.IntVar(&maxConnsPerIP, "max-conns-per-ip", maxConnsPerIP, "同一 IP 最多同时保持的 WebSocket 连接数")
+	exempt := flag.String("ratelimit-exempt", "", "不受 IP 级限流的地址 (逗号分隔的 IP/CIDR)，供压测机使用")
+	flag.StringVar(&packsDir, "packs-dir", packsDir, "自定义曲包存放目录")
+	packLimitMB := flag.Int64("max-pack-mb", maxPackBytes>>20, "单个曲包上传大小上限 (MB)")
+	packsQuotaMB := flag.Int64("packs-quota-mb", maxPacksTotalBytes>>20, "曲包目录总占用上限 (MB)，0 表示不限")
+	flag.DurationVar(&packTTL, "pack-ttl", packTTL, "曲包连续多久无人使用后删除，0 表示永久保留")
+	flag.BoolVar(&packUploadAdminOnly, "pack-upload-admin", false, "只允许携带管理令牌的请求上传曲包")
+	flag.StringVar(&ffmpegPath, "ffmpeg", ffmpegPath, "ffmpeg 可执行文件，用于曲包音频转码")
+	flag.StringVar(&ffprobePath, "ffprobe", ffprobePath, "ffprobe 可执行文件")
+	flag.StringVar(&imageCacheDir, "image-cache", imageCacheDir, "缩放 / 转码后的牌面图片缓存目录")
+	flag.StringVar(&audioCacheDir, "audio-cache", audioCacheDir, "转码后的题目音频缓存目录")
+	flag.Float64Var(&audioLoudness, "audio-loudness", audioLoudness, "题目音频统一到的响度 (LUFS)，0 表示不调整")
+	flag.BoolVar(&audioClipEnabled, "audio-clip", audioClipEnabled, "只下发每局播放的音频片段 (需要 ffmpeg)")
+	flag.DurationVar(&imageMaxAge, "image-max-age", imageMaxAge, "牌面图片的浏览器缓存时长，过期后凭 ETag 重新验证")
+	flag.BoolVar(&exposeRoundAnswers, "loadtest-answers", false, "在管理状态与事件流中公开各房间本局的答案，供 mgbot 压测按正确率作答 (切勿在正式服开启)")
+	proxies := flag.String("trusted-proxies", "127.0.0.1,::1", "受信任的反向代理 (逗号分隔的 IP/CIDR)，仅采信这些代理的 X-Forwarded-For")
+	flag.StringVar(&realIPHeader, "real-ip-header", "", "受信任代理在没有 X-Forwarded-For 时传递客户端 IP 的请求头 (如 X-Real-IP)，仅当代理总会覆盖该头时配置")
+	var adminCfg adminConfig
+	flag.StringVar(&adminCfg.Listen, "admin-listen", "", "管理接口独立监听地址，如 127.0.0.1:3001 或 unix:/run/metagaruta/admin.sock；留空则与游戏服务共用端口")
+	flag.StringVar(&adminCfg.TokensFile, "admin-tokens", "", "管理令牌文件，每行 \"名称 令牌\"")
+	flag.StringVar(&adminCfg.TLSCert, "admin-tls-cert", "", "管理接口 TLS 证书")
+	flag.StringVar(&adminCfg.TLSKey, "admin-tls-key", "", "管理接口 TLS 私钥")
+	flag.StringVar(&adminCfg.ClientCA, "admin-client-ca", "", "管理接口客户端 CA，配置后要求 mTLS")
+	flag.BoolVar(&adminCfg.AllowLocal, "admin-allow-local", false, "未配置令牌与 mTLS 时允许本机直连免认证访问管理接口 (仅限开发环境)")
+	flag.Parse()
+
+	nets, err := parseCIDRs(*proxies)
+	if err != nil {
+		fmt.Println("受信任代理配置无效:", err)
+		os.Exit(1)
+	}
+	if rateLimitExempt, err = parseCIDRs(*exempt); err != nil {
+		fmt.Println("限流豁免地址配置无效:", err)
+		os.Exit(1)
+	}
+	if len(rateLimitExempt) > 0 {
+		fmt.Printf("警告: 以下地址不受 IP 级限流: %s\n", *exempt)
+	}
+	trustedProxies = nets
+	maxPackBytes = *packLimitMB << 20
+	maxPacksTotalBytes = *packsQuotaMB << 20
+	if exposeRoundAnswers {
+		fmt.Println("警告: 已开启 -loadtest-answers，管理接口与事件流会公开本局答案，仅用于压测")
+	}
+	if !haveFFmpeg() {
+		fmt.Println("警告: 未找到 ffmpeg/ffprobe，曲包上传只接受 m4a 音频，题目音频按原格式发送")
+	}
+
+	if *chatFilterPath != "" {
+		f, err := loadWordListFilter(*chatFilterPath)
+		if err != nil {
+			fmt.Println("加载敏感词表失败:", err)
+			os.Exit(1)
+		}
+		chatFilter = f
+		fmt.Printf("成功加载 %d 个敏感词\n", len(f.words))
+	}
+
+	roomCodes = newRoomCodeGenerator(*codeAlphabet, *codeLength)
+	if err := roomCodes.validate(); err != nil {
+		fmt.Println("房间号配置无效:", err)
+		os.Exit(1)
+	}
+
+	if err := setupCluster(*nodeID, *publicAddr, *directory); err != nil {
+		fmt.Println("集群初始化失败:", err)
+		os.Exit(1)
+	}
+
+	loadCatalogs()
+	prewarmAudioVariants()
+	startPackJanitor()
+	if err := setupAdmin(adminCfg); err != nil {
+		fmt.Println("管理接口初始化失败:", err)
+		os.Exit(1)
+	}
 	http.HandleFunc("/ws", handleConnections)
 	http.HandleFunc("/api/audio", handleAudioProxy)
+	http.HandleFunc("/api/audio/preload", handleAudioPreload)
 	http.HandleFunc("/api/picture", handlePictureProxy)
-	http.HandleFunc("/api/admin/status", handleAdminStatus)
+	http.HandleFunc("/api/picture/sprite", handlePictureSprite)
+	http.HandleFunc("/api/packs", handlePackUpload)
+	http.HandleFunc("/api/packs/", handlePackInfo)
+	registerHealthRoutes(http.DefaultServeMux)
 	fmt.Println("---------------------------------------")
-	fmt.Println("歌牌游戏裁判服务器已启动 :3000/ws")
+	fmt.Printf("歌牌游戏裁判服务器已启动 %s/ws (节点: %s, 版本: %s)\n", *listenAddr, cluster.ID, currentVersion().Commit)
 	fmt.Println("---------------------------------------")
-	http.ListenAndServe(":3000", nil)
+	http.ListenAndServe(*listenAddr, nil)
 }
 
 // 处理音频请求
 func handleAudioProxy(w http.ResponseWriter, r *http.Request) {
-	roomID := r.URL.Query().Get("roomId")
+	roomID := normalizeRoomID(r.URL.Query().Get("roomId"))
 
 	globalMutex.Lock()
 	room, exists := rooms[roomID]
 	globalMutex.Unlock()
 
-	if !exists || room.CurrentSong == nil {
+	if !exists {
+		// 房间在其它节点上，让浏览器去对应节点取音频
+		if _, addr, ok := cluster.locateRoom(roomID); ok {
+			http.Redirect(w, r, nodeHTTPBase(addr)+r.URL.RequestURI(), http.StatusTemporaryRedirect)
+			return
+		}
+	}
+	if !exists {
 		http.Error(w, "找不到歌曲或游戏未开始", http.StatusNotFound)
 		return
 	}
-
-	var audioPath string
-	var contentType string
-	if room.GameMode == "touhou" {
-		// touhou: touhou/audio/{characterId}/{songId}.ogg
-		audioPath = filepath.Join("touhou", "audio",
-			fmt.Sprintf("%d", room.CurrentSong.CharacterID),
-			room.CurrentSong.ID+".ogg")
-		contentType = "audio/ogg"
-	} else {
-		// vocaloid: vocaloid/audio/{songId}.m4a
-		audioPath = filepath.Join("vocaloid", "audio", room.CurrentSong.ID+".m4a")
-		contentType = "audio/mp4"
-	}
-
-	if _, err := os.Stat(audioPath); os.IsNotExist(err) {
-		fmt.Printf("严重错误: 找不到音频文件: %s\n", audioPath)
-		http.Error(w, "音频文件不存在", http.StatusNotFound)
+	room.Mutex.Lock()
+	ra, mode := room.RoundAudio, room.Mode
+	room.Mutex.Unlock()
+	if ra == nil {
+		http.Error(w, "找不到歌曲或游戏未开始", http.StatusNotFound)
 		return
 	}
 
-	fmt.Printf("正在发送音频文件: %s\n", audioPath)
-
-	// 设置 Header，禁浏览器缓存
-	w.Header().Set("Cache-Control", "no-store, no-cache, must-revalidate")
-	w.Header().Set("Content-Type", contentType)
-
-	// 音频文件流返回给前端
-	http.ServeFile(w, r, audioPath)
+	serveRoundAudio(w, r, mode, ra)
 }
 
+// 处理牌面图片请求，缩放与格式协商见 images.go
 func handlePictureProxy(w http.ResponseWriter, r *http.Request) {
 	id := r.URL.Query().Get("id")
 	if id == "" {
 		http.Error(w, "缺少 id 参数", http.StatusBadRequest)
 		return
 	}
-	picPath := filepath.Join("touhou", "picture", id+".jpg")
-	if _, err := os.Stat(picPath); os.IsNotExist(err) {
-		http.Error(w, "图片不存在", http.StatusNotFound)
+	if !imageIDPattern.MatchString(id) {
+		http.Error(w, "无效的图片 id", http.StatusBadRequest)
 		return
 	}
-	w.Header().Set("Cache-Control", "public, max-age=86400")
-	w.Header().Set("Content-Type", "image/jpeg")
-	http.ServeFile(w, r, picPath)
-}
-
-func loadSongs() {
-	file, err := os.ReadFile("vocaloid/data/songs.json")
-	if err != nil {
-		fmt.Println("警告: 无法读取 vocaloid/data/songs.json，请检查路径！", err)
+	// 早期的牌面地址不带 mode，只有东方模式有图片
+	modeName := r.URL.Query().Get("mode")
+	if modeName == "" {
+		modeName = "touhou"
+	}
+	mode, ok := lookupMode(modeName)
+	if !ok {
+		http.Error(w, "未知的游戏模式", http.StatusBadRequest)
 		return
 	}
-	json.Unmarshal(file, &globalSongs)
-	fmt.Printf("成功加载 %d 首 Vocaloid 歌曲到全局题库\n", len(globalSongs))
+	picPath, contentType, ok := mode.CardImage(id)
+	if !ok {
+		http.Error(w, "无效的图片 id", http.StatusBadRequest)
+		return
+	}
+	serveImage(w, r, picPath, contentType)
 }
 
-func loadTouhouChars() {
-	file, err := os.ReadFile("touhou/data/data.json")
-	if err != nil {
-		fmt.Println("警告: 无法读取 touhou/data/data.json，请检查路径！", err)
-		return
+// 牌面显示方式：图文都显示、只显示封面 (art)、只显示标题 (title)
+const (
+	cardDisplayBoth  = "both"
+	cardDisplayArt   = "art"
+	cardDisplayTitle = "title"
+)
+
+func validCardDisplay(v string) bool {
+	return v == cardDisplayBoth || v == cardDisplayArt || v == cardDisplayTitle
+}
+
+// 持有 room.Mutex
+// roomCard 按房间的牌面显示设置生成歌牌；只看封面时在服务端去掉标题，
+// 没有封面的牌仍显示标题，东方立绘牌不受影响
+func roomCard(room *Room, s Song) Card {
+	card := room.Mode.BuildCard(s)
+	if card.CharacterID != 0 {
+		return card
 	}
-	// 去除 UTF-8 BOM (0xEF 0xBB 0xBF)，防止 json.Unmarshal 解析失败
-	if len(file) >= 3 && file[0] == 0xEF && file[1] == 0xBB && file[2] == 0xBF {
-		file = file[3:]
+	switch room.CardDisplay {
+	case cardDisplayArt:
+		if card.PictureUrl != "" {
+			card.TitleOriginal, card.TitleTranslation = "", ""
+		}
+	case cardDisplayTitle:
+		card.PictureUrl = ""
 	}
-	json.Unmarshal(file, &globalTouhouChars)
-	fmt.Printf("成功加载 %d 个东方角色到全局题库\n", len(globalTouhouChars))
+	return card
 }
 
-// 持有 globalMutex
-func generateRoomID() string {
-	for {
-		id := fmt.Sprintf("%04d", rand.Intn(10000))
-		if _, exists := rooms[id]; !exists {
-			return id
+// 已在集群目录中登记、尚未放入 rooms 的房间号，由 globalMutex 保护
+var pendingRoomIDs = make(map[string]bool)
+
+// 不能持有 globalMutex：向集群目录登记房间号需要访问网络
+// 房间号需同时在本节点与集群目录中都未被占用；返回的房间号记在 pendingRoomIDs 中，
+// 调用方放入 rooms 或放弃该房间号 (同时释放目录登记) 时移除
+func generateRoomID() (string, error) {
+	return roomCodes.next(func(id string) (bool, error) {
+		globalMutex.Lock()
+		_, exists := rooms[id]
+		taken := exists || pendingRoomIDs[id]
+		if !taken {
+			pendingRoomIDs[id] = true
 		}
-	}
+		globalMutex.Unlock()
+		if taken {
+			return true, nil
+		}
+		ok, err := cluster.claimRoom(id)
+		if !ok || err != nil {
+			globalMutex.Lock()
+			delete(pendingRoomIDs, id)
+			globalMutex.Unlock()
+		}
+		return !ok, err
+	})
 }
 
 func initGame(room *Room) {
@@ -199,105 +363,42 @@ func initGame(room *Room) {
 
 	room.State = "playing"
 	room.CurrentRound = 1
+	room.PlayedTracks = make(map[string]bool)
+	room.NextRound, room.PreloadedRound = nil, nil
+	room.RoundAudio = nil
 
-	if room.GameMode == "touhou" {
-		initTouhouGame(room)
+	if room.Practice != nil {
+		initPracticeGame(room)
 	} else {
-		initVocaloidGame(room)
+		initBoardGame(room)
 	}
 }
 
-func initVocaloidGame(room *Room) {
-	rand.Seed(time.Now().UnixNano())
-	shuffledAll := make([]Song, len(globalSongs))
-	copy(shuffledAll, globalSongs)
-	rand.Shuffle(len(shuffledAll), func(i, j int) {
-		shuffledAll[i], shuffledAll[j] = shuffledAll[j], shuffledAll[i]
-	})
-
-	poolSize := 25
-	if len(shuffledAll) < 25 {
-		poolSize = len(shuffledAll)
-	}
-	room.SongPool = shuffledAll[:poolSize]
-
-	cardSize := 16
-	if poolSize < 16 {
-		cardSize = poolSize
-	}
-
-	room.BoardCards = make([]Card, cardSize)
-	for i := 0; i < cardSize; i++ {
-		room.BoardCards[i] = Card{
-			ID:               room.SongPool[i].ID,
-			TitleOriginal:    room.SongPool[i].TitleOriginal,
-			TitleTranslation: room.SongPool[i].TitleTranslation,
-			IsMatched:        false,
-		}
-	}
-
-	rand.Shuffle(len(room.BoardCards), func(i, j int) {
-		room.BoardCards[i], room.BoardCards[j] = room.BoardCards[j], room.BoardCards[i]
-	})
-
-	fmt.Printf("房间 [%s] Vocaloid 游戏初始化完成，生成 %d 张牌\n", room.ID, cardSize)
-}
-
-func initTouhouGame(room *Room) {
-	rand.Seed(time.Now().UnixNano())
-
-	shuffledChars := make([]TouhouCharacter, len(globalTouhouChars))
-	copy(shuffledChars, globalTouhouChars)
-	rand.Shuffle(len(shuffledChars), func(i, j int) {
-		shuffledChars[i], shuffledChars[j] = shuffledChars[j], shuffledChars[i]
-	})
-
-	poolSize := 25
-	if len(shuffledChars) < 25 {
-		poolSize = len(shuffledChars)
-	}
-	selectedChars := shuffledChars[:poolSize]
+// 一局上场的歌牌数与题目池大小
+const (
+	boardSize = 16
+	poolSize  = 25
+)
 
-	room.SongPool = make([]Song, poolSize)
-	for i, char := range selectedChars {
-		keys := make([]string, 0, len(char.Data))
-		for k := range char.Data {
-			keys = append(keys, k)
-		}
-		songID := keys[rand.Intn(len(keys))]
-		duration := char.Data[songID]
-
-		room.SongPool[i] = Song{
-			ID:               songID,
-			TitleOriginal:    char.Character,
-			TitleTranslation: char.Character,
-			Duration:         duration,
-			CharacterID:      char.ID,
-			CharacterName:    char.Character,
-		}
-	}
+// initBoardGame 按房间的筛选条件抽取 25 道题作为题目池，其中 16 道上场
+func initBoardGame(room *Room) {
+	room.SongPool = room.Mode.BuildPool(poolSize, room.Filter)
 
-	cardSize := 16
-	if poolSize < 16 {
-		cardSize = poolSize
+	cardSize := boardSize
+	if len(room.SongPool) < boardSize {
+		cardSize = len(room.SongPool)
 	}
 
 	room.BoardCards = make([]Card, cardSize)
 	for i := 0; i < cardSize; i++ {
-		room.BoardCards[i] = Card{
-			ID:            room.SongPool[i].ID,
-			CharacterID:   room.SongPool[i].CharacterID,
-			CharacterName: room.SongPool[i].CharacterName,
-			PictureUrl:    fmt.Sprintf("/api/picture?id=%d", room.SongPool[i].CharacterID),
-			IsMatched:     false,
-		}
+		room.BoardCards[i] = roomCard(room, room.SongPool[i])
 	}
 
 	rand.Shuffle(len(room.BoardCards), func(i, j int) {
 		room.BoardCards[i], room.BoardCards[j] = room.BoardCards[j], room.BoardCards[i]
 	})
 
-	fmt.Printf("房间 [%s] Touhou 游戏初始化完成，生成 %d 张牌\n", room.ID, cardSize)
+	fmt.Printf("房间 [%s] %s 游戏初始化完成，生成 %d 张牌\n", room.ID, room.Mode.Label(), cardSize)
 }
 
 // 阶段一：开始新一回合，发送“准备”指令
@@ -308,6 +409,10 @@ func startRound(room *Room) {
 	if len(room.Players) == 0 {
 		return
 	}
+	if room.Practice != nil {
+		startPracticeRoundLocked(room)
+		return
+	}
 
 	room.RoundState = "preparing"
 
@@ -324,7 +429,8 @@ func startRound(room *Room) {
 			matchedCount++
 		}
 	}
-	if matchedCount >= 16 {
+	// 题目不足时上场的牌少于 boardSize，以实际上场的牌数为准
+	if matchedCount >= len(room.BoardCards) {
 		fmt.Printf("房间 [%s] 游戏结束，所有歌牌已清空！\n", room.ID)
 		var pList []Player
 		for _, p := range room.Players {
@@ -333,6 +439,7 @@ func startRound(room *Room) {
 		overMsg := WsMessage{Type: "game_over", Payload: map[string]interface{}{"players": pList}}
 		broadcastToRoom(room, overMsg)
 		room.RoundState = "ended"
+		events.publish(evGameOver, room.ID, map[string]interface{}{"scores": eventScores(pList)})
 		return
 	}
 
@@ -340,47 +447,75 @@ func startRound(room *Room) {
 		return
 	}
 
-	room.CurrentSongIndex = rand.Intn(len(room.SongPool))
-	targetSong := room.SongPool[room.CurrentSongIndex]
-	room.CurrentSong = &targetSong
-
-	maxStart := targetSong.Duration * 3 / 4
-	if maxStart <= 0 {
-		maxStart = 1
-	}
-	startTime := rand.Intn(maxStart)
-
-	playDuration := targetSong.Duration - startTime
-	if playDuration > 45 {
-		playDuration = 45
+	// 上一局播放时已预选本局题目的，沿用预选 (客户端已预取了加密音频)
+	var startTime, playDuration int
+	plan, idx := takeRoundPlanLocked(room)
+	room.PreloadedRound = plan
+	if plan != nil {
+		room.CurrentSongIndex = idx
+		if room.AllTracks {
+			setCurrentTrackLocked(room, plan.Song)
+		} else {
+			room.CurrentSong = &plan.Song
+		}
+		startTime, playDuration = plan.StartTime, plan.PlayDuration
+		room.RoundAudio = plan.Audio
+	} else {
+		room.CurrentSongIndex = rand.Intn(len(room.SongPool))
+		targetSong := room.SongPool[room.CurrentSongIndex]
+		room.CurrentSong = &targetSong
+		rotateTrackLocked(room)
+		startTime, playDuration = clipWindow(*room.CurrentSong)
+		room.RoundAudio = newRoundAudio(room.CurrentRound, *room.CurrentSong, startTime, playDuration, room.Effects)
 	}
+	targetSong := *room.CurrentSong
 
 	fmt.Printf("房间 [%s] 第 %d 局，播放时长: %d 秒\n", room.ID, room.CurrentRound, playDuration)
+	started := map[string]interface{}{
+		"round":        room.CurrentRound,
+		"playDuration": playDuration,
+	}
+	if exposeRoundAnswers {
+		started["songId"] = targetSong.ID
+	}
+	events.publish(evRoundStarted, room.ID, started)
 
 	// 发送 prepare_round 指令 (带上计算好的时长给前端)
 	prepMsg := WsMessage{
 		Type: "prepare_round",
 		Payload: map[string]interface{}{
 			"round":        room.CurrentRound,
-			"startTime":    startTime,
+			"startTime":    room.RoundAudio.relative(startTime),
 			"playDuration": playDuration,
+			"preloaded":    plan != nil,
 		},
 	}
 
 	msgBytes, _ := json.Marshal(prepMsg)
 	for _, p := range room.Players {
-		p.Conn.WriteMessage(websocket.TextMessage, msgBytes)
+		p.send(msgBytes)
 	}
 
-	room.TimerCancel = make(chan struct{})
-	go func(r *Room, roundNum int, cancelCh chan struct{}) {
-		select {
-		case <-time.After(5 * time.Second):
-			startCountdownAndPlay(r, roundNum)
-		case <-cancelCh:
-			return
-		}
-	}(room, room.CurrentRound, room.TimerCancel)
+	roundNum := room.CurrentRound
+	armRoomTimer(room, 5*time.Second, func(r *Room) {
+		startCountdownAndPlay(r, roundNum)
+	})
+	scheduleBotsReady(room)
+}
+
+// clipWindow 随机截取歌曲的一段作为本局播放片段 (单位: 秒)
+func clipWindow(song Song) (startTime, playDuration int) {
+	maxStart := song.Duration * 3 / 4
+	if maxStart <= 0 {
+		maxStart = 1
+	}
+	startTime = rand.Intn(maxStart)
+
+	playDuration = song.Duration - startTime
+	if playDuration > 45 {
+		playDuration = 45
+	}
+	return startTime, playDuration
 }
 
 // 阶段二：开始倒计时，然后正式播放
@@ -395,12 +530,17 @@ func startCountdownAndPlay(room *Room, roundNum int) {
 	countdownMsg := WsMessage{Type: "countdown_start", Payload: map[string]interface{}{}}
 	cdBytes, _ := json.Marshal(countdownMsg)
 	for _, p := range room.Players {
-		p.Conn.WriteMessage(websocket.TextMessage, cdBytes)
+		p.send(cdBytes)
 	}
-	room.Mutex.Unlock() // 必须先解锁，因为我们要睡 4 秒
 
-	time.Sleep(4 * time.Second)
+	armRoomTimer(room, 4*time.Second, func(r *Room) {
+		startPlayback(r, roundNum)
+	})
+	room.Mutex.Unlock()
+}
 
+// 阶段三：倒计时结束，正式播放
+func startPlayback(room *Room, roundNum int) {
 	room.Mutex.Lock()
 	if room.RoundState != "countdown" || room.CurrentRound != roundNum {
 		room.Mutex.Unlock()
@@ -410,32 +550,69 @@ func startCountdownAndPlay(room *Room, roundNum int) {
 
 	fmt.Printf("房间 [%s] 第 %d 局正式播放！\n", room.ID, room.CurrentRound)
 
+	// 本局音频是预取的，随开始信号下发密钥
 	playMsg := WsMessage{Type: "play_round", Payload: map[string]interface{}{}}
+	if room.PreloadedRound != nil {
+		playMsg.Payload = room.PreloadedRound.keyPayload()
+	}
 	msgBytes, _ := json.Marshal(playMsg)
 	for _, p := range room.Players {
-		p.Conn.WriteMessage(websocket.TextMessage, msgBytes)
+		p.send(msgBytes)
 	}
+	planNextRoundLocked(room)
+
+	armRoomTimer(room, 45*time.Second, func(r *Room) {
+		r.Mutex.Lock()
+		defer r.Mutex.Unlock()
+		if r.RoundState == "playing" && r.CurrentRound == roundNum {
+			endRound(r, "时间到！无人答对。", !isSongOnBoard(r), false)
+		}
+	})
+	scheduleBotAnswers(room, roundNum)
+	room.Mutex.Unlock()
+}
 
-	room.TimerCancel = make(chan struct{})
-	go func(r *Room, roundNum int, cancelCh chan struct{}) {
+// armRoomTimer 为房间挂上一个可取消、可暂停的定时器，到期后执行 fire
+// fire 在独立协程中执行，需要自行加锁
+// 持有 room.Mutex
+func armRoomTimer(room *Room, d time.Duration, fire func(r *Room)) {
+	cancelCh := make(chan struct{})
+	room.TimerCancel = cancelCh
+	room.TimerDeadline = time.Now().Add(d)
+	room.TimerFire = fire
+	go func(r *Room) {
 		select {
-		case <-time.After(45 * time.Second):
+		case <-time.After(d):
 			r.Mutex.Lock()
-			defer r.Mutex.Unlock()
-			if r.RoundState == "playing" && r.CurrentRound == roundNum {
-				endRound(r, "时间到！无人答对。", !isSongOnBoard(r), false)
+			if r.TimerCancel != cancelCh {
+				// 到期的同时被取消或暂停了
+				r.Mutex.Unlock()
+				return
 			}
+			r.TimerCancel = nil
+			r.TimerFire = nil
+			r.Mutex.Unlock()
+			fire(r)
 		case <-cancelCh:
 			return
 		}
-	}(room, room.CurrentRound, room.TimerCancel)
-	room.Mutex.Unlock()
+	}(room)
+}
+
+// cancelRoomTimer 取消房间当前的定时器
+// 持有 room.Mutex
+func cancelRoomTimer(room *Room) {
+	if room.TimerCancel != nil {
+		close(room.TimerCancel)
+		room.TimerCancel = nil
+	}
+	room.TimerFire = nil
 }
 
 // 辅助函数：检查当前歌曲是否真的在场上的 16 张牌中
 func isSongOnBoard(room *Room) bool {
 	for _, c := range room.BoardCards {
-		if c.ID == room.CurrentSong.ID && !c.IsMatched {
+		if !c.IsMatched && room.Mode.CheckAnswer(*room.CurrentSong, c.ID) {
 			return true
 		}
 	}
@@ -457,10 +634,7 @@ func isAllAnswered(room *Room) bool {
 func endRound(room *Room, reason string, removeSong bool, showAnswer bool) {
 	room.RoundState = "ended"
 
-	if room.TimerCancel != nil {
-		close(room.TimerCancel)
-		room.TimerCancel = nil
-	}
+	cancelRoomTimer(room)
 
 	if removeSong {
 		idx := room.CurrentSongIndex
@@ -476,9 +650,20 @@ func endRound(room *Room, reason string, removeSong bool, showAnswer bool) {
 			matchedCount++
 		}
 	}
-	isAllMatched := (matchedCount >= 16)
+	isAllMatched := (matchedCount >= len(room.BoardCards))
 
 	fmt.Printf("房间 [%s] 第 %d 局结束。原因: %s\n", room.ID, room.CurrentRound, reason)
+	events.publish(evRoundEnded, room.ID, map[string]interface{}{
+		"round":   room.CurrentRound,
+		"reason":  reason,
+		"songId":  room.CurrentSong.ID,
+		"answer":  room.CurrentSong.TitleOriginal,
+		"matched": matchedCount,
+	})
+	postSystemMessageLocked(room, fmt.Sprintf("第 %d 局: %s", room.CurrentRound, reason))
+	if showAnswer {
+		postSystemMessageLocked(room, fmt.Sprintf("正确答案是: %s", room.CurrentSong.TitleOriginal))
+	}
 
 	endMsg := WsMessage{
 		Type: "round_end",
@@ -491,27 +676,16 @@ func endRound(room *Room, reason string, removeSong bool, showAnswer bool) {
 	}
 	msgBytes, _ := json.Marshal(endMsg)
 	for _, p := range room.Players {
-		p.Conn.WriteMessage(websocket.TextMessage, msgBytes)
+		p.send(msgBytes)
 	}
 
 	// 3. 广播最新分数
 	// 注意：因为这里在锁里，不能直接调用 broadcastRoomState(room)
-	var playerList []Player
-	for _, p := range room.Players {
-		playerList = append(playerList, *p)
-	}
-	stateMsg := WsMessage{
-		Type:    "room_state_update",
-		Payload: map[string]interface{}{"players": playerList, "ownerId": room.OwnerID, "gameMode": room.GameMode},
-	}
-	stateBytes, _ := json.Marshal(stateMsg)
-	for _, p := range room.Players {
-		p.Conn.WriteMessage(websocket.TextMessage, stateBytes)
-	}
+	broadcastRoomStateLocked(room)
 
-	// 4. 开启一个独立的协程，3 秒后开启下一局（留出展示结算画面的时间）
-	go func(r *Room, isGameOver bool) {
-		time.Sleep(3 * time.Second)
+	// 4. 3 秒后开启下一局（留出展示结算画面的时间）
+	isGameOver := isAllMatched
+	armRoomTimer(room, 3*time.Second, func(r *Room) {
 		if isGameOver {
 			r.Mutex.Lock()
 			var pList []Player
@@ -521,21 +695,32 @@ func endRound(room *Room, reason string, removeSong bool, showAnswer bool) {
 			r.Mutex.Unlock()
 			overMsg := WsMessage{Type: "game_over", Payload: map[string]interface{}{"players": pList}}
 			broadcastToRoom(r, overMsg)
+			events.publish(evGameOver, r.ID, map[string]interface{}{"scores": eventScores(pList)})
 		} else {
 			r.Mutex.Lock()
 			r.CurrentRound++
 			r.Mutex.Unlock()
 			startRound(r)
 		}
-	}(room, isAllMatched)
+	})
 }
 
 func handleConnections(w http.ResponseWriter, r *http.Request) {
+	ip := clientIP(r)
+	if ok, reason := guard.admit(ip); !ok {
+		http.Error(w, reason, http.StatusTooManyRequests)
+		return
+	}
+	defer guard.release(ip)
+
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		fmt.Println("WebSocket 升级失败:", err)
 		return
 	}
+	conn.SetReadLimit(maxWsMessageSize)
+	limiter := guard.newConnLimiter(ip, func() { conn.Close() })
+	defer guard.forget(limiter)
 
 	var currentPlayer *Player
 	var currentRoom *Room
@@ -544,31 +729,53 @@ func handleConnections(w http.ResponseWriter, r *http.Request) {
 		if currentRoom != nil && currentPlayer != nil {
 			currentRoom.Mutex.Lock()
 			delete(currentRoom.Players, currentPlayer.ID)
-			isEmpty := len(currentRoom.Players) == 0
-			// 转移房主身份
-			if !isEmpty && currentRoom.OwnerID == currentPlayer.ID {
-				for _, p := range currentRoom.Players {
-					currentRoom.OwnerID = p.ID
+			// 只剩电脑玩家时同样视为空房间
+			isEmpty := true
+			for _, p := range currentRoom.Players {
+				if !p.IsBot {
+					isEmpty = false
 					break
 				}
 			}
+			// 转移房主身份：由在房间里待得最久的玩家继任
+			ownerChanged := false
+			if !isEmpty && currentRoom.OwnerID == currentPlayer.ID {
+				currentRoom.OwnerID = nextOwner(currentRoom).ID
+				ownerChanged = true
+			}
+			events.publish(evPlayerLeft, currentRoom.ID, map[string]interface{}{"playerId": currentPlayer.ID, "name": currentPlayer.Name})
+			if !isEmpty {
+				postSystemMessageLocked(currentRoom, fmt.Sprintf("玩家 [%s] 离开了房间", currentPlayer.Name))
+				if ownerChanged {
+					newOwner := currentRoom.Players[currentRoom.OwnerID]
+					postSystemMessageLocked(currentRoom, fmt.Sprintf("玩家 [%s] 成为新房主", newOwner.Name))
+					events.publish(evOwnerChanged, currentRoom.ID, map[string]interface{}{"playerId": newOwner.ID, "name": newOwner.Name})
+				}
+			}
 			currentRoom.Mutex.Unlock()
 
 			fmt.Printf("玩家 [%s] 离开了房间 [%s]\n", currentPlayer.Name, currentRoom.ID)
 
 			if isEmpty {
 				globalMutex.Lock()
-				delete(rooms, currentRoom.ID)
+				// 房间可能已被管理员关闭，房间号甚至已分配给新房间
+				owned := rooms[currentRoom.ID] == currentRoom
+				if owned {
+					delete(rooms, currentRoom.ID)
+				}
 				globalMutex.Unlock()
+				if owned {
+					cluster.releaseRoom(currentRoom.ID)
+				}
 
 				currentRoom.Mutex.Lock()
 				currentRoom.RoundState = "ended"
-				if currentRoom.TimerCancel != nil {
-					close(currentRoom.TimerCancel)
-					currentRoom.TimerCancel = nil
-				}
+				cancelRoomTimer(currentRoom)
 				currentRoom.Mutex.Unlock()
 				fmt.Printf("房间 [%s] 已空，销毁房间并释放资源\n", currentRoom.ID)
+				if owned {
+					events.publish(evRoomDestroyed, currentRoom.ID, map[string]interface{}{"reason": "empty"})
+				}
 			} else {
 				broadcastRoomState(currentRoom)
 			}
@@ -579,12 +786,37 @@ func handleConnections(w http.ResponseWriter, r *http.Request) {
 	for {
 		_, msgBytes, err := conn.ReadMessage()
 		if err != nil {
+			if errors.Is(err, websocket.ErrReadLimit) {
+				guard.noteOversized()
+				fmt.Printf("IP [%s] 发送的消息超过 %d 字节，断开连接\n", ip, maxWsMessageSize)
+			}
 			fmt.Println("玩家断开连接/网络异常")
 			break
 		}
 
+		// 无法解析的消息同样计入限流，归入其他消息类型的共享桶
 		var msg WsMessage
-		if err := json.Unmarshal(msgBytes, &msg); err != nil {
+		parseErr := json.Unmarshal(msgBytes, &msg)
+		if parseErr != nil {
+			msg.Type = ""
+		}
+
+		switch guard.check(limiter, msg.Type) {
+		case limitWarn:
+			warnMsg := WsMessage{
+				Type:    "rate_limited",
+				Payload: map[string]interface{}{"message": "操作过于频繁，请稍后再试！"},
+			}
+			wBytes, _ := json.Marshal(warnMsg)
+			conn.WriteMessage(websocket.TextMessage, wBytes)
+			continue
+		case limitDrop:
+			continue
+		case limitDisconnect:
+			fmt.Printf("IP [%s] 持续刷屏，断开连接\n", ip)
+			return
+		}
+		if parseErr != nil {
 			continue
 		}
 
@@ -597,74 +829,188 @@ func handleConnections(w http.ResponseWriter, r *http.Request) {
 			if gm, ok := msg.Payload["gameMode"].(string); ok && gm != "" {
 				gameMode = gm
 			}
+			// 练习模式：catalog 指定用哪个题库练习，房间内按该题库的模式出题
+			practice := gameMode == "practice"
+			if practice {
+				gameMode = "vocaloid"
+				if c, _ := msg.Payload["catalog"].(string); c != "" {
+					gameMode = c
+				}
+			}
+			// 自定义曲包：普通对局与练习都改用该曲包出题
+			packID, _ := msg.Payload["packId"].(string)
+			// 混合模式：catalogs 指定要混合的题库，缺省为全部内置题库
+			var catalogs []string
+			if list, ok := msg.Payload["catalogs"].([]interface{}); ok {
+				for _, c := range list {
+					if name, ok := c.(string); ok && name != "" {
+						catalogs = append(catalogs, name)
+					}
+				}
+			}
+			filterText, _ := msg.Payload["filter"].(string)
+			mode, filter, err := resolveRoomMode(gameMode, packID, catalogs, filterText)
+			if err != nil {
+				sendError(conn, "", err.Error())
+				continue
+			}
+			gameMode = mode.Name()
+			allTracks, _ := msg.Payload["allTracks"].(bool)
+			effects, err := parseAudioEffects(msg.Payload["effects"])
+			if err != nil {
+				sendError(conn, "", err.Error())
+				continue
+			}
 
 			globalMutex.Lock()
-			if len(rooms) >= 10 {
-				globalMutex.Unlock()
-				errMsg := WsMessage{
-					Type:    "error",
-					Payload: map[string]interface{}{"message": "当前房间数已达上限 (最多10个)，请稍后再试。"},
-				}
-				eBytes, _ := json.Marshal(errMsg)
-				conn.WriteMessage(websocket.TextMessage, eBytes)
+			full, limit := len(rooms) >= maxRooms, maxRooms
+			globalMutex.Unlock()
+			if full {
+				sendError(conn, "", fmt.Sprintf("当前房间数已达上限 (最多%d个)，请稍后再试。", limit))
+				continue
+			}
+			roomID, err := generateRoomID()
+			if err != nil {
+				fmt.Println("生成房间号失败:", err)
+				sendError(conn, "", "创建房间失败，请稍后再试。")
 				continue
 			}
-			roomID := generateRoomID()
 			room := &Room{
 				ID:       roomID,
 				OwnerID:  playerID,
 				GameMode: gameMode,
+				Mode:     mode,
+				Filter:   filter,
 				Players:  make(map[string]*Player),
+				Muted:    make(map[string]time.Time),
+				Banned:   make(map[string]bool),
 				State:    "waiting",
 			}
+			if practice {
+				room.Practice = &practiceSession{}
+			}
+			// 不支持多曲目的模式忽略 allTracks
+			room.AllTracks = allTracks && supportsAllTracks(mode)
+			room.CardDisplay = cardDisplayBoth
+			if cd, _ := msg.Payload["cardDisplay"].(string); validCardDisplay(cd) {
+				room.CardDisplay = cd
+			}
+			room.Effects = effects
+			password, _ := msg.Payload["password"].(string)
+			private, _ := msg.Payload["private"].(bool)
+			if private || password != "" {
+				room.Private = true
+				room.InviteToken = newInviteToken()
+				if password != "" {
+					room.PasswordHash = hashRoomPassword(password)
+				}
+			}
+			// 登记房间号期间其它玩家可能已经创建了房间，放入前再检查一次上限
+			globalMutex.Lock()
+			delete(pendingRoomIDs, roomID)
+			if len(rooms) >= maxRooms {
+				limit := maxRooms
+				globalMutex.Unlock()
+				cluster.releaseRoom(roomID)
+				sendError(conn, "", fmt.Sprintf("当前房间数已达上限 (最多%d个)，请稍后再试。", limit))
+				continue
+			}
 			rooms[roomID] = room
 			globalMutex.Unlock()
 
 			room.Mutex.Lock()
-			newPlayer := &Player{ID: playerID, Name: playerName, Score: 0, Conn: conn}
+			newPlayer := &Player{ID: playerID, Name: playerName, Score: 0, JoinedAt: time.Now(), Conn: conn}
 			room.Players[playerID] = newPlayer
 			currentPlayer = newPlayer
 			currentRoom = room
 			room.Mutex.Unlock()
 
+			createdPayload := map[string]interface{}{"roomId": roomID, "gameMode": gameMode, "private": room.Private, "practice": practice, "filter": filter.String(), "allTracks": room.AllTracks, "effects": effects}
+			if room.Private {
+				createdPayload["inviteToken"] = room.InviteToken
+			}
 			createdMsg := WsMessage{
 				Type:    "room_created",
-				Payload: map[string]interface{}{"roomId": roomID, "gameMode": gameMode},
+				Payload: createdPayload,
 			}
 			cBytes, _ := json.Marshal(createdMsg)
 			conn.WriteMessage(websocket.TextMessage, cBytes)
 
-			fmt.Printf("玩家 [%s] 创建了房间 [%s]\n", playerName, roomID)
+			fmt.Printf("玩家 [%s] 创建了房间 [%s] (%s)\n", playerName, roomID, mode.Label())
+			if filter != nil {
+				fmt.Printf("房间 [%s] 题目筛选条件: %s\n", roomID, filter)
+			}
+			if effects.active() {
+				fmt.Printf("房间 [%s] 音效: %s\n", roomID, effects)
+			}
+			events.publish(evRoomCreated, roomID, map[string]interface{}{
+				"ownerId":  playerID,
+				"owner":    playerName,
+				"gameMode": gameMode,
+				"filter":   filter.String(),
+				"effects":  effects.String(),
+				"private":  room.Private,
+				"practice": practice,
+			})
+			postSystemMessage(room, fmt.Sprintf("玩家 [%s] 创建了房间", playerName))
 			broadcastRoomState(room)
 
 		case "join_room":
-			roomID := msg.Payload["roomId"].(string)
+			roomID := normalizeRoomID(msg.Payload["roomId"].(string))
 			playerName := msg.Payload["playerName"].(string)
 			playerID := msg.Payload["playerId"].(string)
+			password, _ := msg.Payload["password"].(string)
+			inviteToken, _ := msg.Payload["inviteToken"].(string)
+
+			if joinGuard.blocked(ip) {
+				sendError(conn, roomID, "尝试加入房间失败次数过多，请稍后再试。")
+				continue
+			}
 
 			globalMutex.Lock()
 			room, exists := rooms[roomID]
 			globalMutex.Unlock()
 
 			if !exists {
-				errMsg := WsMessage{
-					Type:    "error",
-					Payload: map[string]interface{}{"message": "房间不存在！请检查房间号。"},
+				// 房间由其它节点持有：告知客户端改连对应节点
+				if ownerNode, addr, ok := cluster.locateRoom(roomID); ok {
+					redirectMsg := WsMessage{
+						Type: "redirect",
+						Payload: map[string]interface{}{
+							"roomId": roomID,
+							"nodeId": ownerNode,
+							"addr":   addr,
+						},
+					}
+					rBytes, _ := json.Marshal(redirectMsg)
+					conn.WriteMessage(websocket.TextMessage, rBytes)
+					continue
 				}
-				eBytes, _ := json.Marshal(errMsg)
-				conn.WriteMessage(websocket.TextMessage, eBytes)
+				joinGuard.fail(ip)
+				sendError(conn, roomID, "房间不存在！请检查房间号。")
 				continue
 			}
 
 			room.Mutex.Lock()
+			if room.Banned[playerID] {
+				room.Mutex.Unlock()
+				sendError(conn, roomID, "你已被该房间的房主封禁。")
+				continue
+			}
+			if room.Practice != nil {
+				room.Mutex.Unlock()
+				sendError(conn, roomID, "这是单人练习房间，无法加入。")
+				continue
+			}
+			if !canJoinPrivate(room, password, inviteToken) {
+				room.Mutex.Unlock()
+				joinGuard.fail(ip)
+				sendError(conn, roomID, "这是私密房间，密码或邀请码错误！")
+				continue
+			}
 			if len(room.Players) >= 8 {
 				room.Mutex.Unlock()
-				errMsg := WsMessage{
-					Type:    "error",
-					Payload: map[string]interface{}{"message": "房间人数已满 (最多8人)"},
-				}
-				msgBytes, _ := json.Marshal(errMsg)
-				conn.WriteMessage(websocket.TextMessage, msgBytes)
+				sendError(conn, roomID, "房间人数已满 (最多8人)")
 				continue
 			}
 			nameConflict := false
@@ -676,22 +1022,20 @@ func handleConnections(w http.ResponseWriter, r *http.Request) {
 			}
 			if nameConflict {
 				room.Mutex.Unlock()
-				errMsg := WsMessage{
-					Type:    "error",
-					Payload: map[string]interface{}{"message": "该房间已有同名玩家，请更换名称！"},
-				}
-				eBytes, _ := json.Marshal(errMsg)
-				conn.WriteMessage(websocket.TextMessage, eBytes)
+				sendError(conn, roomID, "该房间已有同名玩家，请更换名称！")
 				continue
 			}
 
-			newPlayer := &Player{ID: playerID, Name: playerName, Score: 0, Conn: conn}
+			newPlayer := &Player{ID: playerID, Name: playerName, Score: 0, JoinedAt: time.Now(), Conn: conn}
 			room.Players[playerID] = newPlayer
 			currentPlayer = newPlayer
 			currentRoom = room
 			room.Mutex.Unlock()
 
 			fmt.Printf("玩家 [%s] 加入了房间 [%s]\n", playerName, roomID)
+			events.publish(evPlayerJoined, roomID, map[string]interface{}{"playerId": playerID, "name": playerName})
+			sendChatHistory(room, conn)
+			postSystemMessage(room, fmt.Sprintf("玩家 [%s] 加入了房间", playerName))
 			broadcastRoomState(room)
 			room.Mutex.Lock()
 			roomState := room.State
@@ -718,17 +1062,37 @@ func handleConnections(w http.ResponseWriter, r *http.Request) {
 				conn.WriteMessage(websocket.TextMessage, msgBytes)
 			}
 
+		case "practice_replay", "practice_reveal", "practice_next":
+			if currentRoom != nil && currentPlayer != nil {
+				handlePracticeCommand(currentRoom, currentPlayer, msg.Type)
+			}
+
 		case "chat":
 			if currentRoom != nil && currentPlayer != nil {
-				text := msg.Payload["text"].(string)
-				chatMsg := WsMessage{
-					Type: "chat_receive",
-					Payload: map[string]interface{}{
-						"sender": currentPlayer.Name,
-						"text":   text,
-					},
-				}
-				broadcastToRoom(currentRoom, chatMsg)
+				text, _ := msg.Payload["text"].(string)
+				handleChat(currentRoom, currentPlayer, text)
+			}
+
+		case "add_bot", "remove_bot":
+			if currentRoom != nil && currentPlayer != nil {
+				handleBotCommand(currentRoom, currentPlayer, msg.Type, msg.Payload)
+			}
+
+		case "kick_player", "ban_player", "transfer_owner":
+			if currentRoom != nil && currentPlayer != nil {
+				targetID, _ := msg.Payload["playerId"].(string)
+				handleOwnerAction(currentRoom, currentPlayer, msg.Type, targetID)
+			}
+
+		case "set_card_display":
+			if currentRoom != nil && currentPlayer != nil {
+				value, _ := msg.Payload["cardDisplay"].(string)
+				handleCardDisplay(currentRoom, currentPlayer, value)
+			}
+
+		case "set_audio_effects":
+			if currentRoom != nil && currentPlayer != nil {
+				handleAudioEffects(currentRoom, currentPlayer, msg.Payload["effects"])
 			}
 
 		case "toggle_ready":
@@ -773,6 +1137,10 @@ func handleConnections(w http.ResponseWriter, r *http.Request) {
 					},
 				}
 				broadcastToRoom(currentRoom, startMsg)
+				events.publish(evGameStarted, currentRoom.ID, map[string]interface{}{
+					"gameMode": currentRoom.GameMode,
+					"players":  len(currentRoom.Players),
+				})
 
 				startRound(currentRoom)
 			}
@@ -788,18 +1156,21 @@ func handleConnections(w http.ResponseWriter, r *http.Request) {
 					currentRoom.SongPool = nil
 					currentRoom.CurrentSong = nil
 					currentRoom.CurrentSongIndex = 0
+					currentRoom.RoundAudio = nil
+					// 上一局预选的题目与管理员暂停状态不能带进新的一局
+					currentRoom.NextRound = nil
+					currentRoom.PreloadedRound = nil
+					currentRoom.Paused = false
+					currentRoom.PausedRemaining = 0
 					// 关闭可能残留的定时器协程
-					if currentRoom.TimerCancel != nil {
-						close(currentRoom.TimerCancel)
-						currentRoom.TimerCancel = nil
-					}
+					cancelRoomTimer(currentRoom)
 				}
 				// 重置所有玩家状态
 				for _, p := range currentRoom.Players {
 					p.Score = 0
 					p.HasAnswered = false
 					p.IsReady = false
-					p.GameReady = false
+					p.GameReady = p.IsBot
 				}
 				currentRoom.Mutex.Unlock()
 
@@ -814,98 +1185,170 @@ func handleConnections(w http.ResponseWriter, r *http.Request) {
 			}
 
 		case "client_ready":
-			if currentRoom != nil && currentRoom.RoundState == "preparing" {
-				currentRoom.Mutex.Lock()
-				currentPlayer.IsReady = true
-
-				allReady := true
-				for _, p := range currentRoom.Players {
-					if !p.IsReady {
-						allReady = false
-						break
-					}
-				}
-
-				if allReady {
-					if currentRoom.TimerCancel != nil {
-						close(currentRoom.TimerCancel)
-						currentRoom.TimerCancel = nil
-					}
-					currentRoom.Mutex.Unlock() // 先解锁，再调用 startCountdownAndPlay
-					startCountdownAndPlay(currentRoom, currentRoom.CurrentRound)
-				} else {
-					currentRoom.Mutex.Unlock()
-				}
+			if currentRoom != nil {
+				playerClientReady(currentRoom, currentPlayer)
 			}
 
 		case "buzz":
 			if currentRoom != nil {
-				currentRoom.Mutex.Lock() // 抢答锁
-
-				if currentRoom.RoundState == "playing" && !currentPlayer.HasAnswered {
-					cardID := msg.Payload["cardId"].(string)
-					currentPlayer.HasAnswered = true
-
-					if cardID == currentRoom.CurrentSong.ID {
-						currentPlayer.Score += 10
-						for i, c := range currentRoom.BoardCards {
-							if c.ID == cardID {
-								currentRoom.BoardCards[i].IsMatched = true
-								break
-							}
-						}
-						endRound(currentRoom, fmt.Sprintf("玩家 [%s] 抢答正确！(+10分)", currentPlayer.Name), true, true)
-					} else {
-						currentPlayer.Score -= 5
-						wrongMsg := WsMessage{Type: "wrong_answer", Payload: map[string]interface{}{}}
-						msgBytes, _ := json.Marshal(wrongMsg)
-						currentPlayer.Conn.WriteMessage(websocket.TextMessage, msgBytes)
-
-						if isAllAnswered(currentRoom) {
-							if currentRoom.NoSongCorrect {
-								endRound(currentRoom, "本轮歌曲不在场上，所有玩家鉴定完毕！", true, false)
-							} else {
-								endRound(currentRoom, "本轮无人答对。", !isSongOnBoard(currentRoom), false)
-							}
-						}
-					}
-				}
-				currentRoom.Mutex.Unlock()
+				cardID, _ := msg.Payload["cardId"].(string)
+				playerBuzz(currentRoom, currentPlayer, cardID)
 			}
 
 		case "no_song":
 			if currentRoom != nil {
-				currentRoom.Mutex.Lock()
+				playerNoSong(currentRoom, currentPlayer)
+			}
+		}
+	}
+}
+
+// ==========================================
+// 玩家操作：真人玩家与机器人共用同一套裁判逻辑
+// ==========================================
 
-				if currentRoom.RoundState == "playing" && !currentPlayer.HasAnswered {
-					currentPlayer.HasAnswered = true
+// playerClientReady 玩家音频加载完毕；所有人就绪后立即开始倒计时
+func playerClientReady(room *Room, player *Player) {
+	room.Mutex.Lock()
+	if room.RoundState != "preparing" || room.Paused {
+		room.Mutex.Unlock()
+		return
+	}
+	player.IsReady = true
+	if room.Practice != nil {
+		// 练习模式只有一名玩家，加载完毕直接播放，不走倒计时
+		startPracticePlaybackLocked(room)
+		room.Mutex.Unlock()
+		return
+	}
 
-					songOnBoard := isSongOnBoard(currentRoom)
+	allReady := true
+	for _, p := range room.Players {
+		if !p.IsReady {
+			allReady = false
+			break
+		}
+	}
 
-					if !songOnBoard {
-						currentPlayer.Score += 5
-						currentRoom.NoSongCorrect = true
+	if allReady {
+		cancelRoomTimer(room)
+		roundNum := room.CurrentRound
+		room.Mutex.Unlock() // 先解锁，再调用 startCountdownAndPlay
+		startCountdownAndPlay(room, roundNum)
+	} else {
+		room.Mutex.Unlock()
+	}
+}
 
-						if isAllAnswered(currentRoom) {
-							endRound(currentRoom, "本轮歌曲不在场上，所有玩家鉴定完毕！", true, false)
-						}
-					} else {
-						currentPlayer.Score -= 5
-						wrongMsg := WsMessage{Type: "wrong_answer", Payload: map[string]interface{}{}}
-						msgBytes, _ := json.Marshal(wrongMsg)
-						currentPlayer.Conn.WriteMessage(websocket.TextMessage, msgBytes)
+// playerBuzz 玩家抢牌
+func playerBuzz(room *Room, player *Player, cardID string) {
+	room.Mutex.Lock() // 抢答锁
+	defer room.Mutex.Unlock()
+	playerBuzzLocked(room, player, cardID)
+}
 
-						if isAllAnswered(currentRoom) {
-							endRound(currentRoom, "所有玩家选择错误，这首歌其实在场上。", false, false)
-						}
-					}
-				}
-				currentRoom.Mutex.Unlock()
+// 持有 room.Mutex
+func playerBuzzLocked(room *Room, player *Player, cardID string) {
+	if room.RoundState != "playing" || room.Paused || player.HasAnswered {
+		return
+	}
+	if room.Practice != nil {
+		practiceAnswerLocked(room, player, cardID)
+		return
+	}
+	player.HasAnswered = true
+	correct := room.Mode.CheckAnswer(*room.CurrentSong, cardID)
+	events.publish(evBuzz, room.ID, map[string]interface{}{
+		"playerId": player.ID,
+		"name":     player.Name,
+		"round":    room.CurrentRound,
+		"cardId":   cardID,
+		"correct":  correct,
+	})
+
+	if correct {
+		player.Score += 10
+		for i, c := range room.BoardCards {
+			if c.ID == cardID {
+				room.BoardCards[i].IsMatched = true
+				break
 			}
 		}
+		endRound(room, fmt.Sprintf("玩家 [%s] 抢答正确！(+10分)", player.Name), true, true)
+		return
+	}
+
+	player.Score -= 5
+	wrongMsg := WsMessage{Type: "wrong_answer", Payload: map[string]interface{}{}}
+	msgBytes, _ := json.Marshal(wrongMsg)
+	player.send(msgBytes)
+
+	if isAllAnswered(room) {
+		if room.NoSongCorrect {
+			endRound(room, "本轮歌曲不在场上，所有玩家鉴定完毕！", true, false)
+		} else {
+			endRound(room, "本轮无人答对。", !isSongOnBoard(room), false)
+		}
+	}
+}
+
+// playerNoSong 玩家判断本轮歌曲不在场上
+func playerNoSong(room *Room, player *Player) {
+	room.Mutex.Lock()
+	defer room.Mutex.Unlock()
+	playerNoSongLocked(room, player)
+}
+
+// 持有 room.Mutex
+func playerNoSongLocked(room *Room, player *Player) {
+	if room.RoundState != "playing" || room.Paused || player.HasAnswered {
+		return
+	}
+	if room.Practice != nil {
+		practiceAnswerLocked(room, player, "")
+		return
+	}
+	player.HasAnswered = true
+
+	songOnBoard := isSongOnBoard(room)
+	events.publish(evNoSong, room.ID, map[string]interface{}{
+		"playerId": player.ID,
+		"name":     player.Name,
+		"round":    room.CurrentRound,
+		"correct":  !songOnBoard,
+	})
+
+	if !songOnBoard {
+		player.Score += 5
+		room.NoSongCorrect = true
+
+		if isAllAnswered(room) {
+			endRound(room, "本轮歌曲不在场上，所有玩家鉴定完毕！", true, false)
+		}
+		return
+	}
+
+	player.Score -= 5
+	wrongMsg := WsMessage{Type: "wrong_answer", Payload: map[string]interface{}{}}
+	msgBytes, _ := json.Marshal(wrongMsg)
+	player.send(msgBytes)
+
+	if isAllAnswered(room) {
+		endRound(room, "所有玩家选择错误，这首歌其实在场上。", false, false)
 	}
 }
 
+// sendError 向单个连接发送错误提示，并记入管理事件流
+func sendError(conn *websocket.Conn, roomID string, message string) {
+	errMsg := WsMessage{
+		Type:    "error",
+		Payload: map[string]interface{}{"message": message},
+	}
+	eBytes, _ := json.Marshal(errMsg)
+	conn.WriteMessage(websocket.TextMessage, eBytes)
+	events.publish(evError, roomID, map[string]interface{}{"message": message})
+}
+
 // 将消息广播给房间里的所有人
 func broadcastToRoom(room *Room, msg WsMessage) {
 	room.Mutex.Lock()
@@ -913,56 +1356,55 @@ func broadcastToRoom(room *Room, msg WsMessage) {
 
 	msgBytes, _ := json.Marshal(msg)
 	for _, p := range room.Players {
-		p.Conn.WriteMessage(websocket.TextMessage, msgBytes)
+		p.send(msgBytes)
 	}
 }
 
-// 广播当前房间的玩家状态
-func broadcastRoomState(room *Room) {
+// 同 broadcastRoomState，供已持有 room.Mutex 的调用方使用
+// 两种广播都从这里发出，玩家列表之外带上房间设置，避免客户端收到不完整的状态
+func broadcastRoomStateLocked(room *Room) {
 	var playerList []Player
-	room.Mutex.Lock()
 	for _, p := range room.Players {
 		playerList = append(playerList, *p)
 	}
-	ownerID := room.OwnerID
-	room.Mutex.Unlock()
-
 	stateMsg := WsMessage{
 		Type: "room_state_update",
 		Payload: map[string]interface{}{
-			"players":  playerList,
-			"ownerId":  ownerID,
-			"gameMode": room.GameMode,
+			"players":     playerList,
+			"ownerId":     room.OwnerID,
+			"gameMode":    room.GameMode,
+			"practice":    room.Practice != nil,
+			"filter":      room.Filter.String(),
+			"allTracks":   room.AllTracks,
+			"cardDisplay": room.CardDisplay,
+			"effects":     room.Effects,
 		},
 	}
-	broadcastToRoom(room, stateMsg)
+	stateBytes, _ := json.Marshal(stateMsg)
+	for _, p := range room.Players {
+		p.send(stateBytes)
+	}
+}
+
+// 广播当前房间的玩家状态
+func broadcastRoomState(room *Room) {
+	room.Mutex.Lock()
+	defer room.Mutex.Unlock()
+	broadcastRoomStateLocked(room)
 }
 
 // ==========================================
 // 管理状态查询接口 (GET /api/admin/status)
-// 仅允许本机访问
+// 访问控制见 requireAdmin
 // ==========================================
 
 func handleAdminStatus(w http.ResponseWriter, r *http.Request) {
-	ip := r.RemoteAddr
-	// 仅允许来自本机的请求
-	isLocal := false
-	for _, prefix := range []string{"127.0.0.1", "::1", "[::1]"} {
-		if len(ip) >= len(prefix) && ip[:len(prefix)] == prefix {
-			isLocal = true
-			break
-		}
-	}
-	if !isLocal {
-		http.Error(w, "Forbidden", http.StatusForbidden)
-		return
-	}
-
 	globalMutex.Lock()
 	roomList := make([]*Room, 0, len(rooms))
 	for _, room := range rooms {
 		roomList = append(roomList, room)
 	}
+	roomCap := maxRooms
 	globalMutex.Unlock()
 
 	type PlayerInfo struct {
@@ -971,13 +1413,21 @@ func handleAdminStatus(w http.ResponseWriter, r *http.Request) {
 		Score       int    `json:"score"`
 		HasAnswered bool   `json:"hasAnswered"`
 		GameReady   bool   `json:"gameReady"`
+		IsBot       bool   `json:"isBot"`
 	}
 	type RoomInfo struct {
 		ID           string       `json:"id"`
 		OwnerID      string       `json:"ownerId"`
 		GameMode     string       `json:"gameMode"`
+		Filter       string       `json:"filter,omitempty"`
+		Effects      string       `json:"effects,omitempty"`
+		AllTracks    bool         `json:"allTracks,omitempty"`
+		Private      bool         `json:"private"`
+		Practice     bool         `json:"practice,omitempty"`
 		State        string       `json:"state"`
 		RoundState   string       `json:"roundState"`
+		Paused       bool         `json:"paused"`
+		PhaseLeftMs  int64        `json:"phaseRemainingMs"` // 当前阶段计时剩余毫秒，无计时为 0
 		CurrentRound int          `json:"currentRound"`
 		PlayerCount  int          `json:"playerCount"`
 		Players      []PlayerInfo `json:"players"`
@@ -985,20 +1435,32 @@ func handleAdminStatus(w http.ResponseWriter, r *http.Request) {
 		MatchedCards int          `json:"matchedCards"`
 		SongPoolSize int          `json:"songPoolSize"`
 		CurrentSong  string       `json:"currentSong,omitempty"`
+		CurrentID    string       `json:"currentSongId,omitempty"` // 正在播放的曲目 ID，仅 -loadtest-answers 时提供
+		AnswerCardID string       `json:"answerCardId,omitempty"`  // 本局正确歌牌的 ID (与 buzz 的 cardId 对应)，仅 -loadtest-answers 时提供
 	}
 	type StatusResponse struct {
-		Timestamp     string     `json:"timestamp"`
-		TotalRooms    int        `json:"totalRooms"`
-		TotalPlayers  int        `json:"totalPlayers"`
-		VocaloidSongs int        `json:"vocaloidSongs"`
-		TouhouChars   int        `json:"touhouChars"`
-		Rooms         []RoomInfo `json:"rooms"`
+		Timestamp     string         `json:"timestamp"`
+		NodeID        string         `json:"nodeId"`
+		TotalRooms    int            `json:"totalRooms"`
+		MaxRooms      int            `json:"maxRooms"`
+		TotalPlayers  int            `json:"totalPlayers"`
+		VocaloidSongs int            `json:"vocaloidSongs"`
+		TouhouChars   int            `json:"touhouChars"`
+		Catalogs      map[string]int `json:"catalogs"` // 模式 -> 题库条目数
+		Abuse         abuseStats     `json:"abuse"`
+		BannedIPs     int            `json:"bannedIps"`
+		Answers       bool           `json:"loadtestAnswers,omitempty"` // 是否附带各房间本局答案，见 exposeRoundAnswers
+		Rooms         []RoomInfo     `json:"rooms"`
 	}
 
 	var status StatusResponse
 	status.Timestamp = time.Now().Format("2006-01-02 15:04:05")
-	status.VocaloidSongs = len(globalSongs)
-	status.TouhouChars = len(globalTouhouChars)
+	status.NodeID = cluster.ID
+	status.Answers = exposeRoundAnswers
+	status.Abuse, status.BannedIPs = guard.snapshot()
+	status.Catalogs = catalogSizes()
+	status.VocaloidSongs = status.Catalogs["vocaloid"]
+	status.TouhouChars = status.Catalogs["touhou"]
 	status.Rooms = make([]RoomInfo, 0)
 
 	totalPlayers := 0
@@ -1008,8 +1470,14 @@ func handleAdminStatus(w http.ResponseWriter, r *http.Request) {
 			ID:           room.ID,
 			OwnerID:      room.OwnerID,
 			GameMode:     room.GameMode,
+			Filter:       room.Filter.String(),
+			Effects:      room.Effects.String(),
+			AllTracks:    room.AllTracks,
+			Private:      room.Private,
+			Practice:     room.Practice != nil,
 			State:        room.State,
 			RoundState:   room.RoundState,
+			Paused:       room.Paused,
 			CurrentRound: room.CurrentRound,
 			PlayerCount:  len(room.Players),
 			BoardCards:   len(room.BoardCards),
@@ -1025,6 +1493,15 @@ func handleAdminStatus(w http.ResponseWriter, r *http.Request) {
 		ri.MatchedCards = matched
 		if room.CurrentSong != nil {
 			ri.CurrentSong = room.CurrentSong.TitleOriginal
+			if exposeRoundAnswers {
+				ri.CurrentID = room.CurrentSong.ID
+				ri.AnswerCardID = room.Mode.BuildCard(*room.CurrentSong).ID
+			}
+		}
+		if room.Paused {
+			ri.PhaseLeftMs = room.PausedRemaining.Milliseconds()
+		} else if room.TimerFire != nil {
+			ri.PhaseLeftMs = max(time.Until(room.TimerDeadline).Milliseconds(), 0)
 		}
 		for _, p := range room.Players {
 			ri.Players = append(ri.Players, PlayerInfo{
@@ -1033,6 +1510,7 @@ func handleAdminStatus(w http.ResponseWriter, r *http.Request) {
 				Score:       p.Score,
 				HasAnswered: p.HasAnswered,
 				GameReady:   p.GameReady,
+				IsBot:       p.IsBot,
 			})
 		}
 		room.Mutex.Unlock()
@@ -1041,9 +1519,20 @@ func handleAdminStatus(w http.ResponseWriter, r *http.Request) {
 	}
 
 	status.TotalRooms = len(roomList)
+	status.MaxRooms = roomCap
 	status.TotalPlayers = totalPlayers
 
 	w.Header().Set("Content-Type", "application/json")
 	w.Header().Set("Cache-Control", "no-store")
 	json.NewEncoder(w).Encode(status)
 }
+
+// writeJSON 输出不缓存的 JSON 响应，供健康检查、曲包等 HTTP 接口共用
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	enc.Encode(body)
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+)
+
+// ==========================================
+// 游戏模式注册表
+// 每个系列 (Vocaloid、东方……) 实现 Mode 接口，并在自己文件的 init 中调用 registerMode；
+// 建房、发牌、判题、音频与图片代理都通过房间的 Mode 完成，不再按模式名分支
+// 新增一个系列只需新建一个 mode_xxx.go
+// ==========================================
+
+type Mode interface {
+	// Name 模式标识，即客户端 create_room 时传的 gameMode
+	Name() string
+	// Label 用于日志与提示的显示名
+	Label() string
+
+	// LoadCatalog 从磁盘读取题库但不替换现有题库；所有模式都读取成功后
+	// 由调用方在持有 catalogMutex 写锁时调用 apply 生效
+	LoadCatalog() (apply func(), size int, err error)
+	// CatalogSize 当前题库条目数，调用方持有 catalogMutex 读锁
+	CatalogSize() int
+	// BuildPool 从题库中符合筛选条件的题目里随机抽取至多 n 道作为一局的题目池，
+	// n <= 0 表示全部，filter 为 nil 表示不筛选；返回的题目需填好 Mode
+	BuildPool(n int, filter *songFilter) []Song
+	// BuildCard 为题目生成歌牌
+	BuildCard(s Song) Card
+	// CheckAnswer 判断玩家抢的牌是否对应本局题目
+	CheckAnswer(s Song, cardID string) bool
+
+	// AudioDir 音频根目录，供就绪检查使用
+	AudioDir() string
+	// AudioAsset 返回题目音频的文件路径与 Content-Type
+	AudioAsset(s Song) (path string, contentType string)
+	// CardImage 解析 /api/picture 的 id；该模式没有图片或 id 无效时 ok 为 false
+	CardImage(id string) (path string, contentType string, ok bool)
+}
+
+// multiTrackMode 由一张牌对应多段音频的模式实现 (东方角色有多首主题曲)，
+// 房间开启 allTracks 后每局从中重新抽取，见 tracks.go
+type multiTrackMode interface {
+	// Tracks 返回与 s 同一张牌、符合筛选条件的全部曲目
+	Tracks(s Song, filter *songFilter) []Song
+}
+
+var modeRegistry = make(map[string]Mode)
+
+// registerMode 只应在 init 中调用
+func registerMode(m Mode) {
+	if _, dup := modeRegistry[m.Name()]; dup {
+		panic("重复注册的游戏模式: " + m.Name())
+	}
+	modeRegistry[m.Name()] = m
+}
+
+// lookupMode 按名称查找模式；"pack:{packId}" 为玩家上传的曲包 (见 packs.go)
+func lookupMode(name string) (Mode, bool) {
+	if id, ok := strings.CutPrefix(name, packModePrefix); ok {
+		m, err := loadPack(id)
+		return m, err == nil
+	}
+	m, ok := modeRegistry[name]
+	return m, ok
+}
+
+// resolveRoomMode 确定新房间的出题模式与筛选条件，返回的错误可直接提示给玩家
+// gameMode 为 "mixed" 时按 catalogs 组合多个题库；指定了筛选条件或混合题库时，
+// 可出的题目少于一局的牌数则拒绝建房
+func resolveRoomMode(gameMode, packID string, catalogs []string, filterText string) (Mode, *songFilter, error) {
+	var mode Mode
+	switch {
+	case packID != "":
+		m, ok := lookupMode(packModePrefix + packID)
+		if !ok {
+			return nil, nil, fmt.Errorf("曲包不存在，请检查曲包 ID。")
+		}
+		mode = m
+	case gameMode == mixedModeName:
+		m, err := newMixedMode(catalogs)
+		if err != nil {
+			return nil, nil, err
+		}
+		mode = m
+	default:
+		m, ok := lookupMode(gameMode)
+		if !ok {
+			return nil, nil, fmt.Errorf("不支持的游戏模式: %s (可选: %s / %s)", gameMode, modeNames(), mixedModeName)
+		}
+		mode = m
+	}
+
+	// 记下曲包被选用，避免过期清理或管理员删除时房间还没出现在房间表里
+	for _, id := range packIDsOf(mode) {
+		if err := usePack(id); err != nil {
+			return nil, nil, fmt.Errorf("曲包不存在，请检查曲包 ID。")
+		}
+	}
+
+	filter, err := parseSongFilter(filterText)
+	if err != nil {
+		return nil, nil, err
+	}
+	if filter != nil || mode.Name() == mixedModeName {
+		if n := len(mode.BuildPool(0, filter)); n < boardSize {
+			return nil, nil, fmt.Errorf("符合条件的题目只有 %d 首，不足一局所需的 %d 首，请放宽筛选条件。", n, boardSize)
+		}
+	}
+	return mode, filter, nil
+}
+
+// allModes 按名称排序返回所有已注册的模式
+func allModes() []Mode {
+	modes := make([]Mode, 0, len(modeRegistry))
+	for _, m := range modeRegistry {
+		modes = append(modes, m)
+	}
+	sort.Slice(modes, func(i, j int) bool { return modes[i].Name() < modes[j].Name() })
+	return modes
+}
+
+func modeNames() string {
+	names := make([]string, 0, len(modeRegistry))
+	for _, m := range allModes() {
+		names = append(names, m.Name())
+	}
+	return strings.Join(names, " / ")
+}
+
+// loadCatalogs 启动时加载各模式的题库，读取失败的模式只打印警告
+func loadCatalogs() {
+	for _, m := range allModes() {
+		apply, n, err := m.LoadCatalog()
+		if err != nil {
+			fmt.Printf("警告: 无法读取 %s 题库，请检查路径！ %v\n", m.Label(), err)
+			continue
+		}
+		catalogMutex.Lock()
+		apply()
+		catalogMutex.Unlock()
+		fmt.Printf("成功加载 %d 条 %s 题目到全局题库\n", n, m.Label())
+	}
+}
+
+// reloadCatalog 重新读取所有模式的题库，任一读取失败或为空时全部保留原题库
+// 进行中的对局使用开局时复制的题目池，不受影响
+func reloadCatalog() (map[string]int, error) {
+	modes := allModes()
+	applies := make([]func(), 0, len(modes))
+	sizes := make(map[string]int, len(modes))
+	for _, m := range modes {
+		apply, n, err := m.LoadCatalog()
+		if err != nil {
+			return nil, fmt.Errorf("读取 %s 题库失败: %w", m.Label(), err)
+		}
+		if n == 0 {
+			return nil, fmt.Errorf("新的 %s 题库为空，已保留原题库", m.Label())
+		}
+		applies = append(applies, apply)
+		sizes[m.Name()] = n
+	}
+	catalogMutex.Lock()
+	for _, apply := range applies {
+		apply()
+	}
+	catalogMutex.Unlock()
+	fmt.Printf("题库已重新加载: %s\n", formatCatalogSizes(sizes))
+	prewarmAudioVariants()
+	return sizes, nil
+}
+
+// catalogSizes 返回各模式当前的题库规模
+func catalogSizes() map[string]int {
+	catalogMutex.RLock()
+	defer catalogMutex.RUnlock()
+	sizes := make(map[string]int, len(modeRegistry))
+	for name, m := range modeRegistry {
+		sizes[name] = m.CatalogSize()
+	}
+	return sizes
+}
+
+func formatCatalogSizes(sizes map[string]int) string {
+	parts := make([]string, 0, len(sizes))
+	for _, m := range allModes() {
+		if n, ok := sizes[m.Name()]; ok {
+			parts = append(parts, fmt.Sprintf("%s %d", m.Label(), n))
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// shuffledCopy 复制并打乱题库切片，调用方持有 catalogMutex 读锁
+func shuffledCopy[T any](src []T) []T {
+	out := make([]T, len(src))
+	copy(out, src)
+	rand.Shuffle(len(out), func(i, j int) { out[i], out[j] = out[j], out[i] })
+	return out
+}
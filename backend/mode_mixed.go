@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+)
+
+// ==========================================
+// 混合模式：把多个题库放进同一局
+// 不注册到模式表，由建房时的 catalogs 参数临时组合；每道题按 Song.Mode 交给来源模式
+// 出牌、判题与取音频。牌 ID 加上 "{来源模式}:" 前缀，避免不同题库的歌曲 ID 撞车
+// ==========================================
+
+const mixedModeName = "mixed"
+
+type mixedMode struct {
+	modes []Mode
+}
+
+// newMixedMode 按名称组合题库，names 为空时使用全部已注册模式
+func newMixedMode(names []string) (*mixedMode, error) {
+	if len(names) == 0 {
+		return &mixedMode{modes: allModes()}, nil
+	}
+	m := &mixedMode{}
+	seen := make(map[string]bool)
+	for _, name := range names {
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		sub, ok := lookupMode(name)
+		if !ok || name == mixedModeName {
+			return nil, fmt.Errorf("不支持的题库: %s (可选: %s)", name, modeNames())
+		}
+		m.modes = append(m.modes, sub)
+	}
+	if len(m.modes) < 2 {
+		return nil, fmt.Errorf("混合模式至少需要选择两个题库")
+	}
+	return m, nil
+}
+
+func (m *mixedMode) Name() string { return mixedModeName }
+
+// PackIDs 返回混合进来的曲包 ID
+func (m *mixedMode) PackIDs() []string {
+	var ids []string
+	for _, sub := range m.modes {
+		if pm, ok := sub.(*packMode); ok {
+			ids = append(ids, pm.manifest.ID)
+		}
+	}
+	return ids
+}
+
+func (m *mixedMode) Label() string {
+	labels := make([]string, len(m.modes))
+	for i, sub := range m.modes {
+		labels[i] = sub.Label()
+	}
+	return "混合(" + strings.Join(labels, "+") + ")"
+}
+
+// 混合模式没有自己的题库
+func (m *mixedMode) LoadCatalog() (func(), int, error) {
+	return func() {}, m.CatalogSize(), nil
+}
+
+func (m *mixedMode) CatalogSize() int {
+	n := 0
+	for _, sub := range m.modes {
+		n += sub.CatalogSize()
+	}
+	return n
+}
+
+// BuildPool 合并各题库中符合条件的题目后统一打乱，题库越大出现得越多
+func (m *mixedMode) BuildPool(n int, filter *songFilter) []Song {
+	var pool []Song
+	for _, sub := range m.modes {
+		pool = append(pool, sub.BuildPool(0, filter)...)
+	}
+	rand.Shuffle(len(pool), func(i, j int) { pool[i], pool[j] = pool[j], pool[i] })
+	if n > 0 && len(pool) > n {
+		pool = pool[:n]
+	}
+	return pool
+}
+
+func (m *mixedMode) source(s Song) Mode {
+	for _, sub := range m.modes {
+		if sub.Name() == s.Mode {
+			return sub
+		}
+	}
+	return m.modes[0]
+}
+
+func (m *mixedMode) BuildCard(s Song) Card {
+	card := m.source(s).BuildCard(s)
+	card.ID = s.Mode + ":" + card.ID
+	return card
+}
+
+func (m *mixedMode) CheckAnswer(s Song, cardID string) bool {
+	id, ok := strings.CutPrefix(cardID, s.Mode+":")
+	return ok && m.source(s).CheckAnswer(s, id)
+}
+
+// Tracks 来源模式支持多曲目时交给它，否则只有本身一首
+func (m *mixedMode) Tracks(s Song, filter *songFilter) []Song {
+	if mt, ok := m.source(s).(multiTrackMode); ok {
+		return mt.Tracks(s, filter)
+	}
+	return []Song{s}
+}
+
+func (m *mixedMode) AudioDir() string { return "" }
+
+func (m *mixedMode) AudioAsset(s Song) (string, string) {
+	return m.source(s).AudioAsset(s)
+}
+
+// 牌上的图片地址指向来源模式，混合模式本身不提供图片
+func (m *mixedMode) CardImage(id string) (string, string, bool) {
+	return "", "", false
+}
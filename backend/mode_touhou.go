@@ -0,0 +1,184 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+)
+
+// ==========================================
+// 东方模式：听角色曲找角色立绘
+// 题库 touhou/data/data.json，每个角色一张牌，开局时从该角色的曲目中随机选一首；
+// 房间开启 allTracks 时每局重新从该角色的曲目中抽取 (见 tracks.go)
+// 音频 touhou/audio/{characterId}/{songId}.ogg (也可以是其它格式，见 audio.go)，立绘 touhou/picture/{characterId}.jpg
+// 角色上的 game/year/tags 等元数据作为默认值，tracks 中可按曲目覆盖 (出典作品、专辑等)
+// ==========================================
+
+type TouhouCharacter struct {
+	ID         int                 `json:"id"`
+	Character  string              `json:"character"`
+	MusicCount int                 `json:"music_count"`      // 每局游戏参与出题的曲目数，0 表示 data 中的全部
+	Data       map[string]int      `json:"data"`             // songId -> duration
+	Tracks     map[string]SongMeta `json:"tracks,omitempty"` // songId -> 曲目元数据
+	SongMeta
+}
+
+// trackSongs 返回角色符合筛选条件的曲目，按 songId 排序
+// 符合条件的曲目多于 music_count 时按 seed 从中挑出 music_count 首：同一局游戏的题目带着同一个 seed
+// (Song.TrackSeed)，allTracks 轮换与练习展开都落在同一个子集里，换一局游戏就换一批；seed 为 0 时返回全部
+func (m *touhouMode) trackSongs(char *TouhouCharacter, filter *songFilter, seed int64) []Song {
+	ids := make([]string, 0, len(char.Data))
+	for id := range char.Data {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	var songs []Song
+	for _, songID := range ids {
+		s := Song{
+			ID:               songID,
+			TitleOriginal:    char.Character,
+			TitleTranslation: char.Character,
+			Duration:         char.Data[songID],
+			CharacterID:      char.ID,
+			CharacterName:    char.Character,
+			Mode:             m.Name(),
+			TrackSeed:        seed,
+			SongMeta:         char.trackMeta(songID),
+		}
+		if filter.Match(&s) {
+			songs = append(songs, s)
+		}
+	}
+	if seed != 0 && char.MusicCount > 0 && len(songs) > char.MusicCount {
+		r := rand.New(rand.NewSource(seed + int64(char.ID)))
+		r.Shuffle(len(songs), func(i, j int) { songs[i], songs[j] = songs[j], songs[i] })
+		songs = songs[:char.MusicCount]
+		sort.Slice(songs, func(i, j int) bool { return songs[i].ID < songs[j].ID })
+	}
+	return songs
+}
+
+// trackMeta 合并角色与曲目的元数据，曲目上的字段优先，tags 取并集
+func (c *TouhouCharacter) trackMeta(songID string) SongMeta {
+	meta := c.SongMeta
+	t, ok := c.Tracks[songID]
+	if !ok {
+		return meta
+	}
+	if t.Producer != "" {
+		meta.Producer = t.Producer
+	}
+	if t.Year != 0 {
+		meta.Year = t.Year
+	}
+	if len(t.Vocalist) > 0 {
+		meta.Vocalist = t.Vocalist
+	}
+	if t.Game != "" {
+		meta.Game = t.Game
+	}
+	if t.Album != "" {
+		meta.Album = t.Album
+	}
+	meta.Tags = append(append(stringList{}, meta.Tags...), t.Tags...)
+	return meta
+}
+
+type touhouMode struct {
+	chars []TouhouCharacter // 持有 catalogMutex
+}
+
+func init() {
+	registerMode(&touhouMode{})
+}
+
+func (m *touhouMode) Name() string  { return "touhou" }
+func (m *touhouMode) Label() string { return "东方" }
+
+func (m *touhouMode) LoadCatalog() (func(), int, error) {
+	file, err := os.ReadFile(filepath.Join("touhou", "data", "data.json"))
+	if err != nil {
+		return nil, 0, err
+	}
+	// 去除 UTF-8 BOM (0xEF 0xBB 0xBF)，防止 json.Unmarshal 解析失败
+	if len(file) >= 3 && file[0] == 0xEF && file[1] == 0xBB && file[2] == 0xBF {
+		file = file[3:]
+	}
+	var chars []TouhouCharacter
+	if err := json.Unmarshal(file, &chars); err != nil {
+		return nil, 0, err
+	}
+	return func() { m.chars = chars }, len(chars), nil
+}
+
+func (m *touhouMode) CatalogSize() int { return len(m.chars) }
+
+// BuildPool 每个角色至多出一道题，从该角色本局游戏的曲目子集中随机选一首
+func (m *touhouMode) BuildPool(n int, filter *songFilter) []Song {
+	catalogMutex.RLock()
+	chars := shuffledCopy(m.chars)
+	catalogMutex.RUnlock()
+
+	seed := rand.Int63() | 1 // 非 0，见 trackSongs
+
+	pool := make([]Song, 0, len(chars))
+	for i := range chars {
+		if n > 0 && len(pool) >= n {
+			break
+		}
+		candidates := m.trackSongs(&chars[i], filter, seed)
+		if len(candidates) == 0 {
+			continue
+		}
+		pool = append(pool, candidates[rand.Intn(len(candidates))])
+	}
+	return pool
+}
+
+// Tracks 返回与 s 同一角色、同一曲目子集的全部曲目；角色已不在题库中时只返回 s 本身
+func (m *touhouMode) Tracks(s Song, filter *songFilter) []Song {
+	catalogMutex.RLock()
+	defer catalogMutex.RUnlock()
+	for i := range m.chars {
+		if m.chars[i].ID == s.CharacterID {
+			if tracks := m.trackSongs(&m.chars[i], filter, s.TrackSeed); len(tracks) > 0 {
+				return tracks
+			}
+			break
+		}
+	}
+	return []Song{s}
+}
+
+// BuildCard 牌 ID 取角色 ID，同一角色换了曲目仍对应同一张牌
+func (m *touhouMode) BuildCard(s Song) Card {
+	return Card{
+		ID:            strconv.Itoa(s.CharacterID),
+		CharacterID:   s.CharacterID,
+		CharacterName: s.CharacterName,
+		PictureUrl:    fmt.Sprintf("/api/picture?mode=%s&id=%d", m.Name(), s.CharacterID),
+	}
+}
+
+func (m *touhouMode) CheckAnswer(s Song, cardID string) bool {
+	return cardID == strconv.Itoa(s.CharacterID)
+}
+
+func (m *touhouMode) AudioDir() string { return filepath.Join("touhou", "audio") }
+
+func (m *touhouMode) AudioAsset(s Song) (string, string) {
+	return findAudioSource(filepath.Join(m.AudioDir(), strconv.Itoa(s.CharacterID)), s.ID, ".ogg")
+}
+
+// CardImage 立绘以角色 ID 命名，只接受数字 id
+func (m *touhouMode) CardImage(id string) (string, string, bool) {
+	if _, err := strconv.ParseUint(id, 10, 32); err != nil {
+		return "", "", false
+	}
+	return filepath.Join("touhou", "picture", id+".jpg"), "image/jpeg", true
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+)
+
+// ==========================================
+// Vocaloid 模式：听歌找歌名
+// 题库 vocaloid/data/songs.json，音频 vocaloid/audio/{songId}.m4a (也可以是其它格式，见 audio.go)
+// 歌曲可用 cover 字段指定封面 (专辑图 / PV 截图)，放在 vocaloid/cover/ 下，仅支持 JPEG 与 PNG
+// ==========================================
+
+type vocaloidMode struct {
+	songs  []Song            // 持有 catalogMutex
+	covers map[string]string // songId -> 封面路径，持有 catalogMutex
+}
+
+func init() {
+	registerMode(&vocaloidMode{})
+}
+
+func (m *vocaloidMode) Name() string  { return "vocaloid" }
+func (m *vocaloidMode) Label() string { return "Vocaloid" }
+
+func (m *vocaloidMode) LoadCatalog() (func(), int, error) {
+	file, err := os.ReadFile(filepath.Join("vocaloid", "data", "songs.json"))
+	if err != nil {
+		return nil, 0, err
+	}
+	var songs []Song
+	if err := json.Unmarshal(file, &songs); err != nil {
+		return nil, 0, err
+	}
+	covers := make(map[string]string)
+	for _, s := range songs {
+		if s.Cover == "" {
+			continue
+		}
+		// 只取文件名，防止 cover 字段指向封面目录以外
+		path := filepath.Join("vocaloid", "cover", filepath.Base(s.Cover))
+		if _, ok := imageFormatByExt(path); !ok {
+			fmt.Printf("警告: 歌曲 %s 的封面 %s 不是 JPEG/PNG，已忽略\n", s.ID, s.Cover)
+			continue
+		}
+		covers[s.ID] = path
+	}
+	return func() { m.songs, m.covers = songs, covers }, len(songs), nil
+}
+
+func (m *vocaloidMode) CatalogSize() int { return len(m.songs) }
+
+func (m *vocaloidMode) BuildPool(n int, filter *songFilter) []Song {
+	catalogMutex.RLock()
+	songs := shuffledCopy(m.songs)
+	catalogMutex.RUnlock()
+	pool := songs[:0]
+	for _, s := range songs {
+		if n > 0 && len(pool) >= n {
+			break
+		}
+		s.Mode = m.Name()
+		if filter.Match(&s) {
+			pool = append(pool, s)
+		}
+	}
+	return pool
+}
+
+func (m *vocaloidMode) BuildCard(s Song) Card {
+	card := Card{
+		ID:               s.ID,
+		TitleOriginal:    s.TitleOriginal,
+		TitleTranslation: s.TitleTranslation,
+	}
+	catalogMutex.RLock()
+	_, hasCover := m.covers[s.ID]
+	catalogMutex.RUnlock()
+	if hasCover {
+		card.PictureUrl = fmt.Sprintf("/api/picture?mode=%s&id=%s", m.Name(), url.QueryEscape(s.ID))
+	}
+	return card
+}
+
+func (m *vocaloidMode) CheckAnswer(s Song, cardID string) bool {
+	return cardID == s.ID
+}
+
+func (m *vocaloidMode) AudioDir() string { return filepath.Join("vocaloid", "audio") }
+
+func (m *vocaloidMode) AudioAsset(s Song) (string, string) {
+	return findAudioSource(m.AudioDir(), s.ID, ".m4a")
+}
+
+func (m *vocaloidMode) CardImage(id string) (string, string, bool) {
+	catalogMutex.RLock()
+	path, ok := m.covers[id]
+	catalogMutex.RUnlock()
+	if !ok {
+		return "", "", false
+	}
+	format, _ := imageFormatByExt(path)
+	return path, format.ctype, true
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/gorilla/websocket"
+)
+
+// ==========================================
+// 房主管理：踢人、封禁、转让房主、房间设置与房主继任
+// ==========================================
+
+// handleOwnerAction 处理 kick_player / ban_player / transfer_owner 消息
+func handleOwnerAction(room *Room, player *Player, action, targetID string) {
+	room.Mutex.Lock()
+	defer room.Mutex.Unlock()
+
+	if room.OwnerID != player.ID {
+		sendOwnerError(player.Conn, "只有房主可以执行该操作。")
+		return
+	}
+	if targetID == player.ID {
+		sendOwnerError(player.Conn, "不能对自己执行该操作。")
+		return
+	}
+	target, inRoom := room.Players[targetID]
+
+	switch action {
+	case "kick_player":
+		if !inRoom {
+			sendOwnerError(player.Conn, "该玩家不在房间中。")
+			return
+		}
+		kickPlayerLocked(room, target, "你已被房主移出房间。")
+
+	case "ban_player":
+		room.Banned[targetID] = true
+		if inRoom {
+			postSystemMessageLocked(room, fmt.Sprintf("玩家 [%s] 被房主封禁", target.Name))
+			kickPlayerLocked(room, target, "你已被房主封禁，无法再加入该房间。")
+		}
+		fmt.Printf("房间 [%s] 封禁了玩家 ID [%s]\n", room.ID, targetID)
+
+	case "transfer_owner":
+		if !inRoom {
+			sendOwnerError(player.Conn, "该玩家不在房间中。")
+			return
+		}
+		if target.IsBot {
+			sendOwnerError(player.Conn, "不能把房主转让给电脑玩家。")
+			return
+		}
+		room.OwnerID = target.ID
+		// 新房主不需要准备
+		target.GameReady = false
+		postSystemMessageLocked(room, fmt.Sprintf("房主 [%s] 把房主转让给了 [%s]", player.Name, target.Name))
+		fmt.Printf("房间 [%s] 房主转让: [%s] -> [%s]\n", room.ID, player.Name, target.Name)
+		events.publish(evOwnerChanged, room.ID, map[string]interface{}{"playerId": target.ID, "name": target.Name, "from": player.Name})
+		broadcastRoomStateLocked(room)
+	}
+}
+
+// handleCardDisplay 处理 set_card_display：房主在开局前切换牌面显示方式
+func handleCardDisplay(room *Room, player *Player, value string) {
+	room.Mutex.Lock()
+	defer room.Mutex.Unlock()
+
+	if room.OwnerID != player.ID {
+		sendOwnerError(player.Conn, "只有房主可以执行该操作。")
+		return
+	}
+	if !validCardDisplay(value) {
+		sendOwnerError(player.Conn, "无效的牌面显示方式。")
+		return
+	}
+	if room.State != "waiting" {
+		sendOwnerError(player.Conn, "游戏进行中不能修改牌面显示方式。")
+		return
+	}
+	room.CardDisplay = value
+	fmt.Printf("房间 [%s] 牌面显示方式改为 %s\n", room.ID, value)
+	broadcastRoomStateLocked(room)
+}
+
+// handleAudioEffects 处理 set_audio_effects：房主在开局前调整题目音频特效
+func handleAudioEffects(room *Room, player *Player, raw interface{}) {
+	room.Mutex.Lock()
+	defer room.Mutex.Unlock()
+
+	if room.OwnerID != player.ID {
+		sendOwnerError(player.Conn, "只有房主可以执行该操作。")
+		return
+	}
+	effects, err := parseAudioEffects(raw)
+	if err != nil {
+		sendOwnerError(player.Conn, err.Error())
+		return
+	}
+	if room.State != "waiting" {
+		sendOwnerError(player.Conn, "游戏进行中不能修改音效。")
+		return
+	}
+	room.Effects = effects
+	label := effects.String()
+	if label == "" {
+		label = "无"
+	}
+	fmt.Printf("房间 [%s] 音效改为: %s\n", room.ID, label)
+	broadcastRoomStateLocked(room)
+}
+
+func sendOwnerError(conn *websocket.Conn, message string) {
+	errMsg := WsMessage{
+		Type:    "owner_action_error",
+		Payload: map[string]interface{}{"message": message},
+	}
+	eBytes, _ := json.Marshal(errMsg)
+	conn.WriteMessage(websocket.TextMessage, eBytes)
+}
+
+// nextOwner 选出在房间里待得最久的玩家作为继任房主，同时加入时按 ID 排序保证确定性
+// 持有 room.Mutex
+func nextOwner(room *Room) *Player {
+	var best *Player
+	for _, p := range room.Players {
+		if p.IsBot {
+			continue
+		}
+		if best == nil || p.JoinedAt.Before(best.JoinedAt) ||
+			(p.JoinedAt.Equal(best.JoinedAt) && p.ID < best.ID) {
+			best = p
+		}
+	}
+	return best
+}
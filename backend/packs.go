@@ -0,0 +1,779 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"math"
+	"math/rand"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// ==========================================
+// 自定义曲包
+// 玩家上传 zip (pack.json + 音频 + 可选封面图)，服务端校验、转码后存到 packs/{packId}/，
+// 建房时以 packId 选用；曲包作为一个普通的 Mode，和 Vocaloid 模式走同一套 Song/Card 流程
+//
+// pack.json 格式:
+//   {"name": "我的歌单", "author": "...", "songs": [
+//     {"id": "s1", "title_original": "...", "title_translation": "...",
+//      "duration": 180, "audio": "audio/s1.mp3", "image": "covers/s1.jpg"}]}
+// audio / image 为 zip 内的路径；duration 可省略，由 ffprobe 读取
+// ==========================================
+
+const packModePrefix = "pack:"
+
+var (
+	packsDir           = "packs"
+	maxPackBytes int64 = 200 << 20
+	// 曲包目录总占用上限，0 表示不限
+	maxPacksTotalBytes int64 = 10 << 30
+	// 曲包连续这么久没有房间使用就删除，0 表示永久保留
+	packTTL = 30 * 24 * time.Hour
+	// 只允许持有管理令牌的请求上传曲包
+	packUploadAdminOnly = false
+)
+
+const (
+	minPackSongs       = boardSize // 至少能摆满一局的牌
+	maxPackSongs       = 300
+	maxPackAudioBytes  = 50 << 20
+	maxPackImageBytes  = 2 << 20
+	maxPackImageSide   = 4096
+	maxPackTitleLen    = 200
+	maxPackSongTags    = 20
+	maxPackNameLen     = 64
+	minPackSongSeconds = 5
+	maxPackSongSeconds = 3600
+
+	// 每个 IP 每小时最多上传的曲包数
+	packUploadsPerHour = 10
+
+	// 清理过期曲包与曲包缓存的间隔
+	packSweepInterval = time.Hour
+	// 导入中途崩溃留下的临时目录超过这么久即删除
+	packStageMaxAge = time.Hour
+)
+
+var (
+	packIDPattern     = regexp.MustCompile(`^[0-9a-f]{12}$`)
+	packSongIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+	packAudioExts     = map[string]bool{".m4a": true, ".mp3": true, ".ogg": true, ".opus": true, ".flac": true, ".wav": true, ".aac": true}
+)
+
+// 同时进行的导入数，转码比较吃 CPU
+var packImportSlots = make(chan struct{}, 2)
+
+type packSong struct {
+	ID               string `json:"id"`
+	TitleOriginal    string `json:"title_original"`
+	TitleTranslation string `json:"title_translation"`
+	Duration         int    `json:"duration"`
+	Audio            string `json:"audio"`
+	Image            string `json:"image,omitempty"`
+	SongMeta
+}
+
+// packManifest 即 pack.json；存盘时 Audio/Image 改写为曲包目录内的相对路径
+type packManifest struct {
+	ID        string     `json:"id,omitempty"`
+	Name      string     `json:"name"`
+	Author    string     `json:"author,omitempty"`
+	CreatedAt string     `json:"createdAt,omitempty"`
+	Songs     []packSong `json:"songs"`
+}
+
+// packError 是上传内容本身的问题，返回 400
+type packError struct{ msg string }
+
+func (e *packError) Error() string { return e.msg }
+
+func packErrorf(format string, args ...interface{}) error {
+	return &packError{msg: fmt.Sprintf(format, args...)}
+}
+
+// ==========================================
+// 曲包模式
+// ==========================================
+
+type packMode struct {
+	manifest packManifest
+	dir      string
+	byID     map[string]*packSong
+}
+
+func (m *packMode) Name() string  { return packModePrefix + m.manifest.ID }
+func (m *packMode) Label() string { return fmt.Sprintf("曲包「%s」", m.manifest.Name) }
+
+// 曲包上传后不再变化，不参与题库重新加载
+func (m *packMode) LoadCatalog() (func(), int, error) {
+	return func() {}, len(m.manifest.Songs), nil
+}
+
+func (m *packMode) CatalogSize() int { return len(m.manifest.Songs) }
+
+func (m *packMode) BuildPool(n int, filter *songFilter) []Song {
+	pool := make([]Song, 0, len(m.manifest.Songs))
+	for _, ps := range m.manifest.Songs {
+		s := Song{
+			ID:               ps.ID,
+			TitleOriginal:    ps.TitleOriginal,
+			TitleTranslation: ps.TitleTranslation,
+			Duration:         ps.Duration,
+			Mode:             m.Name(),
+			SongMeta:         ps.SongMeta,
+		}
+		if filter.Match(&s) {
+			pool = append(pool, s)
+		}
+	}
+	rand.Shuffle(len(pool), func(i, j int) { pool[i], pool[j] = pool[j], pool[i] })
+	if n > 0 && len(pool) > n {
+		pool = pool[:n]
+	}
+	return pool
+}
+
+func (m *packMode) BuildCard(s Song) Card {
+	card := Card{ID: s.ID, TitleOriginal: s.TitleOriginal, TitleTranslation: s.TitleTranslation}
+	if ps := m.byID[s.ID]; ps != nil && ps.Image != "" {
+		card.PictureUrl = fmt.Sprintf("/api/picture?mode=%s&id=%s", m.Name(), s.ID)
+	}
+	return card
+}
+
+func (m *packMode) CheckAnswer(s Song, cardID string) bool {
+	return cardID == s.ID
+}
+
+func (m *packMode) AudioDir() string { return filepath.Join(m.dir, "audio") }
+
+func (m *packMode) AudioAsset(s Song) (string, string) {
+	return filepath.Join(m.AudioDir(), s.ID+".m4a"), "audio/mp4"
+}
+
+func (m *packMode) CardImage(id string) (string, string, bool) {
+	ps := m.byID[id]
+	if ps == nil || ps.Image == "" {
+		return "", "", false
+	}
+	return filepath.Join(m.dir, filepath.FromSlash(ps.Image)), mime.TypeByExtension(path.Ext(ps.Image)), true
+}
+
+// 已加载的曲包，曲包不可修改，读一次即可；没有房间使用的曲包由 sweepPacks 定期移出
+// lastUse 记录本进程内最近一次建房选用的时间，见 packClaimGrace
+var packCache = struct {
+	sync.Mutex
+	packs   map[string]*packMode
+	lastUse map[string]time.Time
+}{packs: make(map[string]*packMode), lastUse: make(map[string]time.Time)}
+
+// 选用曲包到房间出现在房间表之间有一小段空档，这段时间内不删除该曲包
+const packClaimGrace = time.Minute
+
+// loadPack 按 ID 读取曲包
+func loadPack(id string) (*packMode, error) {
+	if !packIDPattern.MatchString(id) {
+		return nil, fmt.Errorf("无效的曲包 ID")
+	}
+	packCache.Lock()
+	defer packCache.Unlock()
+	return loadPackLocked(id)
+}
+
+// 持有 packCache
+func loadPackLocked(id string) (*packMode, error) {
+	if m, ok := packCache.packs[id]; ok {
+		return m, nil
+	}
+	dir := filepath.Join(packsDir, id)
+	data, err := os.ReadFile(filepath.Join(dir, "pack.json"))
+	if err != nil {
+		return nil, err
+	}
+	var manifest packManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, err
+	}
+	m := &packMode{manifest: manifest, dir: dir, byID: make(map[string]*packSong, len(manifest.Songs))}
+	for i := range m.manifest.Songs {
+		m.byID[m.manifest.Songs[i].ID] = &m.manifest.Songs[i]
+	}
+	packCache.packs[id] = m
+	return m, nil
+}
+
+// usePack 为新房间选用曲包：确认曲包仍在，并记录最近使用时间 (曲包目录的 mtime)，过期清理以此为准
+// 与 deletePack / sweepPacks 在同一把锁内完成，选用之后的 packClaimGrace 内曲包不会被删掉
+func usePack(id string) error {
+	if !packIDPattern.MatchString(id) {
+		return fmt.Errorf("无效的曲包 ID")
+	}
+	packCache.Lock()
+	defer packCache.Unlock()
+	m, err := loadPackLocked(id)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	os.Chtimes(m.dir, now, now)
+	packCache.lastUse[id] = now
+	return nil
+}
+
+// packIDsOf 返回模式用到的曲包：曲包模式本身，或混合模式中的各个曲包
+func packIDsOf(m Mode) []string {
+	switch m := m.(type) {
+	case *packMode:
+		return []string{m.manifest.ID}
+	case *mixedMode:
+		return m.PackIDs()
+	}
+	return nil
+}
+
+// ==========================================
+// 曲包存储管理：总量配额、过期清理、管理员删除
+// ==========================================
+
+// 持有 packCache
+// packsInUseLocked 返回现存房间正在使用的曲包 ID 及房间数，刚被选用、房间尚未建好的曲包也算在内
+func packsInUseLocked() map[string]int {
+	globalMutex.Lock()
+	roomList := make([]*Room, 0, len(rooms))
+	for _, room := range rooms {
+		roomList = append(roomList, room)
+	}
+	globalMutex.Unlock()
+
+	// Mode 建房后不再修改，无需持有房间锁
+	used := make(map[string]int)
+	for _, room := range roomList {
+		for _, id := range packIDsOf(room.Mode) {
+			used[id]++
+		}
+	}
+	for id, t := range packCache.lastUse {
+		if time.Since(t) > packClaimGrace {
+			delete(packCache.lastUse, id)
+		} else if used[id] == 0 {
+			used[id] = 1
+		}
+	}
+	return used
+}
+
+// packsDiskUsage 统计曲包目录的总占用 (含导入中的临时目录)
+func packsDiskUsage() (int64, error) {
+	var total int64
+	err := filepath.WalkDir(packsDir, func(p string, d os.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if d.Type().IsRegular() {
+			if info, err := d.Info(); err == nil {
+				total += info.Size()
+			}
+		}
+		return nil
+	})
+	return total, err
+}
+
+// deletePack 删除曲包目录并移出缓存，曲包正被房间使用时拒绝
+func deletePack(id string) error {
+	if !packIDPattern.MatchString(id) {
+		return fmt.Errorf("无效的曲包 ID")
+	}
+	packCache.Lock()
+	defer packCache.Unlock()
+	used := packsInUseLocked()
+	dir := filepath.Join(packsDir, id)
+	if _, err := os.Stat(dir); err != nil {
+		return notFoundf("曲包 [%s] 不存在", id)
+	}
+	if n := used[id]; n > 0 {
+		return fmt.Errorf("曲包 [%s] 正被 %d 个房间使用，请先关闭这些房间", id, n)
+	}
+	delete(packCache.packs, id)
+	return os.RemoveAll(dir)
+}
+
+// sweepPacks 删除超过 packTTL 无人使用的曲包与残留的导入临时目录，
+// 并把没有房间使用的曲包移出 packCache
+func sweepPacks() {
+	packCache.Lock()
+	defer packCache.Unlock()
+	entries, err := os.ReadDir(packsDir)
+	if err != nil && !os.IsNotExist(err) {
+		fmt.Println("扫描曲包目录失败:", err)
+		return
+	}
+	used := packsInUseLocked()
+	for id := range packCache.packs {
+		if used[id] == 0 {
+			delete(packCache.packs, id)
+		}
+	}
+	removed := 0
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		idle := time.Since(info.ModTime())
+		dir := filepath.Join(packsDir, e.Name())
+		switch {
+		case strings.HasPrefix(e.Name(), ".import-"):
+			if idle > packStageMaxAge {
+				os.RemoveAll(dir)
+			}
+		case packIDPattern.MatchString(e.Name()):
+			if packTTL > 0 && idle > packTTL && used[e.Name()] == 0 {
+				if err := os.RemoveAll(dir); err != nil {
+					fmt.Printf("删除过期曲包 [%s] 失败: %v\n", e.Name(), err)
+					continue
+				}
+				removed++
+			}
+		}
+	}
+	if removed > 0 {
+		fmt.Printf("已删除 %d 个超过 %v 未使用的曲包\n", removed, packTTL)
+	}
+}
+
+// startPackJanitor 启动曲包定期清理
+func startPackJanitor() {
+	sweepPacks()
+	go func() {
+		for range time.Tick(packSweepInterval) {
+			sweepPacks()
+		}
+	}()
+}
+
+// ==========================================
+// 上传接口 POST /api/packs
+// 请求体为 zip 本身，或 multipart 表单中名为 pack 的文件
+// 查询接口 GET /api/packs/{packId}
+// ==========================================
+
+// packUploadCounter 按 IP 统计最近一小时的上传次数
+var packUploadCounter = struct {
+	sync.Mutex
+	windowStart time.Time
+	counts      map[string]int
+}{counts: make(map[string]int)}
+
+func allowPackUpload(ip string) bool {
+	if isRateLimitExempt(ip) {
+		return true
+	}
+	packUploadCounter.Lock()
+	defer packUploadCounter.Unlock()
+	if time.Since(packUploadCounter.windowStart) > time.Hour {
+		packUploadCounter.windowStart = time.Now()
+		packUploadCounter.counts = make(map[string]int)
+	}
+	if packUploadCounter.counts[ip] >= packUploadsPerHour {
+		return false
+	}
+	packUploadCounter.counts[ip]++
+	return true
+}
+
+func handlePackUpload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]interface{}{"ok": false, "message": "仅支持 POST"})
+		return
+	}
+	ip := clientIP(r)
+	if packUploadAdminOnly {
+		if _, err := authenticateAdmin(r); err != nil {
+			fmt.Printf("拒绝曲包上传: 来自 %s 的请求未携带有效管理令牌\n", ip)
+			writeJSON(w, http.StatusUnauthorized, map[string]interface{}{"ok": false, "message": "本服务器只允许管理员上传曲包"})
+			return
+		}
+	}
+	if !allowPackUpload(ip) {
+		writeJSON(w, http.StatusTooManyRequests, map[string]interface{}{"ok": false, "message": "上传过于频繁，请稍后再试。"})
+		return
+	}
+	select {
+	case packImportSlots <- struct{}{}:
+		defer func() { <-packImportSlots }()
+	default:
+		writeJSON(w, http.StatusServiceUnavailable, map[string]interface{}{"ok": false, "message": "服务器正在处理其它曲包，请稍后再试。"})
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxPackBytes)
+	var src io.Reader = r.Body
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/") {
+		f, _, err := r.FormFile("pack")
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]interface{}{"ok": false, "message": "缺少 pack 文件"})
+			return
+		}
+		defer f.Close()
+		src = f
+	}
+
+	tmp, err := os.CreateTemp("", "metagaruta-pack-*.zip")
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]interface{}{"ok": false, "message": "服务器内部错误"})
+		return
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	size, err := io.Copy(tmp, src)
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeJSON(w, http.StatusRequestEntityTooLarge, map[string]interface{}{"ok": false, "message": fmt.Sprintf("曲包超过 %d MB", maxPackBytes>>20)})
+			return
+		}
+		writeJSON(w, http.StatusBadRequest, map[string]interface{}{"ok": false, "message": "上传中断"})
+		return
+	}
+
+	if maxPacksTotalBytes > 0 {
+		usage, err := packsDiskUsage()
+		if err != nil {
+			fmt.Println("统计曲包目录占用失败:", err)
+			writeJSON(w, http.StatusInternalServerError, map[string]interface{}{"ok": false, "message": "服务器内部错误"})
+			return
+		}
+		if usage+size > maxPacksTotalBytes {
+			fmt.Printf("曲包存储已满 (%d / %d MB)，拒绝 IP [%s] 的上传\n", usage>>20, maxPacksTotalBytes>>20, ip)
+			writeJSON(w, http.StatusInsufficientStorage, map[string]interface{}{"ok": false, "message": "服务器曲包存储空间已满，请稍后再试。"})
+			return
+		}
+	}
+
+	start := time.Now()
+	manifest, err := importPack(tmp, size)
+	if err != nil {
+		var perr *packError
+		if errors.As(err, &perr) {
+			fmt.Printf("IP [%s] 上传的曲包未通过校验: %s\n", ip, perr.msg)
+			writeJSON(w, http.StatusBadRequest, map[string]interface{}{"ok": false, "message": perr.msg})
+			return
+		}
+		fmt.Println("导入曲包失败:", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]interface{}{"ok": false, "message": "导入曲包失败"})
+		return
+	}
+	// 转码后的体积与 zip 不同，且可能有并发导入，落盘后再按实际占用复核一次
+	if maxPacksTotalBytes > 0 {
+		if usage, err := packsDiskUsage(); err == nil && usage > maxPacksTotalBytes {
+			deletePack(manifest.ID)
+			fmt.Printf("曲包存储已满 (%d / %d MB)，已撤销 IP [%s] 上传的曲包 [%s]\n", usage>>20, maxPacksTotalBytes>>20, ip, manifest.ID)
+			writeJSON(w, http.StatusInsufficientStorage, map[string]interface{}{"ok": false, "message": "服务器曲包存储空间已满，请稍后再试。"})
+			return
+		}
+	}
+	fmt.Printf("IP [%s] 上传了曲包 [%s] %s，共 %d 首 (耗时 %v)\n", ip, manifest.ID, manifest.Name, len(manifest.Songs), time.Since(start).Round(time.Millisecond))
+	writeJSON(w, http.StatusCreated, map[string]interface{}{
+		"ok":     true,
+		"packId": manifest.ID,
+		"name":   manifest.Name,
+		"songs":  len(manifest.Songs),
+	})
+}
+
+func handlePackInfo(w http.ResponseWriter, r *http.Request) {
+	m, err := loadPack(strings.TrimPrefix(r.URL.Path, "/api/packs/"))
+	if err != nil {
+		writeJSON(w, http.StatusNotFound, map[string]interface{}{"ok": false, "message": "曲包不存在"})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"ok":        true,
+		"packId":    m.manifest.ID,
+		"name":      m.manifest.Name,
+		"author":    m.manifest.Author,
+		"createdAt": m.manifest.CreatedAt,
+		"songs":     len(m.manifest.Songs),
+	})
+}
+
+// ==========================================
+// 导入：校验 -> 解压 -> 转码 -> 原子落盘
+// ==========================================
+
+func importPack(archive io.ReaderAt, size int64) (*packManifest, error) {
+	zr, err := zip.NewReader(archive, size)
+	if err != nil {
+		return nil, packErrorf("不是有效的 zip 文件")
+	}
+	files := make(map[string]*zip.File, len(zr.File))
+	for _, f := range zr.File {
+		if !f.FileInfo().IsDir() {
+			files[path.Clean(strings.TrimPrefix(f.Name, "/"))] = f
+		}
+	}
+
+	mf := files["pack.json"]
+	if mf == nil {
+		return nil, packErrorf("zip 根目录缺少 pack.json")
+	}
+	data, err := readZipFile(mf, 1<<20)
+	if err != nil {
+		return nil, packErrorf("读取 pack.json 失败: %v", err)
+	}
+	var upload packManifest
+	if err := json.Unmarshal(data, &upload); err != nil {
+		return nil, packErrorf("pack.json 格式错误: %v", err)
+	}
+	if err := validatePackManifest(&upload, files); err != nil {
+		return nil, err
+	}
+
+	useFFmpeg := haveFFmpeg()
+	id := newInviteToken()[:12]
+	if err := os.MkdirAll(packsDir, 0o755); err != nil {
+		return nil, err
+	}
+	stage, err := os.MkdirTemp(packsDir, ".import-")
+	if err != nil {
+		return nil, err
+	}
+	committed := false
+	defer func() {
+		if !committed {
+			os.RemoveAll(stage)
+		}
+	}()
+	for _, sub := range []string{"audio", "images", "src"} {
+		if err := os.Mkdir(filepath.Join(stage, sub), 0o755); err != nil {
+			return nil, err
+		}
+	}
+
+	stored := packManifest{
+		ID:        id,
+		Name:      upload.Name,
+		Author:    upload.Author,
+		CreatedAt: time.Now().Format(time.RFC3339),
+		Songs:     make([]packSong, 0, len(upload.Songs)),
+	}
+	for _, s := range upload.Songs {
+		duration, err := importPackAudio(stage, s, files[s.Audio], useFFmpeg)
+		if err != nil {
+			return nil, err
+		}
+		s.Duration = duration
+		s.Audio = "audio/" + s.ID + ".m4a"
+		if s.Image != "" {
+			if s.Image, err = importPackImage(stage, s, files[s.Image]); err != nil {
+				return nil, err
+			}
+		}
+		stored.Songs = append(stored.Songs, s)
+	}
+	os.RemoveAll(filepath.Join(stage, "src"))
+
+	out, _ := json.MarshalIndent(stored, "", "  ")
+	if err := os.WriteFile(filepath.Join(stage, "pack.json"), out, 0o644); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(stage, filepath.Join(packsDir, id)); err != nil {
+		return nil, err
+	}
+	committed = true
+	return &stored, nil
+}
+
+// validatePackSongMeta 限制上传曲包中元数据的数量与长度
+func validatePackSongMeta(meta *SongMeta) error {
+	if len(meta.Vocalist) > maxPackSongTags || len(meta.Tags) > maxPackSongTags {
+		return fmt.Errorf("vocalist 与 tags 各不超过 %d 项", maxPackSongTags)
+	}
+	texts := append([]string{meta.Producer, meta.Game, meta.Album}, meta.Vocalist...)
+	for _, t := range append(texts, meta.Tags...) {
+		if utf8.RuneCountInString(t) > maxPackTitleLen {
+			return fmt.Errorf("元数据不超过 %d 字", maxPackTitleLen)
+		}
+	}
+	if meta.Year < 0 || meta.Year > 9999 {
+		return fmt.Errorf("year 无效")
+	}
+	return nil
+}
+
+func validatePackManifest(p *packManifest, files map[string]*zip.File) error {
+	p.Name = strings.TrimSpace(p.Name)
+	p.Author = strings.TrimSpace(p.Author)
+	if p.Name == "" || utf8.RuneCountInString(p.Name) > maxPackNameLen {
+		return packErrorf("曲包名称不能为空且不超过 %d 字", maxPackNameLen)
+	}
+	if utf8.RuneCountInString(p.Author) > maxPackNameLen {
+		return packErrorf("作者名不超过 %d 字", maxPackNameLen)
+	}
+	if len(p.Songs) < minPackSongs || len(p.Songs) > maxPackSongs {
+		return packErrorf("曲包需包含 %d 到 %d 首歌曲，当前 %d 首", minPackSongs, maxPackSongs, len(p.Songs))
+	}
+	seen := make(map[string]bool, len(p.Songs))
+	for i := range p.Songs {
+		s := &p.Songs[i]
+		s.TitleOriginal = strings.TrimSpace(s.TitleOriginal)
+		s.TitleTranslation = strings.TrimSpace(s.TitleTranslation)
+		if !packSongIDPattern.MatchString(s.ID) {
+			return packErrorf("第 %d 首: id 只能包含字母、数字、- 和 _", i+1)
+		}
+		if seen[s.ID] {
+			return packErrorf("歌曲 id 重复: %s", s.ID)
+		}
+		seen[s.ID] = true
+		if s.TitleOriginal == "" || utf8.RuneCountInString(s.TitleOriginal) > maxPackTitleLen ||
+			utf8.RuneCountInString(s.TitleTranslation) > maxPackTitleLen {
+			return packErrorf("歌曲 %s: 标题不能为空且不超过 %d 字", s.ID, maxPackTitleLen)
+		}
+		if s.TitleTranslation == "" {
+			s.TitleTranslation = s.TitleOriginal
+		}
+		if s.Duration != 0 && (s.Duration < minPackSongSeconds || s.Duration > maxPackSongSeconds) {
+			return packErrorf("歌曲 %s: 时长需在 %d 到 %d 秒之间", s.ID, minPackSongSeconds, maxPackSongSeconds)
+		}
+		s.Audio = path.Clean(s.Audio)
+		if files[s.Audio] == nil {
+			return packErrorf("歌曲 %s: zip 中找不到音频 %s", s.ID, s.Audio)
+		}
+		if !packAudioExts[strings.ToLower(path.Ext(s.Audio))] {
+			return packErrorf("歌曲 %s: 不支持的音频格式 %s", s.ID, path.Ext(s.Audio))
+		}
+		if err := validatePackSongMeta(&s.SongMeta); err != nil {
+			return packErrorf("歌曲 %s: %v", s.ID, err)
+		}
+		if s.Image != "" {
+			s.Image = path.Clean(s.Image)
+			if files[s.Image] == nil {
+				return packErrorf("歌曲 %s: zip 中找不到图片 %s", s.ID, s.Image)
+			}
+		}
+	}
+	return nil
+}
+
+// importPackAudio 解压并转码一首歌，返回最终采用的时长 (秒)
+func importPackAudio(stage string, s packSong, f *zip.File, useFFmpeg bool) (int, error) {
+	src := filepath.Join(stage, "src", s.ID+strings.ToLower(path.Ext(s.Audio)))
+	if err := extractZipFile(f, src, maxPackAudioBytes); err != nil {
+		return 0, packErrorf("歌曲 %s: %v", s.ID, err)
+	}
+	defer os.Remove(src)
+	dst := filepath.Join(stage, "audio", s.ID+".m4a")
+
+	if !useFFmpeg {
+		// 无法转码：只收 m4a，且必须在 pack.json 中写明时长
+		if path.Ext(src) != ".m4a" || !sniffM4A(src) {
+			return 0, packErrorf("歌曲 %s: 服务器暂不支持转码，请上传 m4a 音频", s.ID)
+		}
+		if s.Duration == 0 {
+			return 0, packErrorf("歌曲 %s: 缺少 duration", s.ID)
+		}
+		return s.Duration, os.Rename(src, dst)
+	}
+
+	probed, err := probeDuration(src)
+	if err != nil {
+		return 0, packErrorf("歌曲 %s: 无法识别的音频 (%v)", s.ID, err)
+	}
+	seconds := int(math.Floor(probed))
+	if seconds < minPackSongSeconds || seconds > maxPackSongSeconds {
+		return 0, packErrorf("歌曲 %s: 时长需在 %d 到 %d 秒之间", s.ID, minPackSongSeconds, maxPackSongSeconds)
+	}
+	// pack.json 中的时长不可信，超出实际长度时以实际为准
+	if s.Duration == 0 || s.Duration > seconds {
+		s.Duration = seconds
+	}
+	if err := transcodeToM4A(src, dst); err != nil {
+		return 0, packErrorf("歌曲 %s: 转码失败 (%v)", s.ID, err)
+	}
+	return s.Duration, nil
+}
+
+// importPackImage 校验封面图并存为 images/{songId}.{jpg|png}，返回曲包内的相对路径
+func importPackImage(stage string, s packSong, f *zip.File) (string, error) {
+	data, err := readZipFile(f, maxPackImageBytes)
+	if err != nil {
+		return "", packErrorf("歌曲 %s 的图片: %v", s.ID, err)
+	}
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return "", packErrorf("歌曲 %s 的图片: 只支持 JPEG 或 PNG", s.ID)
+	}
+	if cfg.Width > maxPackImageSide || cfg.Height > maxPackImageSide {
+		return "", packErrorf("歌曲 %s 的图片: 尺寸不能超过 %dx%d", s.ID, maxPackImageSide, maxPackImageSide)
+	}
+	ext := ".png"
+	if format == "jpeg" {
+		ext = ".jpg"
+	}
+	rel := "images/" + s.ID + ext
+	return rel, os.WriteFile(filepath.Join(stage, filepath.FromSlash(rel)), data, 0o644)
+}
+
+// readZipFile 读取 zip 中的文件，超过 limit 字节视为错误 (不信任 zip 头中声明的大小)
+func readZipFile(f *zip.File, limit int64) ([]byte, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	data, err := io.ReadAll(io.LimitReader(rc, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > limit {
+		return nil, fmt.Errorf("文件超过 %d MB", limit>>20)
+	}
+	return data, nil
+}
+
+func extractZipFile(f *zip.File, dst string, limit int64) error {
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	n, err := io.Copy(out, io.LimitReader(rc, limit+1))
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	if n > limit {
+		return fmt.Errorf("文件超过 %d MB", limit>>20)
+	}
+	return nil
+}
@@ -0,0 +1,454 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"sync"
+)
+
+// ==========================================
+// 单人练习模式
+// 一名玩家按自己的节奏过一遍题库：没有准备/倒计时/限时，答错可以无限重试，
+// 随时可以重播片段，每局结束后展示正确的牌与原名/译名，由玩家决定何时进入下一首
+// 服务端按玩家记录每首歌的错误次数，出题时加大错题的权重，让薄弱的歌曲更常出现
+// ==========================================
+
+// 练习棋盘最多的牌数，与正式对局一致
+const practiceBoardSize = 16
+
+// 目标歌曲放上棋盘的概率；其余回合练习"没有这首歌"的判断
+const practiceOnBoardRate = 0.8
+
+// 最多记录多少名玩家的错题，超出后随机淘汰
+const maxPracticePlayers = 10000
+
+// practiceSession 单人练习的进度，每次开局时重置
+type practiceSession struct {
+	Catalog []Song          // 本次练习的完整题库
+	Recent  []string        // 最近出过的歌曲 ID，避免短时间内重复
+	Cleared map[string]bool // 本次练习中一次答对过的歌曲
+
+	// 当前一局
+	StartTime    int
+	PlayDuration int
+	Attempts     int  // 作答次数 (含答错)
+	Missed       bool // 本局是否已记过错题
+	Replays      int
+
+	Rounds   int
+	FirstTry int
+	Finished bool
+}
+
+// practiceMistakes 记录每名玩家每首歌的错误次数，进程存续期间有效
+var practiceMistakes = struct {
+	sync.Mutex
+	byPlayer map[string]map[string]int // playerID -> "模式:歌曲ID" -> 错误次数
+}{byPlayer: make(map[string]map[string]int)}
+
+func mistakeKey(room *Room, songID string) string {
+	return room.GameMode + ":" + songID
+}
+
+// addMistake 调整玩家某首歌的错误次数，减到 0 时删除
+func addMistake(playerID, key string, delta int) int {
+	practiceMistakes.Lock()
+	defer practiceMistakes.Unlock()
+	m := practiceMistakes.byPlayer[playerID]
+	if m == nil {
+		if delta <= 0 {
+			return 0
+		}
+		if len(practiceMistakes.byPlayer) >= maxPracticePlayers {
+			for id := range practiceMistakes.byPlayer {
+				delete(practiceMistakes.byPlayer, id)
+				break
+			}
+		}
+		m = make(map[string]int)
+		practiceMistakes.byPlayer[playerID] = m
+	}
+	n := m[key] + delta
+	if n <= 0 {
+		delete(m, key)
+		return 0
+	}
+	m[key] = n
+	return n
+}
+
+// playerMistakes 返回玩家在当前题库中的错题表副本
+func playerMistakes(room *Room, playerID string) map[string]int {
+	practiceMistakes.Lock()
+	defer practiceMistakes.Unlock()
+	prefix := room.GameMode + ":"
+	out := make(map[string]int)
+	for key, n := range practiceMistakes.byPlayer[playerID] {
+		if len(key) > len(prefix) && key[:len(prefix)] == prefix {
+			out[key[len(prefix):]] = n
+		}
+	}
+	return out
+}
+
+// 持有 room.Mutex
+// initPracticeGame 以整个题库 (或筛选后的部分) 作为练习范围
+func initPracticeGame(room *Room) {
+	catalog := room.Mode.BuildPool(0, room.Filter)
+	if room.AllTracks {
+		catalog = expandTracks(room.Mode, catalog, room.Filter)
+	}
+	room.Practice = &practiceSession{
+		Catalog: catalog,
+		Cleared: make(map[string]bool),
+	}
+	room.SongPool = catalog
+	room.BoardCards = []Card{}
+	fmt.Printf("房间 [%s] 练习模式初始化完成，题库共 %d 首\n", room.ID, len(catalog))
+}
+
+// 持有 room.Mutex
+// pickPracticeSong 按权重抽取下一首：本次没答对过的歌曲权重更高，
+// 每记一次错再加权，最近出过的歌曲暂不重复
+func pickPracticeSong(room *Room, playerID string) int {
+	s := room.Practice
+	mistakes := playerMistakes(room, playerID)
+	recent := make(map[string]bool, len(s.Recent))
+	for _, id := range s.Recent {
+		recent[id] = true
+	}
+
+	weights := make([]float64, len(s.Catalog))
+	total := 0.0
+	for i, song := range s.Catalog {
+		if recent[song.ID] {
+			continue
+		}
+		w := 1.0
+		if !s.Cleared[song.ID] {
+			w = 3
+		}
+		w += 4 * float64(mistakes[song.ID])
+		weights[i] = w
+		total += w
+	}
+	if total == 0 {
+		return rand.Intn(len(s.Catalog))
+	}
+	r := rand.Float64() * total
+	for i, w := range weights {
+		if r < w {
+			return i
+		}
+		r -= w
+	}
+	return len(weights) - 1
+}
+
+// 持有 room.Mutex
+// startPracticeRoundLocked 出下一题：抽歌、重新发牌，并通知客户端加载音频
+// 不挂任何定时器，玩家加载完毕后直接播放
+func startPracticeRoundLocked(room *Room) {
+	s := room.Practice
+	player := room.Players[room.OwnerID]
+	if player == nil || len(s.Catalog) == 0 {
+		return
+	}
+	player.HasAnswered = false
+	player.IsReady = false
+
+	idx := pickPracticeSong(room, player.ID)
+	target := s.Catalog[idx]
+	room.CurrentSong = &target
+	room.CurrentSongIndex = idx
+
+	s.Recent = append(s.Recent, target.ID)
+	if window := min(5, len(s.Catalog)/2); len(s.Recent) > window {
+		s.Recent = s.Recent[len(s.Recent)-window:]
+	}
+
+	// 发牌：题库不足一整副时全部上场，否则目标歌曲按概率上场
+	// 多曲目练习时同一张牌对应多道题，按牌 ID 去重，目标牌不上场时也不能混进来
+	targetCard := roomCard(room, target)
+	dealt := map[string]bool{targetCard.ID: true}
+	cardCount := 1
+	for _, song := range s.Catalog {
+		if id := room.Mode.BuildCard(song).ID; !dealt[id] {
+			dealt[id] = true
+			cardCount++
+		}
+	}
+	size := min(practiceBoardSize, cardCount)
+	onBoard := size == cardCount || rand.Float64() < practiceOnBoardRate
+	room.BoardCards = make([]Card, 0, size)
+	if onBoard {
+		room.BoardCards = append(room.BoardCards, targetCard)
+	}
+	dealt = map[string]bool{targetCard.ID: true}
+	for _, i := range rand.Perm(len(s.Catalog)) {
+		if len(room.BoardCards) >= size {
+			break
+		}
+		card := roomCard(room, s.Catalog[i])
+		if !dealt[card.ID] {
+			dealt[card.ID] = true
+			room.BoardCards = append(room.BoardCards, card)
+		}
+	}
+	rand.Shuffle(len(room.BoardCards), func(i, j int) {
+		room.BoardCards[i], room.BoardCards[j] = room.BoardCards[j], room.BoardCards[i]
+	})
+
+	s.StartTime, s.PlayDuration = clipWindow(target)
+	room.RoundAudio = newRoundAudio(room.CurrentRound, target, s.StartTime, s.PlayDuration, room.Effects)
+	s.Attempts = 0
+	s.Missed = false
+	s.Replays = 0
+	room.RoundState = "preparing"
+
+	fmt.Printf("房间 [%s] 练习第 %d 题，播放时长: %d 秒\n", room.ID, room.CurrentRound, s.PlayDuration)
+	started := map[string]interface{}{
+		"round":        room.CurrentRound,
+		"playDuration": s.PlayDuration,
+		"practice":     true,
+	}
+	if exposeRoundAnswers {
+		started["songId"] = target.ID
+	}
+	events.publish(evRoundStarted, room.ID, started)
+
+	prepMsg := WsMessage{
+		Type: "prepare_round",
+		Payload: map[string]interface{}{
+			"round":        room.CurrentRound,
+			"startTime":    room.RoundAudio.relative(s.StartTime),
+			"playDuration": s.PlayDuration,
+			"cards":        room.BoardCards,
+			"practice":     true,
+		},
+	}
+	msgBytes, _ := json.Marshal(prepMsg)
+	player.send(msgBytes)
+}
+
+// 持有 room.Mutex
+func startPracticePlaybackLocked(room *Room) {
+	room.RoundState = "playing"
+	playMsg := WsMessage{Type: "play_round", Payload: map[string]interface{}{}}
+	msgBytes, _ := json.Marshal(playMsg)
+	for _, p := range room.Players {
+		p.send(msgBytes)
+	}
+}
+
+// 持有 room.Mutex
+// practiceAnswerLocked 处理练习中的一次作答，cardID 为空表示"没有这首歌"
+// 答错只提示重试，本局第一次答错时记入错题
+func practiceAnswerLocked(room *Room, player *Player, cardID string) {
+	s := room.Practice
+	s.Attempts++
+	onBoard := isSongOnBoard(room)
+	correct := (cardID != "" && onBoard && room.Mode.CheckAnswer(*room.CurrentSong, cardID)) || (cardID == "" && !onBoard)
+
+	evType, data := evBuzz, map[string]interface{}{
+		"playerId": player.ID,
+		"name":     player.Name,
+		"round":    room.CurrentRound,
+		"correct":  correct,
+		"practice": true,
+	}
+	if cardID == "" {
+		evType = evNoSong
+	} else {
+		data["cardId"] = cardID
+	}
+	events.publish(evType, room.ID, data)
+
+	if correct {
+		endPracticeRoundLocked(room, player, true)
+		return
+	}
+	if !s.Missed {
+		s.Missed = true
+		addMistake(player.ID, mistakeKey(room, room.CurrentSong.ID), 1)
+	}
+	wrongMsg := WsMessage{
+		Type: "wrong_answer",
+		Payload: map[string]interface{}{
+			"retry":    true,
+			"attempts": s.Attempts,
+			"cardId":   cardID,
+		},
+	}
+	msgBytes, _ := json.Marshal(wrongMsg)
+	player.send(msgBytes)
+}
+
+// 持有 room.Mutex
+// endPracticeRoundLocked 结束本题并公布答案；一次答对的歌曲错误次数减一
+func endPracticeRoundLocked(room *Room, player *Player, solved bool) {
+	s := room.Practice
+	song := room.CurrentSong
+	room.RoundState = "ended"
+	player.HasAnswered = true
+	s.Rounds++
+
+	key := mistakeKey(room, song.ID)
+	var reason string
+	var mistakes int
+	switch {
+	case solved && !s.Missed:
+		s.FirstTry++
+		s.Cleared[song.ID] = true
+		player.Score += 10
+		mistakes = addMistake(player.ID, key, -1)
+		reason = "一次答对！(+10分)"
+	case solved:
+		player.Score += 5
+		mistakes = addMistake(player.ID, key, 0)
+		reason = fmt.Sprintf("第 %d 次尝试答对 (+5分)", s.Attempts)
+	default:
+		if !s.Missed {
+			s.Missed = true
+			mistakes = addMistake(player.ID, key, 1)
+		} else {
+			mistakes = addMistake(player.ID, key, 0)
+		}
+		reason = "已查看答案，这首歌会更常出现。"
+	}
+
+	onBoard := isSongOnBoard(room)
+	answer := room.Mode.BuildCard(*song)
+	answer.TitleOriginal = song.TitleOriginal
+	answer.TitleTranslation = song.TitleTranslation
+
+	fmt.Printf("房间 [%s] 练习第 %d 题结束。原因: %s\n", room.ID, room.CurrentRound, reason)
+	events.publish(evRoundEnded, room.ID, map[string]interface{}{
+		"round":    room.CurrentRound,
+		"reason":   reason,
+		"songId":   song.ID,
+		"answer":   song.TitleOriginal,
+		"practice": true,
+	})
+	postSystemMessageLocked(room, fmt.Sprintf("第 %d 题: %s", room.CurrentRound, reason))
+	if song.TitleTranslation != "" && song.TitleTranslation != song.TitleOriginal {
+		postSystemMessageLocked(room, fmt.Sprintf("正确答案是: %s / %s", song.TitleOriginal, song.TitleTranslation))
+	} else {
+		postSystemMessageLocked(room, fmt.Sprintf("正确答案是: %s", song.TitleOriginal))
+	}
+
+	endMsg := WsMessage{
+		Type: "round_end",
+		Payload: map[string]interface{}{
+			"reason":      reason,
+			"correctSong": song.TitleOriginal,
+			"cards":       room.BoardCards,
+			"showAnswer":  true,
+			"practice":    true,
+			"answer":      answer,
+			"onBoard":     onBoard,
+			"attempts":    s.Attempts,
+			"replays":     s.Replays,
+			"mistakes":    mistakes,
+			"progress":    practiceProgressLocked(room, player.ID),
+		},
+	}
+	msgBytes, _ := json.Marshal(endMsg)
+	player.send(msgBytes)
+	broadcastRoomStateLocked(room)
+}
+
+// 持有 room.Mutex
+func practiceProgressLocked(room *Room, playerID string) map[string]interface{} {
+	s := room.Practice
+	mistakes := playerMistakes(room, playerID)
+	weak := 0
+	for _, song := range s.Catalog {
+		if mistakes[song.ID] > 0 {
+			weak++
+		}
+	}
+	return map[string]interface{}{
+		"rounds":   s.Rounds,
+		"firstTry": s.FirstTry,
+		"cleared":  len(s.Cleared),
+		"total":    len(s.Catalog),
+		"weak":     weak,
+	}
+}
+
+// 持有 room.Mutex
+// practiceFinished 题库里每首歌都一次答对过且没有遗留错题时，练习完成
+func practiceFinished(room *Room, playerID string) bool {
+	s := room.Practice
+	if len(s.Cleared) < len(s.Catalog) {
+		return false
+	}
+	mistakes := playerMistakes(room, playerID)
+	for _, song := range s.Catalog {
+		if mistakes[song.ID] > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// handlePracticeCommand 处理练习房间的 practice_replay / practice_reveal / practice_next
+func handlePracticeCommand(room *Room, player *Player, action string) {
+	room.Mutex.Lock()
+	defer room.Mutex.Unlock()
+
+	s := room.Practice
+	if s == nil || room.State != "playing" || s.Finished || room.OwnerID != player.ID {
+		return
+	}
+
+	switch action {
+	case "practice_replay":
+		// 播放中或公布答案后都可以重听；客户端已缓存音频，只需回到片段开头
+		if room.RoundState != "playing" && room.RoundState != "ended" {
+			return
+		}
+		s.Replays++
+		replayMsg := WsMessage{
+			Type: "replay_clip",
+			Payload: map[string]interface{}{
+				"startTime":    room.RoundAudio.relative(s.StartTime),
+				"playDuration": s.PlayDuration,
+				"replays":      s.Replays,
+			},
+		}
+		msgBytes, _ := json.Marshal(replayMsg)
+		player.send(msgBytes)
+
+	case "practice_reveal":
+		if room.RoundState != "playing" {
+			return
+		}
+		endPracticeRoundLocked(room, player, false)
+
+	case "practice_next":
+		if room.RoundState != "ended" {
+			return
+		}
+		if practiceFinished(room, player.ID) {
+			s.Finished = true
+			fmt.Printf("房间 [%s] 练习完成，共 %d 题\n", room.ID, s.Rounds)
+			pList := []Player{*player}
+			overMsg := WsMessage{
+				Type: "game_over",
+				Payload: map[string]interface{}{
+					"players":  pList,
+					"practice": practiceProgressLocked(room, player.ID),
+				},
+			}
+			msgBytes, _ := json.Marshal(overMsg)
+			player.send(msgBytes)
+			events.publish(evGameOver, room.ID, map[string]interface{}{"scores": eventScores(pList), "practice": true})
+			return
+		}
+		room.CurrentRound++
+		startPracticeRoundLocked(room)
+	}
+}
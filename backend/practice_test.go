@@ -0,0 +1,92 @@
+package main
+
+import "testing"
+
+// newTestPracticeRoom 构造一个只有练习进度的房间，错题表按玩家隔离
+func newTestPracticeRoom(t *testing.T, playerID string, ids ...string) *Room {
+	t.Helper()
+	room := &Room{ID: "PRACT", GameMode: "vocaloid"}
+	room.Practice = &practiceSession{Cleared: make(map[string]bool)}
+	for _, id := range ids {
+		room.Practice.Catalog = append(room.Practice.Catalog, Song{ID: id})
+	}
+	t.Cleanup(func() {
+		practiceMistakes.Lock()
+		delete(practiceMistakes.byPlayer, playerID)
+		practiceMistakes.Unlock()
+	})
+	return room
+}
+
+// countPicks 多次抽题，返回每首歌被抽中的次数
+func countPicks(room *Room, playerID string, n int) map[string]int {
+	counts := make(map[string]int)
+	for i := 0; i < n; i++ {
+		counts[room.Practice.Catalog[pickPracticeSong(room, playerID)].ID]++
+	}
+	return counts
+}
+
+func TestPickPracticeSongWeights(t *testing.T) {
+	const player = "practice-weights"
+	room := newTestPracticeRoom(t, player, "cleared", "fresh", "missed")
+	room.Practice.Cleared["cleared"] = true
+	addMistake(player, mistakeKey(room, "missed"), 2)
+
+	// 权重: 答对过 1，没答对过 3，每记一次错再加 4 → 1 : 3 : 11
+	const draws = 30000
+	counts := countPicks(room, player, draws)
+	want := map[string]float64{"cleared": 1.0 / 15, "fresh": 3.0 / 15, "missed": 11.0 / 15}
+	for id, p := range want {
+		got := float64(counts[id]) / draws
+		if got < p*0.85 || got > p*1.15 {
+			t.Errorf("%s 被抽中的比例 = %.3f, 期望约 %.3f", id, got, p)
+		}
+	}
+}
+
+func TestPickPracticeSongSkipsRecent(t *testing.T) {
+	const player = "practice-recent"
+	room := newTestPracticeRoom(t, player, "a", "b", "c")
+	addMistake(player, mistakeKey(room, "a"), 5)
+	room.Practice.Recent = []string{"a", "b"}
+
+	counts := countPicks(room, player, 200)
+	if counts["c"] != 200 {
+		t.Fatalf("最近出过的歌曲不应再被抽中: %v", counts)
+	}
+
+	// 全部都在最近列表里时退回均匀抽取，而不是卡住
+	room.Practice.Recent = []string{"a", "b", "c"}
+	counts = countPicks(room, player, 300)
+	for _, id := range []string{"a", "b", "c"} {
+		if counts[id] == 0 {
+			t.Fatalf("全部最近出过时应均匀抽取, %s 一次也没抽到: %v", id, counts)
+		}
+	}
+}
+
+func TestPracticeMistakesPerMode(t *testing.T) {
+	const player = "practice-modes"
+	room := newTestPracticeRoom(t, player, "s1")
+	other := &Room{GameMode: "touhou"}
+
+	if n := addMistake(player, mistakeKey(room, "s1"), 2); n != 2 {
+		t.Fatalf("错误次数 = %d, 期望 2", n)
+	}
+	addMistake(player, mistakeKey(other, "s1"), 1)
+	if m := playerMistakes(room, player); len(m) != 1 || m["s1"] != 2 {
+		t.Fatalf("vocaloid 错题表 = %v, 期望只含本模式的 s1:2", m)
+	}
+	if m := playerMistakes(other, player); m["s1"] != 1 {
+		t.Fatalf("touhou 错题表 = %v, 期望 s1:1", m)
+	}
+
+	// 减到 0 时删除记录，不会出现负数
+	if n := addMistake(player, mistakeKey(room, "s1"), -5); n != 0 {
+		t.Fatalf("减为负数后 = %d, 期望 0", n)
+	}
+	if m := playerMistakes(room, player); len(m) != 0 {
+		t.Fatalf("清零后错题表 = %v, 期望为空", m)
+	}
+}
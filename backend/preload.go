@@ -0,0 +1,172 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	crand "crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strconv"
+)
+
+// ==========================================
+// 下一局音频预取
+// 本局开始播放 (play_round) 时服务端就选好下一局的题目与片段，用 preload_round 通知客户端
+// 趁本局进行时下载 /api/audio/preload 返回的加密音频 (AES-256-GCM，每局随机密钥)；
+// 密钥随下一局的 play_round 下发，在此之前拿到文件也无从得知是哪首歌
+// 明文为 4 字节大端长度 + 音频 + 补零到 preloadPadding 的整数倍，避免凭文件大小认出歌曲
+// 预选的题目在下一局开始前被移出题目池 (本局答对的正好是它) 时重新抽题，按原流程下发音频
+// 练习模式按作答情况选下一题，不做预取；不能裁剪片段时 (没有 ffmpeg 或 -audio-clip=false)
+// 下发的是整首，补零也掩盖不了各曲长度的差异，同样不做预取
+// ==========================================
+
+const preloadPadding = 64 << 10
+
+// roundPlan 是预先选好的一局
+type roundPlan struct {
+	Round        int
+	PoolSong     Song // 题目池中被选中的条目
+	Song         Song // 实际播放的曲目，开启 allTracks 时可能与 PoolSong 不同
+	StartTime    int
+	PlayDuration int
+	Audio        *roundAudio // 下一局开始后即成为 room.RoundAudio，预取与正常请求共用同一份缓存
+	Key          []byte
+	Nonce        []byte
+}
+
+// 持有 room.Mutex
+// planNextRoundLocked 为下一局选题并通知客户端预取
+func planNextRoundLocked(room *Room) {
+	room.NextRound = nil
+	if room.Practice != nil || len(room.SongPool) == 0 {
+		return
+	}
+
+	plan := &roundPlan{
+		Round:    room.CurrentRound + 1,
+		PoolSong: room.SongPool[rand.Intn(len(room.SongPool))],
+		Key:      make([]byte, 32),
+		Nonce:    make([]byte, 12),
+	}
+	if _, err := crand.Read(plan.Key); err != nil {
+		return
+	}
+	if _, err := crand.Read(plan.Nonce); err != nil {
+		return
+	}
+	plan.Song = pickTrackLocked(room, plan.PoolSong)
+	plan.StartTime, plan.PlayDuration = clipWindow(plan.Song)
+	plan.Audio = newRoundAudio(plan.Round, plan.Song, plan.StartTime, plan.PlayDuration, room.Effects)
+	if plan.Audio.duration == 0 {
+		return
+	}
+	room.NextRound = plan
+
+	msg := WsMessage{
+		Type: "preload_round",
+		Payload: map[string]interface{}{
+			"round": plan.Round,
+			"url":   fmt.Sprintf("/api/audio/preload?roomId=%s&round=%d", room.ID, plan.Round),
+		},
+	}
+	msgBytes, _ := json.Marshal(msg)
+	for _, p := range room.Players {
+		p.send(msgBytes)
+	}
+}
+
+// 持有 room.Mutex
+// takeRoundPlanLocked 取出为本局预选的题目及其在题目池中的下标；没有可用的预选时返回 nil
+func takeRoundPlanLocked(room *Room) (*roundPlan, int) {
+	plan := room.NextRound
+	room.NextRound = nil
+	if plan == nil || plan.Round != room.CurrentRound {
+		return nil, -1
+	}
+	key := trackKey(plan.PoolSong)
+	for i, s := range room.SongPool {
+		if trackKey(s) == key {
+			return plan, i
+		}
+	}
+	return nil, -1
+}
+
+// keyPayload 返回 play_round 中下发的解密参数
+func (plan *roundPlan) keyPayload() map[string]interface{} {
+	return map[string]interface{}{
+		"key":   base64.StdEncoding.EncodeToString(plan.Key),
+		"nonce": base64.StdEncoding.EncodeToString(plan.Nonce),
+	}
+}
+
+// seal 按上面的格式补齐并加密音频
+func (plan *roundPlan) seal(audio []byte) ([]byte, error) {
+	block, err := aes.NewCipher(plan.Key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	size := 4 + len(audio)
+	size = (size + preloadPadding - 1) / preloadPadding * preloadPadding
+	plain := make([]byte, size)
+	binary.BigEndian.PutUint32(plain, uint32(len(audio)))
+	copy(plain[4:], audio)
+	return gcm.Seal(nil, plan.Nonce, plain, nil), nil
+}
+
+// 处理下一局音频的预取请求
+func handleAudioPreload(w http.ResponseWriter, r *http.Request) {
+	roomID := normalizeRoomID(r.URL.Query().Get("roomId"))
+
+	globalMutex.Lock()
+	room, exists := rooms[roomID]
+	globalMutex.Unlock()
+
+	if !exists {
+		if _, addr, ok := cluster.locateRoom(roomID); ok {
+			http.Redirect(w, r, nodeHTTPBase(addr)+r.URL.RequestURI(), http.StatusTemporaryRedirect)
+			return
+		}
+		http.Error(w, "房间不存在", http.StatusNotFound)
+		return
+	}
+
+	room.Mutex.Lock()
+	plan := room.NextRound
+	mode := room.Mode
+	room.Mutex.Unlock()
+	if plan == nil || r.URL.Query().Get("round") != strconv.Itoa(plan.Round) {
+		http.Error(w, "没有可预取的音频", http.StatusNotFound)
+		return
+	}
+
+	audio, ctype, err := plan.Audio.load(r, mode, true)
+	if err != nil {
+		fmt.Printf("严重错误: 准备第 %d 局音频失败 (%s): %v\n", plan.Round, plan.Song.ID, err)
+		http.Error(w, "音频文件不存在", http.StatusNotFound)
+		return
+	}
+	sealed, err := plan.seal(audio)
+	if err != nil {
+		http.Error(w, "音频加密失败", http.StatusInternalServerError)
+		return
+	}
+
+	fmt.Printf("房间 [%s] 预取第 %d 局音频 (%s, %d 字节)\n", room.ID, plan.Round, ctype, len(sealed))
+
+	h := w.Header()
+	h.Set("Cache-Control", "no-store")
+	h.Set("Content-Type", "application/octet-stream")
+	// 解密后的音频格式，只暴露编码，不涉及歌曲
+	h.Set("X-Audio-Type", ctype)
+	h.Set("Content-Length", strconv.Itoa(len(sealed)))
+	w.Write(sealed)
+}
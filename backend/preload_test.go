@@ -0,0 +1,230 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// setTestFFmpeg 切换 ffmpeg 是否可用；可用时指向一个什么也不做的脚本，只用于 haveFFmpeg 的判断
+func setTestFFmpeg(t *testing.T, available bool) {
+	t.Helper()
+	oldFFmpeg, oldFFprobe := ffmpegPath, ffprobePath
+	t.Cleanup(func() { ffmpegPath, ffprobePath = oldFFmpeg, oldFFprobe })
+
+	dir := t.TempDir()
+	ffmpegPath = filepath.Join(dir, "ffmpeg")
+	ffprobePath = filepath.Join(dir, "ffprobe")
+	if !available {
+		return
+	}
+	for _, p := range []string{ffmpegPath, ffprobePath} {
+		if err := os.WriteFile(p, []byte("#!/bin/sh\nexit 1\n"), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// setTestAudioClip 临时修改 -audio-clip
+func setTestAudioClip(t *testing.T, enabled bool) {
+	t.Helper()
+	old := audioClipEnabled
+	audioClipEnabled = enabled
+	t.Cleanup(func() { audioClipEnabled = old })
+}
+
+// newTestAudioMode 构造一个音频放在临时目录的曲包模式，不放进 packCache
+func newTestAudioMode(t *testing.T, n int) (*packMode, []Song) {
+	t.Helper()
+	m := &packMode{
+		manifest: packManifest{ID: "feedfacecafe", Name: "测试曲包"},
+		dir:      t.TempDir(),
+		byID:     make(map[string]*packSong),
+	}
+	if err := os.MkdirAll(m.AudioDir(), 0755); err != nil {
+		t.Fatal(err)
+	}
+	var songs []Song
+	for i := 0; i < n; i++ {
+		id := fmt.Sprintf("s%d", i)
+		m.manifest.Songs = append(m.manifest.Songs, packSong{ID: id, TitleOriginal: "曲 " + id, Duration: 120})
+		songs = append(songs, Song{ID: id, TitleOriginal: "曲 " + id, Duration: 120, Mode: packModePrefix + m.manifest.ID})
+	}
+	for i := range m.manifest.Songs {
+		m.byID[m.manifest.Songs[i].ID] = &m.manifest.Songs[i]
+	}
+	return m, songs
+}
+
+// openSealed 按客户端的做法解密并去掉长度前缀与补零
+func openSealed(t *testing.T, key, nonce, sealed []byte) (plain []byte, audio []byte) {
+	t.Helper()
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain, err = gcm.Open(nil, nonce, sealed, nil)
+	if err != nil {
+		t.Fatalf("解密失败: %v", err)
+	}
+	n := binary.BigEndian.Uint32(plain)
+	if int(n) > len(plain)-4 {
+		t.Fatalf("长度前缀 %d 超出明文长度 %d", n, len(plain))
+	}
+	return plain, plain[4 : 4+n]
+}
+
+func TestRoundPlanSealRoundTrip(t *testing.T) {
+	plan := &roundPlan{Key: bytes.Repeat([]byte{7}, 32), Nonce: bytes.Repeat([]byte{9}, 12)}
+
+	cases := []struct {
+		size  int
+		plain int // 补零后的明文长度
+	}{
+		{0, preloadPadding},
+		{1, preloadPadding},
+		{preloadPadding - 4, preloadPadding},
+		{preloadPadding - 3, 2 * preloadPadding},
+		{3*preloadPadding + 100, 4 * preloadPadding},
+	}
+	for _, c := range cases {
+		audio := make([]byte, c.size)
+		for i := range audio {
+			audio[i] = byte(i*31 + 1)
+		}
+		sealed, err := plan.seal(audio)
+		if err != nil {
+			t.Fatal(err)
+		}
+		plain, got := openSealed(t, plan.Key, plan.Nonce, sealed)
+		if len(plain) != c.plain {
+			t.Errorf("%d 字节音频: 明文 %d 字节, 期望补齐到 %d", c.size, len(plain), c.plain)
+		}
+		if !bytes.Equal(got, audio) {
+			t.Errorf("%d 字节音频: 解密后内容不一致", c.size)
+		}
+		for i, b := range plain[4+c.size:] {
+			if b != 0 {
+				t.Fatalf("%d 字节音频: 第 %d 个补齐字节为 %d, 期望 0", c.size, i, b)
+			}
+		}
+	}
+
+	// 同一补齐区间内的音频加密后一样长，无法凭大小区分
+	a, _ := plan.seal(make([]byte, 10))
+	b, _ := plan.seal(make([]byte, preloadPadding-4))
+	if len(a) != len(b) {
+		t.Fatalf("加密后长度 %d / %d, 期望相同", len(a), len(b))
+	}
+
+	// 没有本局密钥时无法解密
+	block, _ := aes.NewCipher(bytes.Repeat([]byte{8}, 32))
+	gcm, _ := cipher.NewGCM(block)
+	if _, err := gcm.Open(nil, plan.Nonce, a, nil); err == nil {
+		t.Fatal("用错误的密钥不应解密成功")
+	}
+
+	// play_round 中下发的密钥可以还原
+	p := plan.keyPayload()
+	key, _ := base64.StdEncoding.DecodeString(p["key"].(string))
+	nonce, _ := base64.StdEncoding.DecodeString(p["nonce"].(string))
+	if !bytes.Equal(key, plan.Key) || !bytes.Equal(nonce, plan.Nonce) {
+		t.Fatal("keyPayload 解码后与原密钥不一致")
+	}
+}
+
+func TestPlanNextRoundSkipsWholeSong(t *testing.T) {
+	cases := []struct {
+		clip     bool
+		ffmpeg   bool
+		practice bool
+		planned  bool
+	}{
+		{clip: true, ffmpeg: true, planned: true},
+		{clip: false, ffmpeg: true},  // -audio-clip=false 时下发整首
+		{clip: true, ffmpeg: false},  // 没有 ffmpeg 时下发整首
+		{clip: false, ffmpeg: false}, // 同上
+		{clip: true, ffmpeg: true, practice: true},
+	}
+	for _, c := range cases {
+		setTestAudioClip(t, c.clip)
+		setTestFFmpeg(t, c.ffmpeg)
+		mode, songs := newTestAudioMode(t, 16)
+		room := &Room{ID: "PLAN1", Mode: mode, SongPool: songs, CurrentRound: 3, Players: map[string]*Player{}}
+		if c.practice {
+			room.Practice = &practiceSession{}
+		}
+
+		planNextRoundLocked(room)
+		plan := room.NextRound
+		if !c.planned {
+			if plan != nil {
+				t.Errorf("clip=%v ffmpeg=%v practice=%v: 不应预选下一局", c.clip, c.ffmpeg, c.practice)
+			}
+			continue
+		}
+		if plan == nil {
+			t.Errorf("clip=%v ffmpeg=%v: 应当预选下一局", c.clip, c.ffmpeg)
+			continue
+		}
+		if plan.Round != 4 || plan.Audio.round != 4 {
+			t.Errorf("预选的局数 = %d, 期望 4", plan.Round)
+		}
+		if plan.Audio.offset != plan.StartTime || plan.Audio.duration != plan.PlayDuration+clipMargin {
+			t.Errorf("片段 %d+%d, 期望 %d+%d", plan.Audio.offset, plan.Audio.duration, plan.StartTime, plan.PlayDuration+clipMargin)
+		}
+		if len(plan.Key) != 32 || len(plan.Nonce) != 12 {
+			t.Errorf("密钥 %d 字节 / nonce %d 字节, 期望 32 / 12", len(plan.Key), len(plan.Nonce))
+		}
+
+		// 下一局开始时取出预选，下标指向题目池中的同一首
+		room.CurrentRound = 4
+		got, idx := takeRoundPlanLocked(room)
+		if got != plan || room.SongPool[idx].ID != plan.PoolSong.ID {
+			t.Errorf("takeRoundPlanLocked = %v, %d, 期望取回预选的 %s", got, idx, plan.PoolSong.ID)
+		}
+		if room.NextRound != nil {
+			t.Error("取出后应清空 NextRound")
+		}
+	}
+}
+
+func TestTakeRoundPlanStale(t *testing.T) {
+	setTestAudioClip(t, true)
+	setTestFFmpeg(t, true)
+	mode, songs := newTestAudioMode(t, 16)
+	room := &Room{ID: "PLAN2", Mode: mode, SongPool: songs, CurrentRound: 1, Players: map[string]*Player{}}
+
+	// 局数对不上 (中途重开等) 时不使用预选
+	planNextRoundLocked(room)
+	room.CurrentRound = 5
+	if plan, idx := takeRoundPlanLocked(room); plan != nil || idx != -1 {
+		t.Fatalf("局数不符时 = %v, %d, 期望 nil, -1", plan, idx)
+	}
+
+	// 预选的题目已被移出题目池时重新抽题
+	room.CurrentRound = 1
+	planNextRoundLocked(room)
+	picked := room.NextRound.PoolSong.ID
+	var rest []Song
+	for _, s := range room.SongPool {
+		if s.ID != picked {
+			rest = append(rest, s)
+		}
+	}
+	room.SongPool = rest
+	room.CurrentRound = 2
+	if plan, idx := takeRoundPlanLocked(room); plan != nil || idx != -1 {
+		t.Fatalf("题目已移出题目池时 = %v, %d, 期望 nil, -1", plan, idx)
+	}
+}
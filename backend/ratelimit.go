@@ -0,0 +1,375 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ==========================================
+// WebSocket 消息限流与滥用防护
+// 每个连接、每个 IP 按消息类型各有一个令牌桶；
+// 单连接超限记在该连接上，逐级升级：警告 → 丢弃 → 断开连接 → 多次断开后临时封禁 IP；
+// IP 总量超限 (同一出口下的多个连接合起来刷屏) 记在 IP 上，累计过多直接封禁该 IP，
+// 不连累恰好发出那条消息的正常连接；封禁时断开该 IP 现有的所有连接
+// 一段时间不再超限后从警告重新开始
+// ==========================================
+
+// 单条 WebSocket 消息最大字节数
+const maxWsMessageSize = 4096
+
+// 同一 IP 最多同时保持的连接数（同一局域网的朋友共用出口 IP）
+var maxConnsPerIP = 8
+
+// 不受 IP 级限制 (连接数上限与 IP 令牌桶) 的地址，用于压测机等；单连接限流仍然生效
+var rateLimitExempt []*net.IPNet
+
+func isRateLimitExempt(ip string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, n := range rateLimitExempt {
+		if n.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+type bucketSpec struct {
+	rate  float64 // 每秒补充的令牌数
+	burst float64 // 桶容量
+}
+
+// 每种消息的单连接限额；同一 IP 的总限额为其 ipBucketFactor 倍
+var messageLimits = map[string]bucketSpec{
+	"chat":         {rate: 1, burst: 5},
+	"create_room":  {rate: 0.2, burst: 2},
+	"join_room":    {rate: 0.5, burst: 3},
+	"toggle_ready": {rate: 2, burst: 5},
+	"buzz":         {rate: 2, burst: 4},
+	"no_song":      {rate: 2, burst: 4},
+}
+
+var defaultMessageLimit = bucketSpec{rate: 10, burst: 20}
+
+const ipBucketFactor = 4
+
+// 升级阈值
+const (
+	violationsToDisconnect = 10               // 单连接累计违规次数达到后断开
+	violationQuietPeriod   = 30 * time.Second // 连续这么久没有违规后清零违规次数，下次违规重新警告
+	disconnectsToBan       = 3                // 同一 IP 在窗口内被断开次数达到后封禁
+	ipViolationsToBan      = 30               // IP 总量累计超限次数达到后封禁
+	disconnectWindow       = 10 * time.Minute // 统计断开次数的窗口
+	banDuration            = 10 * time.Minute
+)
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+	spec   bucketSpec
+}
+
+func newTokenBucket(spec bucketSpec) *tokenBucket {
+	return &tokenBucket{tokens: spec.burst, last: time.Now(), spec: spec}
+}
+
+func (b *tokenBucket) allow(now time.Time) bool {
+	// IP 桶由多个连接共用，各自取的 now 可能早于上一次的 last，不能倒扣令牌
+	if now.After(b.last) {
+		b.tokens += now.Sub(b.last).Seconds() * b.spec.rate
+		if b.tokens > b.spec.burst {
+			b.tokens = b.spec.burst
+		}
+		b.last = now
+	}
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// 不在 messageLimits 中的消息类型 (包括无法解析的消息) 共用一个按 defaultMessageLimit 计的桶，
+// 否则客户端每换一种自造的类型名就能拿到一个新桶，桶的数量也会无限增长
+const otherMessagesKey = "*"
+
+func bucketFor(buckets map[string]*tokenBucket, msgType string, factor float64) *tokenBucket {
+	spec, limited := messageLimits[msgType]
+	key := msgType
+	if !limited {
+		spec, key = defaultMessageLimit, otherMessagesKey
+	}
+	b, ok := buckets[key]
+	if !ok {
+		spec.rate *= factor
+		spec.burst *= factor
+		b = newTokenBucket(spec)
+		buckets[key] = b
+	}
+	return b
+}
+
+// limitAction 是对一条消息的处理结论
+type limitAction int
+
+const (
+	limitAllow      limitAction = iota
+	limitWarn                   // 丢弃并警告客户端
+	limitDrop                   // 静默丢弃
+	limitDisconnect             // 断开连接
+)
+
+type ipState struct {
+	conns         int
+	live          map[*connLimiter]bool // 已升级的连接，封禁时逐个断开
+	buckets       map[string]*tokenBucket
+	violations    int // IP 总量超限次数
+	lastViolation time.Time
+	disconnects   []time.Time
+	bannedUntil   time.Time
+}
+
+// abuseStats 暴露给管理接口的累计计数
+type abuseStats struct {
+	Warned        int64 `json:"warned"`
+	Dropped       int64 `json:"dropped"`
+	Disconnected  int64 `json:"disconnected"`
+	Banned        int64 `json:"banned"`
+	RejectedConns int64 `json:"rejectedConns"`
+	OversizedMsgs int64 `json:"oversizedMessages"`
+}
+
+type abuseGuard struct {
+	mu    sync.Mutex
+	ips   map[string]*ipState
+	stats abuseStats
+}
+
+var guard = newAbuseGuard()
+
+func newAbuseGuard() *abuseGuard {
+	g := &abuseGuard{ips: make(map[string]*ipState)}
+	go func() {
+		for range time.Tick(time.Minute) {
+			g.cleanup()
+		}
+	}()
+	return g
+}
+
+// 持有 g.mu
+func (g *abuseGuard) ip(ip string) *ipState {
+	s, ok := g.ips[ip]
+	if !ok {
+		s = &ipState{live: make(map[*connLimiter]bool), buckets: make(map[string]*tokenBucket)}
+		g.ips[ip] = s
+	}
+	return s
+}
+
+// admit 在升级 WebSocket 前检查 IP 是否被封禁或连接数超限，通过时占用一个连接名额
+func (g *abuseGuard) admit(ip string) (bool, string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	s := g.ip(ip)
+	if time.Now().Before(s.bannedUntil) {
+		atomic.AddInt64(&g.stats.RejectedConns, 1)
+		return false, "请求过于频繁，已被暂时封禁"
+	}
+	if s.conns >= maxConnsPerIP && !isRateLimitExempt(ip) {
+		atomic.AddInt64(&g.stats.RejectedConns, 1)
+		return false, "同一 IP 的连接数已达上限"
+	}
+	s.conns++
+	return true, ""
+}
+
+// release 连接关闭时归还连接名额
+func (g *abuseGuard) release(ip string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if s, ok := g.ips[ip]; ok && s.conns > 0 {
+		s.conns--
+	}
+}
+
+// connLimiter 是单个连接的限流状态，只在该连接的读循环里使用 (closeConn 除外)
+type connLimiter struct {
+	ip            string
+	exempt        bool
+	buckets       map[string]*tokenBucket
+	violations    int
+	lastViolation time.Time
+	closeConn     func() // 封禁该 IP 时由其它连接的 goroutine 调用
+}
+
+// newConnLimiter 为刚升级的连接创建限流状态，连接关闭时需调用 forget
+func (g *abuseGuard) newConnLimiter(ip string, closeConn func()) *connLimiter {
+	c := &connLimiter{ip: ip, exempt: isRateLimitExempt(ip), buckets: make(map[string]*tokenBucket), closeConn: closeConn}
+	g.mu.Lock()
+	g.ip(ip).live[c] = true
+	g.mu.Unlock()
+	return c
+}
+
+// forget 连接关闭时不再参与封禁断开
+func (g *abuseGuard) forget(c *connLimiter) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if s, ok := g.ips[c.ip]; ok {
+		delete(s.live, c)
+	}
+}
+
+// check 判断一条消息是否放行，并按违规次数给出升级后的处理方式
+func (g *abuseGuard) check(c *connLimiter, msgType string) limitAction {
+	return g.checkAt(c, msgType, time.Now())
+}
+
+func (g *abuseGuard) checkAt(c *connLimiter, msgType string, now time.Time) limitAction {
+	if !bucketFor(c.buckets, msgType, 1).allow(now) {
+		return g.connViolation(c, now)
+	}
+	if c.exempt {
+		return limitAllow
+	}
+	g.mu.Lock()
+	s := g.ip(c.ip)
+	if bucketFor(s.buckets, msgType, ipBucketFactor).allow(now) {
+		g.mu.Unlock()
+		return limitAllow
+	}
+	action, closers := g.ipViolationLocked(s, c.ip, now)
+	g.mu.Unlock()
+	for _, closeConn := range closers {
+		closeConn()
+	}
+	return action
+}
+
+// connViolation 单连接超限：记在这个连接上，逐级升级到断开
+func (g *abuseGuard) connViolation(c *connLimiter, now time.Time) limitAction {
+	// 偶尔手快超限的正常玩家不会因为一整局里零散的几次超限被断开
+	if now.Sub(c.lastViolation) >= violationQuietPeriod {
+		c.violations = 0
+	}
+	c.lastViolation = now
+	c.violations++
+	switch {
+	case c.violations >= violationsToDisconnect:
+		atomic.AddInt64(&g.stats.Disconnected, 1)
+		g.recordDisconnect(c.ip, now)
+		return limitDisconnect
+	case c.violations == 1:
+		atomic.AddInt64(&g.stats.Warned, 1)
+		return limitWarn
+	default:
+		atomic.AddInt64(&g.stats.Dropped, 1)
+		return limitDrop
+	}
+}
+
+// 持有 g.mu
+// ipViolationLocked IP 总量超限：记在 IP 上，发出这条消息的连接只收到警告或被丢弃消息；
+// 累计过多时封禁该 IP，返回需要断开的连接
+func (g *abuseGuard) ipViolationLocked(s *ipState, ip string, now time.Time) (limitAction, []func()) {
+	if now.Sub(s.lastViolation) >= violationQuietPeriod {
+		s.violations = 0
+	}
+	s.lastViolation = now
+	s.violations++
+	switch {
+	case s.violations >= ipViolationsToBan:
+		s.violations = 0
+		fmt.Printf("IP [%s] 的连接合计持续刷屏，封禁 %v\n", ip, banDuration)
+		return limitDisconnect, g.banLocked(s, now)
+	case s.violations == 1:
+		atomic.AddInt64(&g.stats.Warned, 1)
+		return limitWarn, nil
+	default:
+		atomic.AddInt64(&g.stats.Dropped, 1)
+		return limitDrop, nil
+	}
+}
+
+// 持有 g.mu
+// banLocked 封禁 IP，返回该 IP 现有连接的断开函数，由调用方在释放 g.mu 后调用
+func (g *abuseGuard) banLocked(s *ipState, now time.Time) []func() {
+	s.bannedUntil = now.Add(banDuration)
+	s.disconnects = nil
+	atomic.AddInt64(&g.stats.Banned, 1)
+	closers := make([]func(), 0, len(s.live))
+	for c := range s.live {
+		if c.closeConn != nil {
+			closers = append(closers, c.closeConn)
+		}
+	}
+	return closers
+}
+
+// recordDisconnect 记录一次因滥用被断开，窗口内次数过多则封禁该 IP 并断开其所有连接
+func (g *abuseGuard) recordDisconnect(ip string, now time.Time) {
+	g.mu.Lock()
+	s := g.ip(ip)
+	recent := s.disconnects[:0]
+	for _, t := range s.disconnects {
+		if now.Sub(t) < disconnectWindow {
+			recent = append(recent, t)
+		}
+	}
+	s.disconnects = append(recent, now)
+	var closers []func()
+	if len(s.disconnects) >= disconnectsToBan {
+		closers = g.banLocked(s, now)
+		fmt.Printf("IP [%s] 多次刷屏被断开，封禁 %v\n", ip, banDuration)
+	}
+	g.mu.Unlock()
+	for _, closeConn := range closers {
+		closeConn()
+	}
+}
+
+// noteOversized 统计超过大小限制的消息
+func (g *abuseGuard) noteOversized() {
+	atomic.AddInt64(&g.stats.OversizedMsgs, 1)
+}
+
+// snapshot 返回计数快照以及当前被封禁的 IP 数
+func (g *abuseGuard) snapshot() (abuseStats, int) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	now := time.Now()
+	banned := 0
+	for _, s := range g.ips {
+		if now.Before(s.bannedUntil) {
+			banned++
+		}
+	}
+	return abuseStats{
+		Warned:        atomic.LoadInt64(&g.stats.Warned),
+		Dropped:       atomic.LoadInt64(&g.stats.Dropped),
+		Disconnected:  atomic.LoadInt64(&g.stats.Disconnected),
+		Banned:        atomic.LoadInt64(&g.stats.Banned),
+		RejectedConns: atomic.LoadInt64(&g.stats.RejectedConns),
+		OversizedMsgs: atomic.LoadInt64(&g.stats.OversizedMsgs),
+	}, banned
+}
+
+func (g *abuseGuard) cleanup() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	now := time.Now()
+	for ip, s := range g.ips {
+		if n := len(s.disconnects); n > 0 && now.Sub(s.disconnects[n-1]) >= disconnectWindow {
+			s.disconnects = nil
+		}
+		if s.conns == 0 && len(s.live) == 0 && now.After(s.bannedUntil) && len(s.disconnects) == 0 {
+			delete(g.ips, ip)
+		}
+	}
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newTestGuard 不启动定期清理，避免测试之间互相影响
+func newTestGuard() *abuseGuard {
+	return &abuseGuard{ips: make(map[string]*ipState)}
+}
+
+// newTestConn 创建一个连接的限流状态，返回其被断开的次数
+func newTestConn(g *abuseGuard, ip string) (*connLimiter, *int32) {
+	closed := new(int32)
+	c := g.newConnLimiter(ip, func() { atomic.AddInt32(closed, 1) })
+	return c, closed
+}
+
+func TestConnViolationEscalation(t *testing.T) {
+	g := newTestGuard()
+	c, _ := newTestConn(g, "203.0.113.1")
+	now := time.Now()
+	burst := int(messageLimits["buzz"].burst)
+
+	for i := 0; i < burst; i++ {
+		if got := g.checkAt(c, "buzz", now); got != limitAllow {
+			t.Fatalf("第 %d 条 = %v, 期望放行", i+1, got)
+		}
+	}
+	if got := g.checkAt(c, "buzz", now); got != limitWarn {
+		t.Fatalf("首次超限 = %v, 期望警告", got)
+	}
+	for i := 2; i < violationsToDisconnect; i++ {
+		if got := g.checkAt(c, "buzz", now); got != limitDrop {
+			t.Fatalf("第 %d 次超限 = %v, 期望丢弃", i, got)
+		}
+	}
+	if got := g.checkAt(c, "buzz", now); got != limitDisconnect {
+		t.Fatalf("第 %d 次超限 = %v, 期望断开", violationsToDisconnect, got)
+	}
+	if n := len(g.ips["203.0.113.1"].disconnects); n != 1 {
+		t.Fatalf("IP 记录的断开次数 = %d, 期望 1", n)
+	}
+}
+
+func TestConnViolationQuietPeriodReset(t *testing.T) {
+	g := newTestGuard()
+	c, _ := newTestConn(g, "203.0.113.2")
+	now := time.Now()
+	burst := int(messageLimits["chat"].burst)
+
+	for i := 0; i < burst; i++ {
+		g.checkAt(c, "chat", now)
+	}
+	if got := g.checkAt(c, "chat", now); got != limitWarn {
+		t.Fatalf("首次超限 = %v, 期望警告", got)
+	}
+	if got := g.checkAt(c, "chat", now); got != limitDrop {
+		t.Fatalf("再次超限 = %v, 期望丢弃", got)
+	}
+
+	// 安静期未满：令牌已补满，耗尽后的超限接着计数
+	now = now.Add(violationQuietPeriod / 2)
+	for i := 0; i < burst; i++ {
+		g.checkAt(c, "chat", now)
+	}
+	if got := g.checkAt(c, "chat", now); got != limitDrop {
+		t.Fatalf("安静期内超限 = %v, 期望丢弃", got)
+	}
+	if c.violations != 3 {
+		t.Fatalf("违规次数 = %d, 期望 3", c.violations)
+	}
+
+	// 安静期满后从警告重新开始
+	now = now.Add(violationQuietPeriod)
+	for i := 0; i < burst; i++ {
+		if got := g.checkAt(c, "chat", now); got != limitAllow {
+			t.Fatalf("安静期后第 %d 条 = %v, 期望放行", i+1, got)
+		}
+	}
+	if got := g.checkAt(c, "chat", now); got != limitWarn {
+		t.Fatalf("安静期后首次超限 = %v, 期望重新警告", got)
+	}
+	if c.violations != 1 {
+		t.Fatalf("违规次数 = %d, 期望清零后为 1", c.violations)
+	}
+}
+
+func TestIPOverflowChargedToIP(t *testing.T) {
+	g := newTestGuard()
+	const ip = "198.51.100.7"
+	now := time.Now()
+	perConn := int(messageLimits["buzz"].burst)
+
+	// 同一出口下多个连接各自都没超限，合起来用完 IP 的桶
+	var closed []*int32
+	for i := 0; i < ipBucketFactor; i++ {
+		c, n := newTestConn(g, ip)
+		closed = append(closed, n)
+		for j := 0; j < perConn; j++ {
+			if got := g.checkAt(c, "buzz", now); got != limitAllow {
+				t.Fatalf("连接 %d 第 %d 条 = %v, 期望放行", i, j+1, got)
+			}
+		}
+	}
+
+	// 守规矩的连接各发一条消息就遇上 IP 桶耗尽：消息被拦下，但不记到这些连接上
+	for i := 1; i < ipViolationsToBan; i++ {
+		c, n := newTestConn(g, ip)
+		closed = append(closed, n)
+		want := limitDrop
+		if i == 1 {
+			want = limitWarn
+		}
+		if got := g.checkAt(c, "buzz", now); got != want {
+			t.Fatalf("第 %d 次 IP 超限 = %v, 期望 %v", i, got, want)
+		}
+		if c.violations != 0 {
+			t.Fatalf("连接的违规次数 = %d, IP 总量超限不应记在连接上", c.violations)
+		}
+	}
+	if v := g.ips[ip].violations; v != ipViolationsToBan-1 {
+		t.Fatalf("IP 违规次数 = %d, 期望 %d", v, ipViolationsToBan-1)
+	}
+	for i, n := range closed {
+		if atomic.LoadInt32(n) != 0 {
+			t.Fatalf("封禁前连接 %d 就被断开了", i)
+		}
+	}
+
+	// 累计到阈值后封禁 IP，并断开该 IP 的所有连接
+	last, lastClosed := newTestConn(g, ip)
+	closed = append(closed, lastClosed)
+	if got := g.checkAt(last, "buzz", now); got != limitDisconnect {
+		t.Fatalf("达到封禁阈值 = %v, 期望断开", got)
+	}
+	for i, n := range closed {
+		if atomic.LoadInt32(n) != 1 {
+			t.Fatalf("连接 %d 断开次数 = %d, 封禁时应断开该 IP 的所有连接", i, atomic.LoadInt32(n))
+		}
+	}
+	if ok, _ := g.admit(ip); ok {
+		t.Fatal("封禁期间不应允许新连接")
+	}
+}
+
+func TestIPOverflowQuietPeriodReset(t *testing.T) {
+	g := newTestGuard()
+	const ip = "198.51.100.8"
+	now := time.Now()
+	c, _ := newTestConn(g, ip)
+	s := g.ip(ip)
+	s.buckets[otherMessagesKey] = &tokenBucket{last: now, spec: bucketSpec{rate: 0, burst: 1}}
+
+	if got := g.checkAt(c, "ping", now); got != limitWarn {
+		t.Fatalf("IP 首次超限 = %v, 期望警告", got)
+	}
+	if got := g.checkAt(c, "ping", now.Add(time.Second)); got != limitDrop {
+		t.Fatalf("IP 再次超限 = %v, 期望丢弃", got)
+	}
+	if got := g.checkAt(c, "ping", now.Add(time.Second+violationQuietPeriod)); got != limitWarn {
+		t.Fatalf("安静期后 IP 超限 = %v, 期望重新警告", got)
+	}
+	if s.violations != 1 {
+		t.Fatalf("IP 违规次数 = %d, 期望清零后为 1", s.violations)
+	}
+}
+
+func TestRepeatedDisconnectsBanAndCloseLiveConns(t *testing.T) {
+	g := newTestGuard()
+	const ip = "203.0.113.9"
+	now := time.Now()
+	bystander, bystanderClosed := newTestConn(g, ip)
+
+	for round := 0; round < disconnectsToBan; round++ {
+		c, _ := newTestConn(g, ip)
+		var last limitAction
+		for i := 0; i < int(messageLimits["chat"].burst)+violationsToDisconnect; i++ {
+			last = g.checkAt(c, "chat", now)
+		}
+		if last != limitDisconnect {
+			t.Fatalf("第 %d 个刷屏连接最后 = %v, 期望断开", round+1, last)
+		}
+		g.forget(c)
+	}
+	if !now.Before(g.ips[ip].bannedUntil) {
+		t.Fatal("多次断开后应封禁该 IP")
+	}
+	if atomic.LoadInt32(bystanderClosed) != 1 {
+		t.Fatal("封禁时应断开该 IP 现有的连接")
+	}
+	g.forget(bystander)
+	if len(g.ips[ip].live) != 0 {
+		t.Fatalf("forget 后仍有 %d 个连接", len(g.ips[ip].live))
+	}
+}
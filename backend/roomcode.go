@@ -0,0 +1,187 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ==========================================
+// 房间号生成与私密房间
+// ==========================================
+
+// 默认字母表去掉了容易混淆的 0/O、1/I/L
+const defaultRoomCodeAlphabet = "23456789ABCDEFGHJKMNPQRSTUVWXYZ"
+
+// 容易被看错的字符，自定义字母表时同样会被剔除
+const confusableChars = "0O1IL"
+
+var errRoomCodeExhausted = errors.New("多次尝试后仍未找到可用的房间号")
+
+// roomCodeGenerator 生成随机房间号，重试次数有上限
+type roomCodeGenerator struct {
+	Alphabet   string
+	Length     int
+	MaxRetries int
+}
+
+var roomCodes = newRoomCodeGenerator(defaultRoomCodeAlphabet, 5)
+
+func newRoomCodeGenerator(alphabet string, length int) *roomCodeGenerator {
+	var sb strings.Builder
+	seen := make(map[rune]bool)
+	for _, c := range strings.ToUpper(alphabet) {
+		if seen[c] || strings.ContainsRune(confusableChars, c) {
+			continue
+		}
+		seen[c] = true
+		sb.WriteRune(c)
+	}
+	return &roomCodeGenerator{Alphabet: sb.String(), Length: length, MaxRetries: 32}
+}
+
+// validate 检查字母表与长度是否能产生足够多的房间号
+func (g *roomCodeGenerator) validate() error {
+	if len([]rune(g.Alphabet)) < 2 {
+		return fmt.Errorf("房间号字母表至少需要 2 个可用字符")
+	}
+	if g.Length < 3 {
+		return fmt.Errorf("房间号长度至少为 3")
+	}
+	return nil
+}
+
+// next 生成一个房间号，inUse 返回 true 表示已被占用
+func (g *roomCodeGenerator) next(inUse func(string) (bool, error)) (string, error) {
+	alphabet := []rune(g.Alphabet)
+	max := big.NewInt(int64(len(alphabet)))
+	code := make([]rune, g.Length)
+	for attempt := 0; attempt < g.MaxRetries; attempt++ {
+		for i := range code {
+			n, err := rand.Int(rand.Reader, max)
+			if err != nil {
+				return "", err
+			}
+			code[i] = alphabet[n.Int64()]
+		}
+		id := string(code)
+		taken, err := inUse(id)
+		if err != nil {
+			return "", err
+		}
+		if !taken {
+			return id, nil
+		}
+	}
+	return "", errRoomCodeExhausted
+}
+
+// normalizeRoomID 统一玩家输入的房间号（去空格、转大写）
+func normalizeRoomID(id string) string {
+	return strings.ToUpper(strings.TrimSpace(id))
+}
+
+// newInviteToken 生成私密房间的邀请码
+func newInviteToken() string {
+	buf := make([]byte, 12)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+func hashRoomPassword(password string) []byte {
+	sum := sha256.Sum256([]byte(password))
+	return sum[:]
+}
+
+// canJoinPrivate 校验私密房间的密码或邀请码
+// 调用时需持有 room.Mutex
+func canJoinPrivate(room *Room, password, inviteToken string) bool {
+	if !room.Private {
+		return true
+	}
+	if inviteToken != "" && subtle.ConstantTimeCompare([]byte(inviteToken), []byte(room.InviteToken)) == 1 {
+		return true
+	}
+	if room.PasswordHash != nil && password != "" {
+		return subtle.ConstantTimeCompare(hashRoomPassword(password), room.PasswordHash) == 1
+	}
+	return false
+}
+
+// ==========================================
+// join_room 失败次数限制，防止枚举房间号
+// ==========================================
+
+type joinAttempts struct {
+	failures     int
+	windowStart  time.Time
+	blockedUntil time.Time
+}
+
+type joinLimiter struct {
+	mu          sync.Mutex
+	attempts    map[string]*joinAttempts
+	maxFailures int
+	window      time.Duration
+	blockFor    time.Duration
+}
+
+var joinGuard = newJoinLimiter(8, time.Minute, 5*time.Minute)
+
+func newJoinLimiter(maxFailures int, window, blockFor time.Duration) *joinLimiter {
+	l := &joinLimiter{
+		attempts:    make(map[string]*joinAttempts),
+		maxFailures: maxFailures,
+		window:      window,
+		blockFor:    blockFor,
+	}
+	go func() {
+		for range time.Tick(time.Minute) {
+			l.cleanup()
+		}
+	}()
+	return l
+}
+
+// blocked 返回该 IP 当前是否被禁止加入房间
+func (l *joinLimiter) blocked(ip string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	a, ok := l.attempts[ip]
+	return ok && time.Now().Before(a.blockedUntil)
+}
+
+// fail 记录一次失败的加入尝试
+func (l *joinLimiter) fail(ip string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	a, ok := l.attempts[ip]
+	if !ok || now.Sub(a.windowStart) > l.window {
+		a = &joinAttempts{windowStart: now}
+		l.attempts[ip] = a
+	}
+	a.failures++
+	if a.failures >= l.maxFailures {
+		a.blockedUntil = now.Add(l.blockFor)
+		fmt.Printf("IP [%s] 加入房间失败次数过多，暂时封禁 %v\n", ip, l.blockFor)
+	}
+}
+
+func (l *joinLimiter) cleanup() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	for ip, a := range l.attempts {
+		if now.Sub(a.windowStart) > l.window && now.After(a.blockedUntil) {
+			delete(l.attempts, ip)
+		}
+	}
+}
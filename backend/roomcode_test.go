@@ -0,0 +1,150 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestNewRoomCodeGeneratorAlphabet(t *testing.T) {
+	cases := []struct {
+		alphabet string
+		want     string
+	}{
+		{defaultRoomCodeAlphabet, defaultRoomCodeAlphabet},
+		{"abc", "ABC"},
+		{"AaBb", "AB"},        // 转大写后去重
+		{"0O1IL2345", "2345"}, // 剔除容易混淆的字符
+		{"ol01i", ""},         // 全部被剔除
+		{"房间ABC", "房间ABC"},    // 按字符而非字节处理
+		{"A B", "A B"},        // 其余字符原样保留
+		{"zzZZyy", "ZY"},
+	}
+	for _, c := range cases {
+		if got := newRoomCodeGenerator(c.alphabet, 5).Alphabet; got != c.want {
+			t.Errorf("newRoomCodeGenerator(%q).Alphabet = %q, 期望 %q", c.alphabet, got, c.want)
+		}
+	}
+}
+
+func TestRoomCodeGeneratorValidate(t *testing.T) {
+	cases := []struct {
+		alphabet string
+		length   int
+		errHas   string
+	}{
+		{defaultRoomCodeAlphabet, 5, ""},
+		{"AB", 3, ""},
+		{"A", 5, "字母表"},
+		{"0O1IL", 5, "字母表"},
+		{defaultRoomCodeAlphabet, 2, "长度"},
+	}
+	for _, c := range cases {
+		err := newRoomCodeGenerator(c.alphabet, c.length).validate()
+		if c.errHas == "" {
+			if err != nil {
+				t.Errorf("validate(%q, %d) 出错: %v", c.alphabet, c.length, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), c.errHas) {
+			t.Errorf("validate(%q, %d) = %v, 期望包含 %q", c.alphabet, c.length, err, c.errHas)
+		}
+	}
+}
+
+func TestRoomCodeGeneratorNext(t *testing.T) {
+	g := newRoomCodeGenerator(defaultRoomCodeAlphabet, 6)
+	for i := 0; i < 200; i++ {
+		code, err := g.next(func(string) (bool, error) { return false, nil })
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(code) != 6 {
+			t.Fatalf("房间号 %q 长度应为 6", code)
+		}
+		for _, c := range code {
+			if !strings.ContainsRune(g.Alphabet, c) {
+				t.Fatalf("房间号 %q 含有字母表以外的字符 %q", code, c)
+			}
+		}
+	}
+}
+
+func TestRoomCodeGeneratorRetry(t *testing.T) {
+	g := newRoomCodeGenerator(defaultRoomCodeAlphabet, 5)
+
+	// 前几次撞上已占用的房间号时重试
+	tries := 0
+	code, err := g.next(func(string) (bool, error) {
+		tries++
+		return tries <= 3, nil
+	})
+	if err != nil || code == "" {
+		t.Fatalf("重试后应得到房间号, got %q, %v", code, err)
+	}
+	if tries != 4 {
+		t.Fatalf("查询次数 = %d, 期望 4", tries)
+	}
+
+	// 始终被占用时在重试上限处放弃
+	tries = 0
+	_, err = g.next(func(string) (bool, error) {
+		tries++
+		return true, nil
+	})
+	if !errors.Is(err, errRoomCodeExhausted) {
+		t.Fatalf("全部被占用时 err = %v, 期望 errRoomCodeExhausted", err)
+	}
+	if tries != g.MaxRetries {
+		t.Fatalf("查询次数 = %d, 期望 %d", tries, g.MaxRetries)
+	}
+
+	// 房间目录出错时直接返回，不再重试
+	tries = 0
+	dirErr := errors.New("redis 不可用")
+	_, err = g.next(func(string) (bool, error) {
+		tries++
+		return false, dirErr
+	})
+	if !errors.Is(err, dirErr) || tries != 1 {
+		t.Fatalf("目录出错时 err = %v, 查询 %d 次, 期望原样返回且只查询 1 次", err, tries)
+	}
+}
+
+func TestCanJoinPrivate(t *testing.T) {
+	room := &Room{
+		Private:      true,
+		PasswordHash: hashRoomPassword("秘密 123"),
+		InviteToken:  newInviteToken(),
+	}
+	noPassword := &Room{Private: true, InviteToken: newInviteToken()}
+
+	cases := []struct {
+		name     string
+		room     *Room
+		password string
+		invite   string
+		want     bool
+	}{
+		{"公开房间", &Room{}, "", "", true},
+		{"密码正确", room, "秘密 123", "", true},
+		{"密码错误", room, "秘密 124", "", false},
+		{"密码多了空格", room, "秘密 123 ", "", false},
+		{"未提供凭据", room, "", "", false},
+		{"邀请码正确", room, "", room.InviteToken, true},
+		{"邀请码错误", room, "", noPassword.InviteToken, false},
+		{"邀请码错误但密码正确", room, "秘密 123", "bad", true},
+		{"未设密码且未提供凭据", noPassword, "", "", false},
+		{"未设密码时提供密码也不行", noPassword, "x", "", false},
+	}
+	for _, c := range cases {
+		if got := canJoinPrivate(c.room, c.password, c.invite); got != c.want {
+			t.Errorf("%s: canJoinPrivate = %v, 期望 %v", c.name, got, c.want)
+		}
+	}
+
+	if len(room.InviteToken) != 24 || room.InviteToken == noPassword.InviteToken {
+		t.Fatalf("邀请码 %q / %q 应为两个不同的 24 位十六进制串", room.InviteToken, noPassword.InviteToken)
+	}
+}
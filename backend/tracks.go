@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+)
+
+// ==========================================
+// 多曲目轮换 (allTracks)
+// 默认每张牌在开局时固定一段音频；开启后每局都从该牌的全部曲目中重新抽取，
+// 优先抽本局还没播过的曲目，全部播过后再从头轮换 (尽量不与上一次相同)
+// 练习模式下则把每首曲目都作为独立的练习题
+// ==========================================
+
+// supportsAllTracks 返回模式是否有一张牌对应多段音频的题目
+func supportsAllTracks(mode Mode) bool {
+	_, ok := mode.(multiTrackMode)
+	return ok
+}
+
+func trackKey(s Song) string {
+	return fmt.Sprintf("%s:%d:%s", s.Mode, s.CharacterID, s.ID)
+}
+
+// 持有 room.Mutex
+// rotateTrackLocked 为本局题目重新抽取曲目，并记入 room.PlayedTracks
+func rotateTrackLocked(room *Room) {
+	if !room.AllTracks || room.CurrentSong == nil {
+		return
+	}
+	setCurrentTrackLocked(room, pickTrackLocked(room, *room.CurrentSong))
+}
+
+// 持有 room.Mutex
+// pickTrackLocked 为题目 s 抽取要播放的曲目，不修改房间状态；模式不支持或未开启 allTracks 时原样返回
+func pickTrackLocked(room *Room, s Song) Song {
+	mt, ok := room.Mode.(multiTrackMode)
+	if !ok || !room.AllTracks {
+		return s
+	}
+	tracks := mt.Tracks(s, room.Filter)
+
+	var fresh, others []Song
+	for _, t := range tracks {
+		if !room.PlayedTracks[trackKey(t)] {
+			fresh = append(fresh, t)
+		} else if t.ID != s.ID {
+			others = append(others, t)
+		}
+	}
+	candidates := fresh
+	if len(candidates) == 0 {
+		candidates = others
+	}
+	if len(candidates) == 0 {
+		candidates = tracks
+	}
+	if len(candidates) == 0 {
+		return s
+	}
+	return candidates[rand.Intn(len(candidates))]
+}
+
+// 持有 room.Mutex
+// setCurrentTrackLocked 把本局题目换成曲目 t，并记入 room.PlayedTracks
+func setCurrentTrackLocked(room *Room, t Song) {
+	room.PlayedTracks[trackKey(t)] = true
+	if idx := room.CurrentSongIndex; idx >= 0 && idx < len(room.SongPool) {
+		room.SongPool[idx] = t
+	}
+	room.CurrentSong = &t
+}
+
+// expandTracks 把题目池展开为每首曲目一道题，供练习模式使用
+func expandTracks(mode Mode, pool []Song, filter *songFilter) []Song {
+	mt, ok := mode.(multiTrackMode)
+	if !ok {
+		return pool
+	}
+	out := make([]Song, 0, len(pool))
+	for _, s := range pool {
+		out = append(out, mt.Tracks(s, filter)...)
+	}
+	return out
+}
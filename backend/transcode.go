@@ -0,0 +1,243 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// ==========================================
+// 音频转码与图片编码 (依赖外部 ffmpeg / ffprobe)
+// 上传的音频统一转成 AAC/m4a 保存；播放时再按客户端支持的格式生成响度统一的变体 (见 audio.go)
+// 服务器未安装 ffmpeg 时只接受本身就是 m4a 的音频，播放时直接输出源文件
+// ==========================================
+
+var (
+	ffmpegPath  = "ffmpeg"
+	ffprobePath = "ffprobe"
+)
+
+// 单个文件转码/探测的超时
+const transcodeTimeout = 2 * time.Minute
+
+// haveFFmpeg 返回 ffmpeg 与 ffprobe 是否都可用
+func haveFFmpeg() bool {
+	if _, err := exec.LookPath(ffmpegPath); err != nil {
+		return false
+	}
+	_, err := exec.LookPath(ffprobePath)
+	return err == nil
+}
+
+// probeDuration 用 ffprobe 读取音频时长 (秒)；无法识别为音频时返回错误
+func probeDuration(path string) (float64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), transcodeTimeout)
+	defer cancel()
+	out, err := runTool(ctx, ffprobePath,
+		"-v", "error",
+		"-select_streams", "a:0",
+		"-show_entries", "format=duration",
+		"-of", "default=noprint_wrappers=1:nokey=1",
+		path)
+	if err != nil {
+		return 0, err
+	}
+	d, err := strconv.ParseFloat(strings.TrimSpace(out), 64)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("无法识别音频时长")
+	}
+	return d, nil
+}
+
+// transcodeToM4A 把任意 ffmpeg 能解码的音频转成 128k AAC，并去掉元数据与封面流
+func transcodeToM4A(src, dst string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), transcodeTimeout)
+	defer cancel()
+	_, err := runTool(ctx, ffmpegPath,
+		"-nostdin", "-hide_banner", "-loglevel", "error", "-y",
+		"-i", src,
+		"-vn", "-map_metadata", "-1",
+		"-c:a", "aac", "-b:a", "128k",
+		"-movflags", "+faststart",
+		"-f", "mp4", dst)
+	return err
+}
+
+// transcodeAudio 把源音频转成 format 对应的编码，loudness 不为 0 时按 EBU R128
+// 单遍 loudnorm 统一到该响度 (LUFS)
+func transcodeAudio(src, dst string, format audioFormat, loudness float64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), transcodeTimeout)
+	defer cancel()
+	args := []string{"-nostdin", "-hide_banner", "-loglevel", "error", "-y", "-i", src, "-vn", "-map_metadata", "-1"}
+	if loudness != 0 {
+		// loudnorm 内部会升采样到 192kHz，输出时需指定采样率
+		args = append(args, "-af", fmt.Sprintf("loudnorm=I=%g:TP=-1.5:LRA=11", loudness))
+	}
+	args = append(args, "-ar", "48000")
+	args = append(args, format.args...)
+	_, err := runTool(ctx, ffmpegPath, append(args, dst)...)
+	return err
+}
+
+// transcodeClip 从 src 的第 offset 秒起截取 duration 秒，经 filter_complex 滤镜图 (输出标签 [out])
+// 处理后按 format 编码
+func transcodeClip(src, dst string, format audioFormat, offset, duration int, filter string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), transcodeTimeout)
+	defer cancel()
+	args := []string{"-nostdin", "-hide_banner", "-loglevel", "error", "-y",
+		"-ss", strconv.Itoa(offset), "-t", strconv.Itoa(duration), "-i", src,
+		"-filter_complex", filter, "-map", "[out]", "-map_metadata", "-1", "-ar", "48000"}
+	args = append(args, format.args...)
+	_, err := runTool(ctx, ffmpegPath, append(args, dst)...)
+	return err
+}
+
+// cutAudio 从 src 的第 offset 秒起截取 duration 秒，直接复制音频流不重新编码
+func cutAudio(src, dst, muxer string, offset, duration int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), transcodeTimeout)
+	defer cancel()
+	args := []string{"-nostdin", "-hide_banner", "-loglevel", "error", "-y",
+		"-ss", strconv.Itoa(offset), "-t", strconv.Itoa(duration), "-i", src,
+		"-map", "0:a:0", "-c", "copy", "-map_metadata", "-1"}
+	if muxer == "mp4" {
+		args = append(args, "-movflags", "+faststart")
+	}
+	_, err := runTool(ctx, ffmpegPath, append(args, "-f", muxer, dst)...)
+	return err
+}
+
+// encodeImage 把 PNG 编码为 WebP 或 AVIF (需要 ffmpeg 带 libwebp / libaom)
+func encodeImage(src, dst string, format imageFormat) error {
+	ctx, cancel := context.WithTimeout(context.Background(), transcodeTimeout)
+	defer cancel()
+	args := []string{"-nostdin", "-hide_banner", "-loglevel", "error", "-y", "-i", src}
+	switch format {
+	case formatWebP:
+		args = append(args, "-c:v", "libwebp", "-quality", "80", "-f", "webp")
+	case formatAVIF:
+		args = append(args, "-c:v", "libaom-av1", "-still-picture", "1", "-crf", "32", "-f", "avif")
+	default:
+		return fmt.Errorf("不支持的图片格式 %s", format.ext)
+	}
+	_, err := runTool(ctx, ffmpegPath, append(args, dst)...)
+	return err
+}
+
+// variantBuilds 保证同一个缓存文件在并发请求时只生成一次
+type variantBuilds struct {
+	sync.Mutex
+	inflight map[string]chan struct{}
+}
+
+func newVariantBuilds() *variantBuilds {
+	return &variantBuilds{inflight: make(map[string]chan struct{})}
+}
+
+// build 在 out 不存在时调用 generate 生成，同时到达的其它请求等待第一个请求的结果
+func (b *variantBuilds) build(out string, generate func() error) error {
+	if _, err := os.Stat(out); err == nil {
+		return nil
+	}
+	b.Lock()
+	done, busy := b.inflight[out]
+	if !busy {
+		done = make(chan struct{})
+		b.inflight[out] = done
+	}
+	b.Unlock()
+	if busy {
+		<-done
+		if _, err := os.Stat(out); err != nil {
+			return fmt.Errorf("并发生成失败")
+		}
+		return nil
+	}
+	defer func() {
+		b.Lock()
+		close(b.inflight[out])
+		delete(b.inflight, out)
+		b.Unlock()
+	}()
+	// 等锁期间上一个生成者可能刚好完成
+	if _, err := os.Stat(out); err == nil {
+		return nil
+	}
+	return generate()
+}
+
+// errEncoderMissing 表示 ffmpeg 缺少所需的编码器或封装格式，换哪个文件都不会成功；
+// 其余失败 (源文件损坏、超时等) 只影响当前文件
+var errEncoderMissing = errors.New("ffmpeg 不支持该编码")
+
+// ffmpeg 在缺少编码器或封装格式时的报错 (不同版本措辞不同)
+var missingEncoderMessages = []string{
+	"Unknown encoder",
+	"Encoder not found",
+	"Unknown output format",
+	"Requested output format",
+}
+
+func isMissingEncoder(stderr string) bool {
+	for _, m := range missingEncoderMessages {
+		if strings.Contains(stderr, m) {
+			return true
+		}
+	}
+	// 较新的版本: "Encoder libopus not found"
+	return strings.Contains(stderr, "Encoder ") && strings.Contains(stderr, " not found")
+}
+
+func runTool(ctx context.Context, name string, args ...string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return "", fmt.Errorf("%s 超时", name)
+		}
+		// 报错原因在输出末尾 (前面是版本信息与流信息)：按完整输出判断，错误信息只保留末尾
+		full := strings.TrimSpace(stderr.String())
+		msg := stderrTail(full, 200)
+		if isMissingEncoder(full) {
+			return "", fmt.Errorf("%s 失败: %w: %s", name, errEncoderMissing, msg)
+		}
+		return "", fmt.Errorf("%s 失败: %v %s", name, err, msg)
+	}
+	return stdout.String(), nil
+}
+
+// stderrTail 返回 s 末尾至多 n 字节，不截断 UTF-8 字符
+func stderrTail(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	i := len(s) - n
+	for i < len(s) && !utf8.RuneStart(s[i]) {
+		i++
+	}
+	return s[i:]
+}
+
+// sniffM4A 检查文件开头是否为 ISO BMFF 的 ftyp 盒 (m4a/mp4)
+func sniffM4A(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	head := make([]byte, 12)
+	if _, err := io.ReadFull(f, head); err != nil {
+		return false
+	}
+	return string(head[4:8]) == "ftyp"
+}